@@ -0,0 +1,137 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// ARP packet constants for IPv4 over Ethernet
+const (
+	ethPArp       = 0x0806
+	ethPIP        = 0x0800
+	arpHrdEther   = 1
+	arpOpRequest  = 1
+	arpOpReply    = 2
+	arpPacketSize = 28
+	ethHeaderSize = 14
+)
+
+// gatewayProbeAttempts is the number of ARP requests sent
+// to the gateway before it is considered unreachable
+const gatewayProbeAttempts = 3
+
+// ProbeGateway sends ARP requests for the gateway from the pod
+// interface in the pod network namespace and returns an error
+// unless the gateway answers within the timeout
+func ProbeGateway(netns string, ifName string, gateway string, timeout time.Duration) error {
+
+	gw := net.ParseIP(gateway).To4()
+	if gw == nil {
+		return fmt.Errorf("Invalid gateway address %q", gateway)
+	}
+
+	return ns.WithNetNSPath(netns, func(ns.NetNS) error {
+
+		link, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("Failed to lookup %q: %v", ifName, err)
+		}
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		if err != nil || len(addrs) == 0 {
+			return fmt.Errorf("No IPv4 address on %q: %v", ifName, err)
+		}
+		srcIP := addrs[0].IPNet.IP.To4()
+		srcMAC := link.Attrs().HardwareAddr
+
+		fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(ethPArp)))
+		if err != nil {
+			return fmt.Errorf("Error opening ARP socket: %v", err)
+		}
+		defer syscall.Close(fd)
+
+		addr := &syscall.SockaddrLinklayer{Protocol: htons(ethPArp), Ifindex: link.Attrs().Index}
+		if err = syscall.Bind(fd, addr); err != nil {
+			return fmt.Errorf("Error binding ARP socket to %q: %v", ifName, err)
+		}
+
+		wait := timeout / gatewayProbeAttempts
+		tv := syscall.NsecToTimeval(int64(wait))
+		if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return fmt.Errorf("Error setting ARP socket timeout: %v", err)
+		}
+
+		request := arpRequest(srcMAC, srcIP, gw)
+		broadcast := &syscall.SockaddrLinklayer{
+			Protocol: htons(ethPArp),
+			Ifindex:  link.Attrs().Index,
+			Halen:    6,
+			Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		}
+
+		buf := make([]byte, 1500)
+		for attempt := 1; attempt <= gatewayProbeAttempts; attempt++ {
+			if err = syscall.Sendto(fd, request, 0, broadcast); err != nil {
+				return fmt.Errorf("Error sending ARP request for %s on %q: %v", gateway, ifName, err)
+			}
+			deadline := time.Now().Add(wait)
+			for time.Now().Before(deadline) {
+				n, _, err := syscall.Recvfrom(fd, buf, 0)
+				if err != nil {
+					break
+				}
+				if isARPReplyFrom(buf[:n], gw, srcIP) {
+					log.Debugf("Gateway %s answered ARP request on %q after %d attempt(s)", gateway, ifName, attempt)
+					return nil
+				}
+			}
+		}
+
+		return fmt.Errorf("Gateway %s did not answer ARP requests on %q within %v", gateway, ifName, timeout)
+	})
+}
+
+// arpRequest builds an Ethernet frame carrying an ARP request
+func arpRequest(srcMAC net.HardwareAddr, srcIP net.IP, targetIP net.IP) []byte {
+
+	frame := make([]byte, ethHeaderSize+arpPacketSize)
+	copy(frame[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(frame[6:12], srcMAC)
+	binary.BigEndian.PutUint16(frame[12:14], ethPArp)
+
+	arp := frame[ethHeaderSize:]
+	binary.BigEndian.PutUint16(arp[0:2], arpHrdEther)
+	binary.BigEndian.PutUint16(arp[2:4], ethPIP)
+	arp[4] = 6
+	arp[5] = 4
+	binary.BigEndian.PutUint16(arp[6:8], arpOpRequest)
+	copy(arp[8:14], srcMAC)
+	copy(arp[14:18], srcIP.To4())
+	copy(arp[24:28], targetIP.To4())
+
+	return frame
+}
+
+// isARPReplyFrom reports whether the frame is an ARP
+// reply of the target to the source address
+func isARPReplyFrom(frame []byte, targetIP net.IP, srcIP net.IP) bool {
+
+	if len(frame) < ethHeaderSize+arpPacketSize || binary.BigEndian.Uint16(frame[12:14]) != ethPArp {
+		return false
+	}
+	arp := frame[ethHeaderSize:]
+	return binary.BigEndian.Uint16(arp[6:8]) == arpOpReply &&
+		bytes.Equal(arp[14:18], targetIP.To4()) &&
+		bytes.Equal(arp[24:28], srcIP.To4())
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"net"
+	"testing"
+)
+
+func TestARPReply(t *testing.T) {
+
+	mac, _ := net.ParseMAC("0a:58:0a:0a:00:05")
+	gwMAC, _ := net.ParseMAC("0a:58:0a:0a:00:01")
+	srcIP := net.ParseIP("10.0.0.5")
+	gateway := net.ParseIP("10.0.0.1")
+
+	// A reply is the request with the roles swapped
+	reply := func(from net.IP, to net.IP, op byte) []byte {
+		frame := arpRequest(gwMAC, from, to)
+		frame[ethHeaderSize+7] = op
+		return frame
+	}
+
+	tests := []struct {
+		name  string
+		frame []byte
+		want  bool
+	}{
+		{name: "reply from gateway", frame: reply(gateway, srcIP, arpOpReply), want: true},
+		{name: "own request", frame: arpRequest(mac, srcIP, gateway)},
+		{name: "request from gateway", frame: reply(gateway, srcIP, arpOpRequest)},
+		{name: "reply from another host", frame: reply(net.ParseIP("10.0.0.7"), srcIP, arpOpReply)},
+		{name: "reply to another host", frame: reply(gateway, net.ParseIP("10.0.0.7"), arpOpReply)},
+		{name: "truncated frame", frame: reply(gateway, srcIP, arpOpReply)[:20]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isARPReplyFrom(test.frame, gateway, srcIP); got != test.want {
+				t.Errorf("expected %t, got %t", test.want, got)
+			}
+		})
+	}
+}
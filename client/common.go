@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/containernetworking/cni/pkg/ip"
@@ -17,6 +18,8 @@ import (
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	vrsSdk "github.com/nuagenetworks/libvrsdk/api"
+	"github.com/nuagenetworks/libvrsdk/api/entity"
+	"github.com/nuagenetworks/libvrsdk/api/port"
 	"github.com/nuagenetworks/nuage-cni/config"
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
@@ -151,14 +154,14 @@ func AssignIPToContainerIntf(netns string, containerInfo map[string]string) (*ty
 
 // ConnectToVRSOVSDB will try connecting to VRS OVSDB via unix socket
 // connection
-func ConnectToVRSOVSDB(conf *config.Config) (vrsSdk.VRSConnection, error) {
+func ConnectToVRSOVSDB(conf *config.Config) (VRSConnection, error) {
 
 	vrsConnection, err := vrsSdk.NewUnixSocketConnection(conf.VRSEndpoint)
 	if err != nil {
-		return vrsConnection, fmt.Errorf("Couldn't connect to VRS: %s", err)
+		return nil, fmt.Errorf("Couldn't connect to VRS: %s", err)
 	}
 
-	return vrsConnection, nil
+	return &vrsConnection, nil
 }
 
 // DeleteVethPair will help user delete veth pairs on VRS
@@ -179,6 +182,25 @@ func DeleteVethPair(brPort string, entityPort string) error {
 	return nil
 }
 
+// DeleteHostVeth deletes a veth pair using
+// its host end interface name
+func DeleteHostVeth(name string) error {
+
+	log.Debugf("Deleting veth paired port %s as a part of Nuage CNI cleanup", name)
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", name, err)
+	}
+
+	err = netlink.LinkDel(link)
+	if err != nil {
+		log.Errorf("Deleting veth pair %s failed with error: %s", name, err)
+		return err
+	}
+
+	return nil
+}
+
 // GetNuagePortName creates a unique port name
 // for container host port entry
 func GetNuagePortName(uuid string) string {
@@ -190,6 +212,24 @@ func GetNuagePortName(uuid string) string {
 	return nuagePortName
 }
 
+// GetEntityName returns the VRS entity name for a pod which is
+// unique across namespaces. K8S namespace and pod names can not
+// contain the separator so the name can be split back
+func GetEntityName(podNs string, podName string) string {
+	return podNs + EntityNameSeparator + podName
+}
+
+// ParseEntityName returns pod namespace and name from VRS entity name.
+// Entities created by earlier plugin versions are named after the pod
+// only and an empty namespace is returned for them
+func ParseEntityName(entityName string) (podNs string, podName string) {
+	parts := strings.SplitN(entityName, EntityNameSeparator, 2)
+	if len(parts) != 2 {
+		return "", entityName
+	}
+	return parts[0], parts[1]
+}
+
 // generateVEthString generates a unique SHA encoded
 // string for veth Nuage host port
 func generateVEthString(uuid string) string {
@@ -251,6 +291,86 @@ func GetContainerNuageMetadata(nuageMetadata *NuageMetadata, args *skel.CmdArgs)
 	return err
 }
 
+// GetPortStateMetadata returns the domain, zone and subnet
+// a port was resolved in as reported by VRS port state
+func GetPortStateMetadata(portState map[port.StateKey]interface{}) NuageMetadata {
+
+	nuageMetadata := NuageMetadata{}
+	nuageMetadata.Domain, _ = portState[port.StateKeyNuageDomain].(string)
+	nuageMetadata.Zone, _ = portState[port.StateKeyNuageZone].(string)
+	nuageMetadata.Network, _ = portState[port.StateKeyNuageNetwork].(string)
+
+	return nuageMetadata
+}
+
+// PortResolutionFailed reports whether VRS port state shows that
+// the controller handled the port without assigning it an address,
+// which is what happens when the subnet is out of addresses
+func PortResolutionFailed(portState map[port.StateKey]interface{}) bool {
+
+	if ip, _ := portState[port.StateKeyIPAddress].(string); ip != "" {
+		return false
+	}
+
+	return isPortStateSet(portState[port.StateKeyVrfID]) || isPortStateSet(portState[port.StateKeyEvpnID])
+}
+
+func isPortStateSet(value interface{}) bool {
+	switch v := value.(type) {
+	case float64:
+		return v != 0
+	case int:
+		return v != 0
+	case int64:
+		return v != 0
+	case string:
+		return v != "" && v != "0"
+	}
+	return false
+}
+
+// GetPortMetadata builds Nuage port table metadata
+// for an entity port from Nuage metadata
+func GetPortMetadata(nuageMetadata NuageMetadata) map[port.MetadataKey]string {
+
+	portMetadata := make(map[port.MetadataKey]string)
+	portMetadata[port.MetadataKeyDomain] = nuageMetadata.Domain
+	portMetadata[port.MetadataKeyNetwork] = nuageMetadata.Network
+	portMetadata[port.MetadataKeyZone] = nuageMetadata.Zone
+	portMetadata[port.MetadataKeyNetworkType] = "ipv4"
+
+	// Handling static IP scenario
+	if nuageMetadata.StaticIP != "" {
+		portMetadata[port.MetadataKeyStaticIP] = nuageMetadata.StaticIP
+	}
+
+	// Handling policy group assignment scenario
+	if nuageMetadata.PolicyGroup != "" {
+		portMetadata[port.MetadataNuagePolicyGroup] = nuageMetadata.PolicyGroup
+	}
+
+	// Handling redirection target scenario
+	if nuageMetadata.RedirectionTarget != "" {
+		portMetadata[port.MetadataKeyNuageRedirectionTarget] = nuageMetadata.RedirectionTarget
+	}
+
+	return portMetadata
+}
+
+// GetEntityMetadata builds Nuage VM table metadata
+// for an entity from Nuage metadata
+func GetEntityMetadata(nuageMetadata NuageMetadata, siteID int) map[entity.MetadataKey]string {
+
+	entityMetadata := make(map[entity.MetadataKey]string)
+	entityMetadata[entity.MetadataKeyUser] = nuageMetadata.User
+	entityMetadata[entity.MetadataKeyEnterprise] = nuageMetadata.Enterprise
+	if siteID != -1 {
+		entityMetadata[entity.MetadataKeySiteID] = strconv.Itoa(siteID)
+	}
+
+	return entityMetadata
+}
+
 // SetDefaultsForNuageCNIConfig will set default values for
 // Nuage CNI yaml parameters if they have not been set
 func SetDefaultsForNuageCNIConfig(conf *config.Config) {
@@ -280,11 +400,72 @@ func SetDefaultsForNuageCNIConfig(conf *config.Config) {
 		conf.PortResolveTimer = 60
 	}
 
+	if conf.SubnetExhaustionRetries == 0 {
+		conf.SubnetExhaustionRetries = 1
+	}
+
 	if conf.VRSConnectionCheckTimer == 0 {
 		log.Warnf("VRS Connection keep alive timer not set. Using default value")
 		conf.VRSConnectionCheckTimer = 180
 	}
 
+	if conf.VRSReconnectMaxInterval == 0 {
+		conf.VRSReconnectMaxInterval = 60
+	}
+
+	if conf.ControllerCheckTimer == 0 {
+		conf.ControllerCheckTimer = 10
+	}
+
+	if conf.ControllerDownThreshold == 0 {
+		conf.ControllerDownThreshold = 3
+	}
+
+	if conf.ControllerUpThreshold == 0 {
+		conf.ControllerUpThreshold = 3
+	}
+
+	if conf.HealthAddress == "" {
+		conf.HealthAddress = ":9097"
+	}
+
+	if conf.StateDir == "" {
+		conf.StateDir = "/var/run/nuage-cni"
+	}
+
+	if conf.OutboxDir == "" {
+		conf.OutboxDir = "/var/lib/nuage-cni/outbox"
+	}
+
+	if conf.SubnetCacheFile == "" {
+		conf.SubnetCacheFile = "/var/lib/nuage-cni/subnet-cache.json"
+	}
+
+	if conf.SubnetCacheTTL == 0 {
+		conf.SubnetCacheTTL = 604800
+	}
+
+	// A negative grace period disables the subnet cache fallback
+	if conf.SubnetCacheGracePeriod == 0 {
+		conf.SubnetCacheGracePeriod = 3600
+	}
+
+	if conf.AuditReportFile == "" {
+		conf.AuditReportFile = "/var/log/cni/nuage-audit-report.json"
+	}
+
+	if conf.AuditEmptyPodsThreshold == 0 {
+		conf.AuditEmptyPodsThreshold = 5
+	}
+
+	if conf.AuditMaxDeletions == 0 {
+		conf.AuditMaxDeletions = 20
+	}
+
+	if conf.AuditMaxDeletionPercent == 0 {
+		conf.AuditMaxDeletionPercent = 50
+	}
+
 	if conf.NuageSiteID == 0 {
 		log.Warnf("SiteId not set. It will not be used when specifying metadata")
 		conf.NuageSiteID = -1
@@ -292,7 +473,7 @@ func SetDefaultsForNuageCNIConfig(conf *config.Config) {
 }
 
 // IsVSPFunctional retruns the state of vsc and vrs connection
-func IsVSPFunctional(vrsConnection vrsSdk.VRSConnection) bool {
+func IsVSPFunctional(vrsConnection VRSConnection) bool {
 	log.Debugf("Verifying VRS-VSC connection state")
 	state, err := vrsConnection.GetControllerState()
 	if err != nil {
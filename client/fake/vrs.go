@@ -0,0 +1,408 @@
+// Package fake provides an in-memory VRS used to unit test
+// the CNI plugin and the audit daemon without OVSDB
+
+package fake
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	vrsSdk "github.com/nuagenetworks/libvrsdk/api"
+	"github.com/nuagenetworks/libvrsdk/api/port"
+)
+
+// Port is a port in the fake Nuage port table
+type Port struct {
+	Attributes port.Attributes
+	Metadata   map[port.MetadataKey]string
+	State      map[port.StateKey]interface{}
+}
+
+// Resolver decides how a newly created port is resolved. It
+// returns the address info to resolve the port with or nil
+// to leave the port unresolved
+type Resolver func(name string, metadata map[port.MetadataKey]string) *vrsSdk.PortIPv4Info
+
+// VRSConnection is an in-memory VRS. Errors can be injected
+// per operation name, e.g. Errors["CreatePort"]
+type VRSConnection struct {
+	sync.Mutex
+	Ports           map[string]*Port
+	Entities        map[string]vrsSdk.EntityInfo
+	Alubr0          map[string]bool
+	ControllerState vrsSdk.ControllerState
+	Errors          map[string]error
+	Resolver        Resolver
+	Disconnected    bool
+
+	registrations map[string]chan *vrsSdk.PortIPv4Info
+	pending       map[string]*vrsSdk.PortIPv4Info
+}
+
+// NewVRSConnection returns an empty fake VRS
+// connected to its controller
+func NewVRSConnection() *VRSConnection {
+	return &VRSConnection{
+		Ports:           make(map[string]*Port),
+		Entities:        make(map[string]vrsSdk.EntityInfo),
+		Alubr0:          make(map[string]bool),
+		ControllerState: vrsSdk.ControllerConnected,
+		Errors:          make(map[string]error),
+		registrations:   make(map[string]chan *vrsSdk.PortIPv4Info),
+		pending:         make(map[string]*vrsSdk.PortIPv4Info),
+	}
+}
+
+func (v *VRSConnection) injected(op string) error {
+	return v.Errors[op]
+}
+
+// ResolvePort resolves a port as the controller would and
+// notifies the registered listener of the port
+func (v *VRSConnection) ResolvePort(name string, ip string, gateway string, mask string) error {
+	v.Lock()
+	defer v.Unlock()
+
+	return v.resolve(name, ip, gateway, mask)
+}
+
+func (v *VRSConnection) resolve(name string, ip string, gateway string, mask string) error {
+	p, ok := v.Ports[name]
+	if !ok {
+		return fmt.Errorf("port %s not found", name)
+	}
+	p.State[port.StateKeyIPAddress] = ip
+	p.State[port.StateKeyGateway] = gateway
+	p.State[port.StateKeySubnetMask] = mask
+	p.State[port.StateKeyVrfID] = float64(1)
+	p.State[port.StateKeyNuageDomain] = p.Metadata[port.MetadataKeyDomain]
+	p.State[port.StateKeyNuageZone] = p.Metadata[port.MetadataKeyZone]
+	p.State[port.StateKeyNuageNetwork] = p.Metadata[port.MetadataKeyNetwork]
+
+	v.notify(name, &vrsSdk.PortIPv4Info{IPAddr: ip, Gateway: gateway, Mask: mask, MAC: p.Attributes.MAC, Registered: true})
+	return nil
+}
+
+// FailPort marks a port as handled by the controller without an
+// address, which is how VRS reports an exhausted subnet
+func (v *VRSConnection) FailPort(name string) error {
+	v.Lock()
+	defer v.Unlock()
+
+	p, ok := v.Ports[name]
+	if !ok {
+		return fmt.Errorf("port %s not found", name)
+	}
+	p.State[port.StateKeyIPAddress] = ""
+	p.State[port.StateKeyVrfID] = float64(1)
+	return nil
+}
+
+// notify delivers a port update the way libvrsdk does: to the
+// registered listener if any, else it is kept until one registers
+func (v *VRSConnection) notify(name string, info *vrsSdk.PortIPv4Info) {
+	if ch, ok := v.registrations[name]; ok {
+		go func() { ch <- info }()
+		return
+	}
+	v.pending[name] = info
+}
+
+// GetAllPorts returns the names of all ports
+func (v *VRSConnection) GetAllPorts() ([]string, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("GetAllPorts"); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range v.Ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CreatePort adds a port to the port table
+func (v *VRSConnection) CreatePort(name string, attributes port.Attributes, metadata map[port.MetadataKey]string) error {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("CreatePort"); err != nil {
+		return err
+	}
+	if _, ok := v.Ports[name]; ok {
+		return fmt.Errorf("port %s already exists", name)
+	}
+	v.Ports[name] = &Port{
+		Attributes: attributes,
+		Metadata:   metadata,
+		State:      make(map[port.StateKey]interface{}),
+	}
+
+	if v.Resolver != nil {
+		if info := v.Resolver(name, metadata); info != nil {
+			return v.resolve(name, info.IPAddr, info.Gateway, info.Mask)
+		}
+	}
+	return nil
+}
+
+// DestroyPort removes a port from the port table
+func (v *VRSConnection) DestroyPort(name string) error {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("DestroyPort"); err != nil {
+		return err
+	}
+	if _, ok := v.Ports[name]; !ok {
+		return fmt.Errorf("port %s not found", name)
+	}
+	delete(v.Ports, name)
+
+	// As in libvrsdk the listener is told about the removal
+	// and updates not yet delivered are dropped
+	if ch, ok := v.registrations[name]; ok {
+		go func() { ch <- &vrsSdk.PortIPv4Info{Registered: false} }()
+		delete(v.registrations, name)
+	}
+	delete(v.pending, name)
+	return nil
+}
+
+// GetPortState returns the state of a port
+func (v *VRSConnection) GetPortState(name string) (map[port.StateKey]interface{}, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("GetPortState"); err != nil {
+		return nil, err
+	}
+	p, ok := v.Ports[name]
+	if !ok {
+		return nil, fmt.Errorf("port %s not found", name)
+	}
+	state := make(map[port.StateKey]interface{})
+	for key, value := range p.State {
+		state[key] = value
+	}
+	return state, nil
+}
+
+// UpdatePortMetadata replaces the metadata of a port
+// as libvrsdk does
+func (v *VRSConnection) UpdatePortMetadata(name string, metadata map[string]string) error {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("UpdatePortMetadata"); err != nil {
+		return err
+	}
+	p, ok := v.Ports[name]
+	if !ok {
+		return fmt.Errorf("port %s not found", name)
+	}
+	p.Metadata = make(map[port.MetadataKey]string)
+	for key, value := range metadata {
+		p.Metadata[port.MetadataKey(key)] = value
+	}
+	return nil
+}
+
+// RegisterForPortUpdates registers a listener for port updates. An
+// update that arrived before registration is delivered right away
+func (v *VRSConnection) RegisterForPortUpdates(brport string, pnc chan *vrsSdk.PortIPv4Info) error {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("RegisterForPortUpdates"); err != nil {
+		return err
+	}
+	v.registrations[brport] = pnc
+	if info, ok := v.pending[brport]; ok {
+		delete(v.pending, brport)
+		v.notify(brport, info)
+	}
+	return nil
+}
+
+// DeregisterForPortUpdates removes the listener of a port
+func (v *VRSConnection) DeregisterForPortUpdates(brport string) error {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("DeregisterForPortUpdates"); err != nil {
+		return err
+	}
+	delete(v.registrations, brport)
+	return nil
+}
+
+// AddPortToAlubr0 attaches a port to alubr0
+func (v *VRSConnection) AddPortToAlubr0(intfName string, entityInfo vrsSdk.EntityInfo) error {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("AddPortToAlubr0"); err != nil {
+		return err
+	}
+	v.Alubr0[intfName] = true
+	return nil
+}
+
+// RemovePortFromAlubr0 detaches a port from alubr0
+func (v *VRSConnection) RemovePortFromAlubr0(portName string) error {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("RemovePortFromAlubr0"); err != nil {
+		return err
+	}
+	if !v.Alubr0[portName] {
+		return fmt.Errorf("port %s not attached to alubr0", portName)
+	}
+	delete(v.Alubr0, portName)
+	return nil
+}
+
+// GetAllEntities returns the UUIDs of all entities
+func (v *VRSConnection) GetAllEntities() ([]string, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("GetAllEntities"); err != nil {
+		return nil, err
+	}
+	var uuids []string
+	for uuid := range v.Entities {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	return uuids, nil
+}
+
+// CreateEntity adds an entity to the VM table
+func (v *VRSConnection) CreateEntity(info vrsSdk.EntityInfo) error {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("CreateEntity"); err != nil {
+		return err
+	}
+	if info.UUID == "" {
+		return fmt.Errorf("Uuid absent")
+	}
+	if info.Name == "" {
+		return fmt.Errorf("Name absent")
+	}
+	v.Entities[info.UUID] = info
+	return nil
+}
+
+// DestroyEntity removes an entity by its UUID
+func (v *VRSConnection) DestroyEntity(uuid string) error {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("DestroyEntity"); err != nil {
+		return err
+	}
+	delete(v.Entities, uuid)
+	return nil
+}
+
+// DestroyEntityByVMName removes an entity by its name
+func (v *VRSConnection) DestroyEntityByVMName(name string) error {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("DestroyEntityByVMName"); err != nil {
+		return err
+	}
+	for uuid, info := range v.Entities {
+		if info.Name == name {
+			delete(v.Entities, uuid)
+		}
+	}
+	return nil
+}
+
+// CheckEntityExists reports whether an entity with the UUID exists
+func (v *VRSConnection) CheckEntityExists(id string) (bool, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("CheckEntityExists"); err != nil {
+		return false, err
+	}
+	_, ok := v.Entities[id]
+	return ok, nil
+}
+
+// GetEntityName returns the name of an entity
+func (v *VRSConnection) GetEntityName(uuid string) (string, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("GetEntityName"); err != nil {
+		return "", err
+	}
+	info, ok := v.Entities[uuid]
+	if !ok {
+		return "", fmt.Errorf("no matching vm with uuid %s found", uuid)
+	}
+	return info.Name, nil
+}
+
+// GetEntityPorts returns the ports of an entity by its UUID
+func (v *VRSConnection) GetEntityPorts(uuid string) ([]string, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("GetEntityPorts"); err != nil {
+		return []string{}, err
+	}
+	info, ok := v.Entities[uuid]
+	if !ok {
+		return []string{}, fmt.Errorf("Unable to get port information for the VM")
+	}
+	return append([]string{}, info.Ports...), nil
+}
+
+// GetEntityPortsByName returns the ports of an entity by its name
+func (v *VRSConnection) GetEntityPortsByName(name string) ([]string, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("GetEntityPortsByName"); err != nil {
+		return []string{}, err
+	}
+	for _, info := range v.Entities {
+		if info.Name == name {
+			return append([]string{}, info.Ports...), nil
+		}
+	}
+	return []string{}, fmt.Errorf("Unable to get port information for the VM")
+}
+
+// GetControllerState returns the VRS controller connection state
+func (v *VRSConnection) GetControllerState() (vrsSdk.ControllerState, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if err := v.injected("GetControllerState"); err != nil {
+		return vrsSdk.ControllerStateUnknown, err
+	}
+	return v.ControllerState, nil
+}
+
+// Disconnect closes the fake connection
+func (v *VRSConnection) Disconnect() {
+	v.Lock()
+	defer v.Unlock()
+
+	v.Disconnected = true
+}
@@ -0,0 +1,123 @@
+// This module persists per pod state recorded by Nuage CNI
+// plugin so that it can be used by audit daemon and admin
+// commands after CNI ADD has completed
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const podStateFolder = "pods"
+
+// PodState holds the details of a pod attached to
+// Nuage defined network on the node
+type PodState struct {
+	ContainerID    string        `json:"containerID"`
+	PodNamespace   string        `json:"podNamespace,omitempty"`
+	PodName        string        `json:"podName,omitempty"`
+	PodUID         string        `json:"podUID,omitempty"`
+	EntityName     string        `json:"entityName"`
+	EntityUUID     string        `json:"entityUUID"`
+	Netns          string        `json:"netns"`
+	IfName         string        `json:"ifName"`
+	PortName       string        `json:"portName"`
+	MAC            string        `json:"mac,omitempty"`
+	IP             string        `json:"ip,omitempty"`
+	Gateway        string        `json:"gateway,omitempty"`
+	Mask           string        `json:"mask,omitempty"`
+	Metadata       NuageMetadata `json:"metadata"`
+	MetadataCached bool          `json:"metadataCached,omitempty"`
+	Created        time.Time     `json:"created"`
+	Updated        time.Time     `json:"updated"`
+}
+
+func podStateFile(stateDir string, containerID string) string {
+	return filepath.Join(stateDir, podStateFolder, containerID+".json")
+}
+
+// SavePodState writes the pod state record to the state directory
+func SavePodState(stateDir string, state *PodState) error {
+
+	if err := os.MkdirAll(filepath.Join(stateDir, podStateFolder), 0700); err != nil {
+		return fmt.Errorf("Error creating pod state folder: %v", err)
+	}
+
+	if state.Created.IsZero() {
+		state.Created = time.Now()
+	}
+	state.Updated = time.Now()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling pod state: %v", err)
+	}
+
+	// Write to a temporary file first so that readers
+	// never see a partially written record
+	file := podStateFile(stateDir, state.ContainerID)
+	if err = ioutil.WriteFile(file+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("Error writing pod state: %v", err)
+	}
+
+	return os.Rename(file+".tmp", file)
+}
+
+// LoadPodState reads the pod state record of a container
+func LoadPodState(stateDir string, containerID string) (*PodState, error) {
+
+	data, err := ioutil.ReadFile(podStateFile(stateDir, containerID))
+	if err != nil {
+		return nil, err
+	}
+
+	state := &PodState{}
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling pod state for container %s: %v", containerID, err)
+	}
+
+	return state, nil
+}
+
+// DeletePodState removes the pod state record of a container
+func DeletePodState(stateDir string, containerID string) error {
+
+	err := os.Remove(podStateFile(stateDir, containerID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// ListPodStates returns all pod state records on the node
+func ListPodStates(stateDir string) ([]*PodState, error) {
+
+	files, err := ioutil.ReadDir(filepath.Join(stateDir, podStateFolder))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*PodState{}, nil
+		}
+		return nil, err
+	}
+
+	var states []*PodState
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		state, err := LoadPodState(stateDir, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		states = append(states, state)
+	}
+
+	return states, nil
+}
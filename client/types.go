@@ -25,6 +25,10 @@ type NetConf struct {
 	Hostname string `json:"hostname"`
 }
 
+// EntityNameSeparator separates pod namespace and
+// pod name in VRS entity name
+const EntityNameSeparator = "_"
+
 // K8sArgs is the valid CNI_ARGS used for Kubernetes
 type K8sArgs struct {
 	types.CommonArgs
@@ -32,17 +36,23 @@ type K8sArgs struct {
 	K8S_POD_NAME               types.UnmarshallableString
 	K8S_POD_NAMESPACE          types.UnmarshallableString
 	K8S_POD_INFRA_CONTAINER_ID types.UnmarshallableString
+	K8S_POD_UID                types.UnmarshallableString
 }
 
 // NuageMetadata will hold metadata needed to resolve
 // a port using Nuage defined overlay network
 type NuageMetadata struct {
-	Enterprise        string
-	Domain            string
-	Zone              string
-	Network           string
-	User              string
-	PolicyGroup       string
-	StaticIP          string
-	RedirectionTarget string
+	Enterprise        string `json:"enterprise"`
+	Domain            string `json:"domain"`
+	Zone              string `json:"zone"`
+	Network           string `json:"subnet"`
+	User              string `json:"user"`
+	PolicyGroup       string `json:"policyGroup,omitempty"`
+	StaticIP          string `json:"staticIP,omitempty"`
+	RedirectionTarget string `json:"redirectionTarget,omitempty"`
+
+	// Policy group assigned by Nuage K8S monitor and whether
+	// PolicyGroup was taken from the pod's label instead
+	MonitorPolicyGroup string `json:"monitorPolicyGroup,omitempty"`
+	PolicyGroupLabeled bool   `json:"policyGroupLabeled,omitempty"`
 }
@@ -0,0 +1,216 @@
+// This module defines the VRS operations used by Nuage CNI
+// plugin and audit daemon along with the VRS steps of
+// attaching and detaching a pod shared by CNI ADD and DEL
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	vrsSdk "github.com/nuagenetworks/libvrsdk/api"
+	"github.com/nuagenetworks/libvrsdk/api/entity"
+	"github.com/nuagenetworks/libvrsdk/api/port"
+	log "github.com/sirupsen/logrus"
+)
+
+// VRSConnection covers the VRS operations used by Nuage CNI. It is
+// implemented by libvrsdk VRS connection and by the in-memory fake
+// used in unit tests
+type VRSConnection interface {
+	GetAllPorts() ([]string, error)
+	CreatePort(name string, attributes port.Attributes, metadata map[port.MetadataKey]string) error
+	DestroyPort(name string) error
+	GetPortState(name string) (map[port.StateKey]interface{}, error)
+	UpdatePortMetadata(name string, metadata map[string]string) error
+	RegisterForPortUpdates(brport string, pnc chan *vrsSdk.PortIPv4Info) error
+	DeregisterForPortUpdates(brport string) error
+	AddPortToAlubr0(intfName string, entityInfo vrsSdk.EntityInfo) error
+	RemovePortFromAlubr0(portName string) error
+
+	GetAllEntities() ([]string, error)
+	CreateEntity(info vrsSdk.EntityInfo) error
+	DestroyEntity(uuid string) error
+	DestroyEntityByVMName(name string) error
+	CheckEntityExists(id string) (bool, error)
+	GetEntityName(uuid string) (string, error)
+	GetEntityPorts(uuid string) ([]string, error)
+	GetEntityPortsByName(name string) ([]string, error)
+
+	GetControllerState() (vrsSdk.ControllerState, error)
+	Disconnect()
+}
+
+// PortStatePollInterval is how often VRS port state
+// is checked while waiting for port resolution
+var PortStatePollInterval = 2 * time.Second
+
+// Errors returned when VRS does not resolve a port
+var (
+	ErrPortResolutionTimeout = errors.New("port was not resolved before the resolve timer expired")
+	ErrPortResolutionFailed  = errors.New("controller assigned no address to the port")
+)
+
+// deleteVethPair removes the veth pair of an entity. Unit
+// tests replace it as they run without the veth pair
+var deleteVethPair = DeleteVethPair
+
+// AttachEntityPort attaches the bridge end of the entity veth to
+// alubr0 and creates its Nuage port and entity. If the entity
+// already exists its port is reused and entityInfo updated
+func AttachEntityPort(vrsConnection VRSConnection, entityInfo map[string]string, portAttributes port.Attributes, nuageMetadata NuageMetadata, siteID int) error {
+
+	var info vrsSdk.EntityInfo
+	info.Name = entityInfo["name"]
+	info.UUID = entityInfo["uuid"]
+	err := vrsConnection.AddPortToAlubr0(entityInfo["brport"], info)
+	if err != nil {
+		log.Errorf("Error adding bridge veth end %s of entity %s to alubr0", entityInfo["brport"], entityInfo["name"])
+		// Cleaning up veth ports from VRS
+		_ = deleteVethPair(entityInfo["brport"], entityInfo["entityport"])
+		return fmt.Errorf("Failed to add bridge veth port to alubr0")
+	}
+	log.Debugf("Attached veth interface %s to bridge %s for entity %s", entityInfo["brport"], portAttributes.Bridge, entityInfo["name"])
+
+	// Create an entry for entity in Nuage Port Table
+	err = vrsConnection.CreatePort(entityInfo["brport"], portAttributes, GetPortMetadata(nuageMetadata))
+	if err != nil {
+		log.Errorf("Error creating entity port for entity %s in Nuage Port table", entityInfo["name"])
+		_ = deleteVethPair(entityInfo["brport"], entityInfo["entityport"])
+		_ = vrsConnection.RemovePortFromAlubr0(entityInfo["brport"])
+		return fmt.Errorf("Unable to create entity port %v", err)
+	}
+	log.Debugf("Successfully created a port for entity %s in Nuage Port table", entityInfo["name"])
+
+	entityExists, _ := vrsConnection.CheckEntityExists(entityInfo["uuid"])
+	if entityExists {
+		portList, err := vrsConnection.GetEntityPorts(entityInfo["uuid"])
+		if err != nil {
+			log.Errorf("Error obtaining alubr0 port for entity %s", entityInfo["name"])
+			return fmt.Errorf("Unable to obtain alubr0 port for entity %v", err)
+		}
+		if len(portList) == 0 {
+			log.Errorf("Error configuring alubr0 port for an existing VRS entity %s", entityInfo["name"])
+			return fmt.Errorf("Error configuring alubr0 port for an existing VRS entity %v", err)
+		}
+		entityInfo["brport"] = portList[0]
+		log.Infof("Using existing alubr0 port %s for entity %s", entityInfo["brport"], entityInfo["name"])
+		return nil
+	}
+
+	// Add entity to VRS
+	entityInfoVRS := vrsSdk.EntityInfo{
+		UUID:     entityInfo["uuid"],
+		Name:     entityInfo["name"],
+		Domain:   entity.Docker,
+		Type:     entity.Container,
+		Ports:    []string{entityInfo["brport"]},
+		Metadata: GetEntityMetadata(nuageMetadata, siteID),
+	}
+
+	// Sending proper events for container activation
+	// as these are different for each entity type in VRS
+	events := &entity.EntityEvents{}
+	events.EntityEventCategory = entity.EventCategoryStarted
+	events.EntityEventType = entity.EventStartedBooted
+	events.EntityState = entity.Running
+	events.EntityReason = entity.RunningBooted
+	entityInfoVRS.Events = events
+
+	err = vrsConnection.CreateEntity(entityInfoVRS)
+	if err != nil {
+		log.Errorf("Error creating an entry in Nuage entity table for entity %s", entityInfo["name"])
+		return fmt.Errorf("Unable to add entity to VRS %v", err)
+	}
+	log.Debugf("Successfully created an entity in Nuage entity table for entity %s", entityInfo["name"])
+
+	return nil
+}
+
+// WaitForPortResolution waits for VRS to resolve the port. The port
+// state is polled meanwhile so that a port the controller could not
+// assign an address to is detected before the timeout
+func WaitForPortResolution(vrsConnection VRSConnection, portName string, portInfoUpdateChan chan *vrsSdk.PortIPv4Info, timeout time.Duration) (*vrsSdk.PortIPv4Info, error) {
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	poll := time.NewTicker(PortStatePollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case portInfo := <-portInfoUpdateChan:
+			if !portInfo.Registered {
+				return nil, fmt.Errorf("port was removed from VRS")
+			}
+			return portInfo, nil
+		case <-poll.C:
+			portState, err := vrsConnection.GetPortState(portName)
+			if err == nil && PortResolutionFailed(portState) {
+				return nil, ErrPortResolutionFailed
+			}
+		case <-timer.C:
+			return nil, ErrPortResolutionTimeout
+		}
+	}
+}
+
+// RecreatePort re-creates the port in Nuage port table with new
+// metadata. Unlike a metadata update this also clears the state
+// left behind by the failed resolution
+func RecreatePort(vrsConnection VRSConnection, portName string, portAttributes port.Attributes, nuageMetadata NuageMetadata, portInfoUpdateChan chan *vrsSdk.PortIPv4Info) error {
+
+	err := vrsConnection.DeregisterForPortUpdates(portName)
+	if err != nil {
+		return err
+	}
+
+	err = vrsConnection.DestroyPort(portName)
+	if err != nil {
+		return err
+	}
+
+	err = vrsConnection.CreatePort(portName, portAttributes, GetPortMetadata(nuageMetadata))
+	if err != nil {
+		return err
+	}
+
+	return vrsConnection.RegisterForPortUpdates(portName, portInfoUpdateChan)
+}
+
+// DetachEntityPort removes the entity, its Nuage port, the alubr0
+// port and the veth pair. Every step is attempted and the last
+// error is returned
+func DetachEntityPort(vrsConnection VRSConnection, entityInfo map[string]string, portName string) error {
+
+	var lastErr error
+	err := vrsConnection.DestroyEntity(entityInfo["uuid"])
+	if err != nil {
+		log.Errorf("Failed to remove entity from Nuage entity Table for entity %s: %v", entityInfo["name"], err)
+		lastErr = err
+	}
+
+	// Performing cleanup of port/entity on VRS
+	err = vrsConnection.DestroyPort(portName)
+	if err != nil {
+		log.Errorf("Failed to delete entity port from Nuage Port table for entity %s: %v", entityInfo["name"], err)
+		lastErr = err
+	}
+
+	// Purging out the veth port from VRS alubr0
+	err = vrsConnection.RemovePortFromAlubr0(portName)
+	if err != nil {
+		log.Errorf("Failed to remove veth port %s for entity %s from alubr0: %v", portName, entityInfo["name"], err)
+		lastErr = err
+	}
+
+	// Cleaning up veth paired ports from VRS
+	err = deleteVethPair(portName, entityInfo["entityport"])
+	if err != nil {
+		log.Errorf("Failed to clear veth ports from VRS for entity %s: %v", entityInfo["name"], err)
+		lastErr = err
+	}
+
+	return lastErr
+}
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	vrsSdk "github.com/nuagenetworks/libvrsdk/api"
+	"github.com/nuagenetworks/libvrsdk/api/entity"
+	"github.com/nuagenetworks/libvrsdk/api/port"
+	"github.com/nuagenetworks/nuage-cni/client/fake"
+)
+
+var _ VRSConnection = &vrsSdk.VRSConnection{}
+var _ VRSConnection = fake.NewVRSConnection()
+
+var testMetadata = NuageMetadata{
+	Enterprise: "ent",
+	Domain:     "domain1",
+	Zone:       "zone1",
+	Network:    "subnet1",
+	User:       "admin",
+}
+
+// noVethPair stubs out veth pair deletion and
+// returns a function restoring it
+func noVethPair() func() {
+	deleteVethPair = func(string, string) error { return nil }
+	return func() { deleteVethPair = DeleteVethPair }
+}
+
+func testEntityInfo() map[string]string {
+	return map[string]string{
+		"name":       "default_nginx",
+		"uuid":       "uuid1",
+		"brport":     "nu1234",
+		"entityport": "eth0",
+	}
+}
+
+func TestAttachEntityPort(t *testing.T) {
+
+	defer noVethPair()()
+	attrs := port.Attributes{Platform: entity.Container, MAC: "0a:58:0a:00:00:01", Bridge: "alubr0"}
+
+	tests := []struct {
+		name         string
+		existing     *vrsSdk.EntityInfo
+		injected     string
+		wantErr      bool
+		wantBrport   string
+		wantEntities int
+	}{
+		{name: "new entity", wantBrport: "nu1234", wantEntities: 1},
+		{name: "existing entity", existing: &vrsSdk.EntityInfo{UUID: "uuid1", Name: "default_nginx", Ports: []string{"nu9999"}},
+			wantBrport: "nu9999", wantEntities: 1},
+		{name: "alubr0 failure", injected: "AddPortToAlubr0", wantErr: true},
+		{name: "port failure", injected: "CreatePort", wantErr: true},
+		{name: "entity failure", injected: "CreateEntity", wantErr: true, wantBrport: "nu1234"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vrs := fake.NewVRSConnection()
+			if test.existing != nil {
+				vrs.Entities[test.existing.UUID] = *test.existing
+			}
+			if test.injected != "" {
+				vrs.Errors[test.injected] = errors.New("injected")
+			}
+
+			entityInfo := testEntityInfo()
+			err := AttachEntityPort(vrs, entityInfo, attrs, testMetadata, 1)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if test.injected == "CreatePort" && vrs.Alubr0["nu1234"] {
+				t.Errorf("alubr0 port was not removed after port creation failed")
+			}
+			if test.wantBrport == "" {
+				return
+			}
+			if entityInfo["brport"] != test.wantBrport {
+				t.Errorf("expected bridge port %s, got %s", test.wantBrport, entityInfo["brport"])
+			}
+			if _, ok := vrs.Ports["nu1234"]; !ok {
+				t.Errorf("port was not created")
+			}
+			if test.wantErr {
+				return
+			}
+			if len(vrs.Entities) != test.wantEntities {
+				t.Errorf("expected %d entities, got %d", test.wantEntities, len(vrs.Entities))
+			}
+			if vrs.Ports["nu1234"].Metadata[port.MetadataKeyNetwork] != "subnet1" {
+				t.Errorf("unexpected port metadata %v", vrs.Ports["nu1234"].Metadata)
+			}
+		})
+	}
+}
+
+func TestWaitForPortResolution(t *testing.T) {
+
+	PortStatePollInterval = 10 * time.Millisecond
+	defer func() { PortStatePollInterval = 2 * time.Second }()
+
+	tests := []struct {
+		name    string
+		act     func(vrs *fake.VRSConnection)
+		wantIP  string
+		wantErr error
+	}{
+		{
+			name:   "resolved",
+			act:    func(vrs *fake.VRSConnection) { _ = vrs.ResolvePort("nu1234", "10.0.0.5", "10.0.0.1", "255.255.255.0") },
+			wantIP: "10.0.0.5",
+		},
+		{
+			name:    "no address assigned",
+			act:     func(vrs *fake.VRSConnection) { _ = vrs.FailPort("nu1234") },
+			wantErr: ErrPortResolutionFailed,
+		},
+		{
+			name:    "not resolved",
+			act:     func(vrs *fake.VRSConnection) {},
+			wantErr: ErrPortResolutionTimeout,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vrs := fake.NewVRSConnection()
+			if err := vrs.CreatePort("nu1234", port.Attributes{}, GetPortMetadata(testMetadata)); err != nil {
+				t.Fatal(err)
+			}
+			ch := make(chan *vrsSdk.PortIPv4Info)
+			if err := vrs.RegisterForPortUpdates("nu1234", ch); err != nil {
+				t.Fatal(err)
+			}
+
+			test.act(vrs)
+			portInfo, err := WaitForPortResolution(vrs, "nu1234", ch, 200*time.Millisecond)
+			if err != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if test.wantIP != "" && portInfo.IPAddr != test.wantIP {
+				t.Errorf("expected IP %s, got %s", test.wantIP, portInfo.IPAddr)
+			}
+		})
+	}
+}
+
+func TestRecreatePort(t *testing.T) {
+
+	vrs := fake.NewVRSConnection()
+	if err := vrs.CreatePort("nu1234", port.Attributes{}, GetPortMetadata(testMetadata)); err != nil {
+		t.Fatal(err)
+	}
+	_ = vrs.FailPort("nu1234")
+	ch := make(chan *vrsSdk.PortIPv4Info)
+	if err := vrs.RegisterForPortUpdates("nu1234", ch); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := testMetadata
+	metadata.Network = "subnet2"
+	if err := RecreatePort(vrs, "nu1234", port.Attributes{}, metadata, ch); err != nil {
+		t.Fatal(err)
+	}
+
+	state, _ := vrs.GetPortState("nu1234")
+	if PortResolutionFailed(state) {
+		t.Errorf("state of the failed resolution was kept")
+	}
+	if vrs.Ports["nu1234"].Metadata[port.MetadataKeyNetwork] != "subnet2" {
+		t.Errorf("port was not moved to the new subnet")
+	}
+
+	_ = vrs.ResolvePort("nu1234", "10.0.1.5", "10.0.1.1", "255.255.255.0")
+	select {
+	case portInfo := <-ch:
+		if !portInfo.Registered || portInfo.IPAddr != "10.0.1.5" {
+			t.Errorf("unexpected port update %+v", portInfo)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no port update after the port was re-created")
+	}
+}
+
+func TestDetachEntityPort(t *testing.T) {
+
+	defer noVethPair()()
+	vrs := fake.NewVRSConnection()
+	vrs.Resolver = func(string, map[port.MetadataKey]string) *vrsSdk.PortIPv4Info {
+		return &vrsSdk.PortIPv4Info{IPAddr: "10.0.0.5", Gateway: "10.0.0.1", Mask: "255.255.255.0"}
+	}
+
+	entityInfo := testEntityInfo()
+	if err := AttachEntityPort(vrs, entityInfo, port.Attributes{}, testMetadata, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DetachEntityPort(vrs, entityInfo, "nu1234"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(vrs.Ports) != 0 || len(vrs.Entities) != 0 || len(vrs.Alubr0) != 0 {
+		t.Errorf("VRS entries left behind: ports %v entities %v alubr0 %v", vrs.Ports, vrs.Entities, vrs.Alubr0)
+	}
+
+	// A second DEL reports the missing entries but does not stop
+	if err := DetachEntityPort(vrs, entityInfo, "nu1234"); err == nil {
+		t.Errorf("expected an error detaching a detached port")
+	}
+}
@@ -1,5 +1,34 @@
 package config
 
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFile is the Nuage CNI plugin parameter file on the node
+const ConfigFile = "/etc/default/nuage-cni.yaml"
+
+// Environment variables relocating Nuage CNI plugin parameter
+// file and Nuage VSP data directory, e.g. for e2e tests
+const (
+	ConfigFileEnv = "NUAGE_CNI_CONFIG"
+	DataDirEnv    = "NUAGE_CNI_DATA_DIR"
+)
+
+// GetConfigFile returns Nuage CNI plugin parameter file
+// set in NUAGE_CNI_CONFIG or the default one
+func GetConfigFile() string {
+
+	if file := os.Getenv(ConfigFileEnv); file != "" {
+		return file
+	}
+
+	return ConfigFile
+}
+
 // NuageVSPK8SConfig struct will be used to read and
 // parse values from Nuage vsp-k8s yaml file on k8s agent nodes
 type NuageVSPK8SConfig struct {
@@ -16,6 +45,7 @@ type NuageVSPK8SConfig struct {
 	NuageK8SMonClientCertFile string `yaml:"nuageMonClientCert"`
 	NuageK8SMonClientKeyFile  string `yaml:"nuageMonClientKey"`
 	NuageK8SMonCAFile         string `yaml:"nuageMonServerCA"`
+	NuageK8SMonServerName     string `yaml:"nuageMonServerName"`
 	KubeConfig                string `yaml:"kubeConfig"`
 }
 
@@ -27,10 +57,48 @@ type Config struct {
 	MonitorInterval         int
 	CNIVersion              string
 	LogLevel                string
+	LogFormat               string
+	CNILogFile              string
+	DaemonLogFile           string
 	PortResolveTimer        int
+	SubnetExhaustionRetries int
 	LogFileSize             int
+	LogFileBackups          int
+	LogFileMaxAge           int
 	VRSConnectionCheckTimer int
+	VRSReconnectMaxInterval int
+	ControllerCheckTimer    int
+	ControllerDownThreshold int
+	ControllerUpThreshold   int
+	NodeTaintOnDisconnect   bool
+	HealthAddress           string
 	MTU                     int
 	StaleEntryTimeout       int64
+	AuditDryRun             bool
+	AuditReportFile         string
+	AuditEmptyPodsThreshold int
+	AuditMaxDeletions       int
+	AuditMaxDeletionPercent int
+	StateDir                string
+	OutboxDir               string
+	SubnetCacheFile         string
+	SubnetCacheTTL          int
+	SubnetCacheGracePeriod  int
 	NuageSiteID             int
 }
+
+// LoadConfig reads Nuage CNI plugin parameters from the given file
+func LoadConfig(file string) (*Config, error) {
+
+	conf := &Config{}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return conf, fmt.Errorf("Error in reading from Nuage CNI plugin parameter file: %s", err)
+	}
+
+	if err = yaml.Unmarshal(data, conf); err != nil {
+		return conf, fmt.Errorf("Error in unmarshalling data from Nuage CNI parameter file: %s", err)
+	}
+
+	return conf, nil
+}
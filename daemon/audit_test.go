@@ -0,0 +1,220 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	vrsSdk "github.com/nuagenetworks/libvrsdk/api"
+	"github.com/nuagenetworks/libvrsdk/api/port"
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/client/fake"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// setupAudit prepares the audit to run against the given
+// active pods without waiting for stale entries to age
+func setupAudit(t *testing.T, pods []*corev1.Pod) func() {
+
+	dir, err := ioutil.TempDir("", "nuage-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range pods {
+		if err = indexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	podLister = corelisters.NewPodLister(indexer)
+	podInformerSynced = func() bool { return true }
+
+	staleEntityMap = make(map[string]int64)
+	stalePortMap = make(map[string]int64)
+	staleVethMap = make(map[string]int64)
+	staleEntryTimeout = 0
+	stateDir = dir
+	outboxDir = dir
+	auditReportFile = ""
+	persistAuditState = false
+	auditDryRun = false
+	emptyPodsThreshold = 0
+	maxDeletions = 0
+	maxDeletionPercent = 0
+
+	return func() {
+		podLister = nil
+		podInformerSynced = nil
+		os.RemoveAll(dir)
+	}
+}
+
+// addEntity adds a CNI created entity and its port to the fake VRS
+func addEntity(vrs *fake.VRSConnection, uuid string, name string, portName string) {
+	_ = vrs.CreatePort(portName, port.Attributes{}, map[port.MetadataKey]string{})
+	_ = vrs.AddPortToAlubr0(portName, vrsSdk.EntityInfo{})
+	_ = vrs.CreateEntity(vrsSdk.EntityInfo{UUID: uuid, Name: name, Ports: []string{portName}})
+}
+
+func TestCleanupStaleEntities(t *testing.T) {
+
+	activePod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1"}}
+
+	tests := []struct {
+		name          string
+		pods          []*corev1.Pod
+		dryRun        bool
+		emptyPods     int
+		wantEntities  []string
+		wantPorts     []string
+		wantSkipAlert bool
+	}{
+		{
+			name:         "stale entity and port removed",
+			pods:         []*corev1.Pod{activePod},
+			wantEntities: []string{"uuid1"},
+			wantPorts:    []string{"nu1111"},
+		},
+		{
+			name:         "dry run",
+			pods:         []*corev1.Pod{activePod},
+			dryRun:       true,
+			wantEntities: []string{"uuid1", "uuid2"},
+			wantPorts:    []string{"nu1111", "nu2222", "nu3333"},
+		},
+		{
+			name:          "no active pods",
+			emptyPods:     1,
+			wantEntities:  []string{"uuid1", "uuid2"},
+			wantPorts:     []string{"nu1111", "nu2222", "nu3333"},
+			wantSkipAlert: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer setupAudit(t, test.pods)()
+			auditDryRun = test.dryRun
+			emptyPodsThreshold = test.emptyPods
+
+			vrs := fake.NewVRSConnection()
+			addEntity(vrs, "uuid1", "ns1_pod1", "nu1111")
+			addEntity(vrs, "uuid2", "ns1_pod2", "nu2222")
+			// Port left behind by an interrupted DEL
+			_ = vrs.CreatePort("nu3333", port.Attributes{}, map[port.MetadataKey]string{})
+
+			_ = cleanupStaleEntities(vrs, "k8s")
+
+			entities, _ := vrs.GetAllEntities()
+			if !reflect.DeepEqual(entities, test.wantEntities) {
+				t.Errorf("expected entities %v, got %v", test.wantEntities, entities)
+			}
+			ports, _ := vrs.GetAllPorts()
+			sort.Strings(ports)
+			if !reflect.DeepEqual(ports, test.wantPorts) {
+				t.Errorf("expected ports %v, got %v", test.wantPorts, ports)
+			}
+			if test.wantSkipAlert != (len(currentReport.Alerts) > 0) {
+				t.Errorf("unexpected audit alerts %v", currentReport.Alerts)
+			}
+		})
+	}
+}
+
+func TestGetActiveEntityNamesLegacy(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		pod        *corev1.Pod
+		zone       string
+		state      *client.PodState
+		wantLegacy bool
+	}{
+		{
+			name:       "pod owning legacy entity",
+			pod:        &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "nsA"}},
+			zone:       "nsA",
+			wantLegacy: true,
+		},
+		{
+			name: "pod with same name in another namespace",
+			pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "nsB"}},
+			zone: "nsA",
+		},
+		{
+			name: "pod in zone of legacy entity",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "nsB",
+				Labels: map[string]string{k8s.ZoneLabel: "zone1"}}},
+			zone:       "zone1",
+			wantLegacy: true,
+		},
+		{
+			name:  "pod state record of another namespace",
+			pod:   &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "nsB"}},
+			zone:  "nsB",
+			state: &client.PodState{ContainerID: "uuid1", PodNamespace: "nsA", PodName: "nginx", EntityName: "nginx"},
+		},
+		{
+			name:       "zone not known",
+			pod:        &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "nsB"}},
+			wantLegacy: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer setupAudit(t, nil)()
+
+			vrs := fake.NewVRSConnection()
+			addEntity(vrs, "uuid1", "nginx", "nu1111")
+			if test.zone != "" {
+				vrs.Ports["nu1111"].Metadata[port.MetadataKeyZone] = test.zone
+				_ = vrs.ResolvePort("nu1111", "10.0.0.5", "10.0.0.1", "255.255.255.0")
+			}
+			if test.state != nil {
+				if err := client.SavePodState(stateDir, test.state); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			entityNames, legacyNames := getActiveEntityNames(vrs, []*corev1.Pod{test.pod})
+			if want := client.GetEntityName(test.pod.Namespace, test.pod.Name); !reflect.DeepEqual(entityNames, []string{want}) {
+				t.Errorf("expected entity names [%s], got %v", want, entityNames)
+			}
+			if legacy := len(legacyNames) == 1 && legacyNames[0] == "nginx"; legacy != test.wantLegacy {
+				t.Errorf("expected legacy entity matched %t, got %v", test.wantLegacy, legacyNames)
+			}
+		})
+	}
+}
+
+func TestCleanupDeletedPod(t *testing.T) {
+
+	defer setupAudit(t, nil)()
+
+	vrs := fake.NewVRSConnection()
+	addEntity(vrs, "uuid1", "ns1_pod1", "nu1111")
+	addEntity(vrs, "uuid2", "ns1_pod2", "nu2222")
+
+	cleanupDeletedPod(vrs, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1"}})
+
+	if exists, _ := vrs.CheckEntityExists("uuid1"); exists {
+		t.Errorf("entity of deleted pod was not removed")
+	}
+	if _, ok := vrs.Ports["nu1111"]; ok {
+		t.Errorf("port of deleted pod was not removed")
+	}
+	if vrs.Alubr0["nu1111"] {
+		t.Errorf("alubr0 port of deleted pod was not removed")
+	}
+	if exists, _ := vrs.CheckEntityExists("uuid2"); !exists {
+		t.Errorf("entity of another pod was removed")
+	}
+}
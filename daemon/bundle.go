@@ -0,0 +1,348 @@
+package daemon
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/config"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// redactedValue replaces secrets in support bundle files
+const redactedValue = "<redacted>"
+
+// Patterns of secrets redacted from configuration and log files: PEM
+// encoded keys and certificates, values of secret looking settings
+// and bearer tokens
+var (
+	pemBlockPattern    = regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]+-----.*?-----END [A-Z0-9 ]+-----`)
+	secretValuePattern = regexp.MustCompile(`(?i)("?[\w.-]*(?:password|passwd|secret|token|credential|apikey|api_key|private_?key|key_?data)[\w.-]*"?\s*[:=]\s*)("[^"]*"|'[^']*'|[^\s,;"']+)`)
+	bearerPattern      = regexp.MustCompile(`(?i)(bearer\s+)[^\s"']+`)
+)
+
+// BundleEntity is a row of Nuage entity table in a support bundle
+type BundleEntity struct {
+	UUID  string   `json:"uuid"`
+	Name  string   `json:"name"`
+	Ports []string `json:"ports"`
+}
+
+// BundlePort is a row of Nuage port table in a support bundle
+type BundlePort struct {
+	Name  string                 `json:"name"`
+	State map[string]interface{} `json:"state,omitempty"`
+}
+
+// BundleOVSDB is the dump of Nuage OVSDB tables in a support bundle
+type BundleOVSDB struct {
+	ControllerState string         `json:"controllerState"`
+	Entities        []BundleEntity `json:"entities"`
+	Ports           []BundlePort   `json:"ports"`
+}
+
+// BundleLink describes a Nuage host veth in a support bundle
+type BundleLink struct {
+	Name        string   `json:"name"`
+	Index       int      `json:"index"`
+	Type        string   `json:"type"`
+	MAC         string   `json:"mac"`
+	MTU         int      `json:"mtu"`
+	Flags       string   `json:"flags"`
+	MasterIndex int      `json:"masterIndex"`
+	Addresses   []string `json:"addresses"`
+	Routes      []string `json:"routes"`
+}
+
+// BundlePod summarizes a pod on the node in a support bundle.
+// Pod specs are left out as they may carry secrets
+type BundlePod struct {
+	Namespace  string            `json:"namespace"`
+	Name       string            `json:"name"`
+	UID        string            `json:"uid"`
+	Phase      string            `json:"phase"`
+	PodIP      string            `json:"podIP,omitempty"`
+	HostNet    bool              `json:"hostNetwork,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Created    time.Time         `json:"created"`
+	Deleted    *time.Time        `json:"deleted,omitempty"`
+	Conditions []string          `json:"conditions,omitempty"`
+}
+
+// supportBundle writes the files of a support bundle to a
+// tarball. Files that cannot be collected are listed in
+// errors.txt so that the rest of the bundle is still written
+type supportBundle struct {
+	tw     *tar.Writer
+	prefix string
+	now    time.Time
+	errors []string
+}
+
+// WriteSupportBundle writes a tarball with Nuage CNI logs, redacted
+// configuration, Nuage OVSDB tables, Nuage host veths, controller
+// state and pods on the node for Nuage support cases
+func WriteSupportBundle(config *config.Config, orchestrator string, file string, w io.Writer) error {
+
+	var err error
+	orchestratorType = orchestrator
+	stateDir = config.StateDir
+	hostname, err = os.Hostname()
+	if err != nil {
+		log.Errorf("finding hostname failed with error: %v", err)
+		return err
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("nuage-cni-support-%s-%s", hostname, now.Format("20060102-150405"))
+	if file == "" {
+		file = name + ".tar.gz"
+	}
+
+	out, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Error creating support bundle %s: %v", file, err)
+	}
+	defer out.Close()
+
+	gw := gzip.NewWriter(out)
+	bundle := &supportBundle{tw: tar.NewWriter(gw), prefix: name, now: now}
+
+	bundle.addFile("logs/nuage-cni.log", config.CNILogFile)
+	bundle.addFile("logs/nuage-daemon.log", config.DaemonLogFile)
+	bundle.addFile("config/nuage-cni.yaml", nuageCNIConfigFile())
+	vspConfigFile := k8s.VSPConfigFile(orchestrator)
+	bundle.addFile("config/"+filepath.Base(vspConfigFile), vspConfigFile)
+	bundle.collectVRS(config)
+	bundle.collectLinks()
+	bundle.collectPods()
+
+	if len(bundle.errors) > 0 {
+		bundle.add("errors.txt", []byte(strings.Join(bundle.errors, "\n")+"\n"))
+	}
+
+	if err = bundle.tw.Close(); err != nil {
+		return fmt.Errorf("Error writing support bundle %s: %v", file, err)
+	}
+	if err = gw.Close(); err != nil {
+		return fmt.Errorf("Error writing support bundle %s: %v", file, err)
+	}
+	if err = out.Close(); err != nil {
+		return fmt.Errorf("Error writing support bundle %s: %v", file, err)
+	}
+
+	fmt.Fprintf(w, "Support bundle written to %s\n", file)
+	for _, e := range bundle.errors {
+		fmt.Fprintf(w, "  not collected: %s\n", e)
+	}
+	return nil
+}
+
+// nuageCNIConfigFile returns Nuage CNI
+// plugin parameter file on the node
+func nuageCNIConfigFile() string {
+	return config.GetConfigFile()
+}
+
+// collectVRS dumps Nuage entity and port tables
+// along with VRS controller state
+func (b *supportBundle) collectVRS(config *config.Config) {
+
+	vrsConnection, err := connectVRS(config)
+	if err != nil {
+		b.fail("ovsdb.json", err)
+		return
+	}
+	defer vrsConnection.Disconnect()
+
+	dump, err := dumpNuageTables(vrsConnection)
+	if err != nil {
+		b.fail("ovsdb.json", err)
+		return
+	}
+	b.addJSON("ovsdb.json", dump)
+}
+
+// dumpNuageTables reads Nuage OVSDB tables through libvrsdk
+func dumpNuageTables(vrsConnection client.VRSConnection) (*BundleOVSDB, error) {
+
+	dump := &BundleOVSDB{Entities: []BundleEntity{}, Ports: []BundlePort{}}
+
+	controllerState, err := vrsConnection.GetControllerState()
+	if err != nil {
+		log.Warnf("Unable to obtain VRS controller state: %v", err)
+	}
+	dump.ControllerState = string(controllerState)
+
+	entities, err := vrsConnection.GetAllEntities()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get entity list from VRS: %v", err)
+	}
+	for _, uuid := range entities {
+		entity := BundleEntity{UUID: uuid}
+		entity.Name, _ = vrsConnection.GetEntityName(uuid)
+		entity.Ports, _ = vrsConnection.GetEntityPorts(uuid)
+		dump.Entities = append(dump.Entities, entity)
+	}
+
+	ports, err := vrsConnection.GetAllPorts()
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting port names from VRS: %v", err)
+	}
+	for _, name := range ports {
+		p := BundlePort{Name: name}
+		portState, err := vrsConnection.GetPortState(name)
+		if err != nil {
+			log.Debugf("Unable to obtain VRS port state for port %s: %v", name, err)
+		} else {
+			p.State = make(map[string]interface{})
+			for key, value := range portState {
+				p.State[string(key)] = value
+			}
+		}
+		dump.Ports = append(dump.Ports, p)
+	}
+
+	return dump, nil
+}
+
+// collectLinks records Nuage host veths with
+// their addresses and routes
+func (b *supportBundle) collectLinks() {
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		b.fail("links.json", err)
+		return
+	}
+
+	bundleLinks := []BundleLink{}
+	for _, link := range links {
+		attrs := link.Attrs()
+		if !strings.HasPrefix(attrs.Name, "nu") {
+			continue
+		}
+		bundleLink := BundleLink{
+			Name:        attrs.Name,
+			Index:       attrs.Index,
+			Type:        link.Type(),
+			MAC:         attrs.HardwareAddr.String(),
+			MTU:         attrs.MTU,
+			Flags:       attrs.Flags.String(),
+			MasterIndex: attrs.MasterIndex,
+			Addresses:   []string{},
+			Routes:      []string{},
+		}
+		if addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL); err == nil {
+			for _, addr := range addrs {
+				bundleLink.Addresses = append(bundleLink.Addresses, addr.IPNet.String())
+			}
+		}
+		if routes, err := netlink.RouteList(link, netlink.FAMILY_ALL); err == nil {
+			for _, route := range routes {
+				bundleLink.Routes = append(bundleLink.Routes, route.String())
+			}
+		}
+		bundleLinks = append(bundleLinks, bundleLink)
+	}
+
+	b.addJSON("links.json", bundleLinks)
+}
+
+// collectPods records the pods on the node
+// as seen by K8S API server
+func (b *supportBundle) collectPods() {
+
+	pods, err := listNodePods()
+	if err != nil {
+		b.fail("pods.json", err)
+		return
+	}
+
+	bundlePods := []BundlePod{}
+	for _, pod := range pods {
+		bundlePod := BundlePod{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			UID:       string(pod.UID),
+			Phase:     string(pod.Status.Phase),
+			PodIP:     pod.Status.PodIP,
+			HostNet:   pod.Spec.HostNetwork,
+			Labels:    pod.Labels,
+			Created:   pod.CreationTimestamp.Time,
+		}
+		if pod.DeletionTimestamp != nil {
+			bundlePod.Deleted = &pod.DeletionTimestamp.Time
+		}
+		for _, condition := range pod.Status.Conditions {
+			bundlePod.Conditions = append(bundlePod.Conditions, fmt.Sprintf("%s=%s", condition.Type, condition.Status))
+		}
+		bundlePods = append(bundlePods, bundlePod)
+	}
+
+	b.addJSON("pods.json", bundlePods)
+}
+
+// addFile adds a file of the node to the bundle. Secrets are
+// redacted from configuration files and from logs alike
+func (b *supportBundle) addFile(name string, file string) {
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		b.fail(name, err)
+		return
+	}
+	b.add(name, redactSecrets(data))
+}
+
+func (b *supportBundle) addJSON(name string, v interface{}) {
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b.fail(name, err)
+		return
+	}
+	b.add(name, append(data, '\n'))
+}
+
+func (b *supportBundle) add(name string, data []byte) {
+
+	header := &tar.Header{
+		Name:    b.prefix + "/" + name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: b.now,
+	}
+	if err := b.tw.WriteHeader(header); err != nil {
+		b.fail(name, err)
+		return
+	}
+	if _, err := b.tw.Write(data); err != nil {
+		b.fail(name, err)
+	}
+}
+
+func (b *supportBundle) fail(name string, err error) {
+	log.Warnf("Unable to add %s to support bundle: %v", name, err)
+	b.errors = append(b.errors, fmt.Sprintf("%s: %v", name, err))
+}
+
+// redactSecrets masks PEM blocks, values of settings
+// named like secrets and bearer tokens
+func redactSecrets(data []byte) []byte {
+
+	data = pemBlockPattern.ReplaceAll(data, []byte(redactedValue))
+	data = secretValuePattern.ReplaceAll(data, []byte("${1}"+redactedValue))
+	return bearerPattern.ReplaceAll(data, []byte("${1}"+redactedValue))
+}
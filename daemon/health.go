@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// counter is a monotonically increasing metric
+type counter struct {
+	value uint64
+}
+
+func (c *counter) inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+func (c *counter) get() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+var vrsConnected int32
+var vrsConnectedSince int64
+var vrsDisconnects counter
+var vrsReconnects counter
+
+func setVRSConnected(connected bool) {
+	if connected {
+		atomic.StoreInt64(&vrsConnectedSince, time.Now().Unix())
+		atomic.StoreInt32(&vrsConnected, 1)
+		return
+	}
+	atomic.StoreInt32(&vrsConnected, 0)
+}
+
+func isVRSConnected() bool {
+	return atomic.LoadInt32(&vrsConnected) == 1
+}
+
+// startHealthServer serves the audit daemon health
+// on /healthz and its metrics on /metrics
+func startHealthServer(address string) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", serveHealth)
+	mux.HandleFunc("/metrics", serveMetrics)
+
+	go func() {
+		log.Infof("Serving audit daemon health and metrics on %s", address)
+		err := http.ListenAndServe(address, mux)
+		if err != nil {
+			log.Errorf("Error serving audit daemon health on %s: %v", address, err)
+		}
+	}()
+}
+
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	if !isVRSConnected() {
+		http.Error(w, "VRS disconnected", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// serveMetrics writes metrics in Prometheus text exposition format
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+
+	connected := 0
+	if isVRSConnected() {
+		connected = 1
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP nuage_cni_vrs_connected Whether the audit daemon is connected to VRS.\n")
+	fmt.Fprintf(w, "# TYPE nuage_cni_vrs_connected gauge\n")
+	fmt.Fprintf(w, "nuage_cni_vrs_connected %d\n", connected)
+	fmt.Fprintf(w, "# HELP nuage_cni_vrs_connected_since_seconds Unix time of the last successful VRS connection.\n")
+	fmt.Fprintf(w, "# TYPE nuage_cni_vrs_connected_since_seconds gauge\n")
+	fmt.Fprintf(w, "nuage_cni_vrs_connected_since_seconds %d\n", atomic.LoadInt64(&vrsConnectedSince))
+	fmt.Fprintf(w, "# HELP nuage_cni_vrs_disconnects_total Number of VRS disconnects seen by the audit daemon.\n")
+	fmt.Fprintf(w, "# TYPE nuage_cni_vrs_disconnects_total counter\n")
+	fmt.Fprintf(w, "nuage_cni_vrs_disconnects_total %d\n", vrsDisconnects.get())
+	fmt.Fprintf(w, "# HELP nuage_cni_vrs_reconnects_total Number of successful VRS reconnects.\n")
+	fmt.Fprintf(w, "# TYPE nuage_cni_vrs_reconnects_total counter\n")
+	fmt.Fprintf(w, "nuage_cni_vrs_reconnects_total %d\n", vrsReconnects.get())
+	fmt.Fprintf(w, "# HELP nuage_cni_node_network_ready Whether VRS on the node is considered connected to its VSC controller.\n")
+	fmt.Fprintf(w, "# TYPE nuage_cni_node_network_ready gauge\n")
+	fmt.Fprintf(w, "nuage_cni_node_network_ready %d\n", atomic.LoadInt32(&nodeNetworkReady))
+	fmt.Fprintf(w, "# HELP nuage_cni_outbox_pending Number of pod deletion notifications queued in the outbox.\n")
+	fmt.Fprintf(w, "# TYPE nuage_cni_outbox_pending gauge\n")
+	fmt.Fprintf(w, "nuage_cni_outbox_pending %d\n", atomic.LoadInt64(&outboxPending))
+	fmt.Fprintf(w, "# HELP nuage_cni_outbox_delivered_total Number of queued pod deletion notifications sent.\n")
+	fmt.Fprintf(w, "# TYPE nuage_cni_outbox_delivered_total counter\n")
+	fmt.Fprintf(w, "nuage_cni_outbox_delivered_total %d\n", outboxDelivered.get())
+	fmt.Fprintf(w, "# HELP nuage_cni_outbox_dropped_total Number of queued notifications dropped as their pod was re-created.\n")
+	fmt.Fprintf(w, "# TYPE nuage_cni_outbox_dropped_total counter\n")
+	fmt.Fprintf(w, "nuage_cni_outbox_dropped_total %d\n", outboxDropped.get())
+}
@@ -0,0 +1,495 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	vrsSdk "github.com/nuagenetworks/libvrsdk/api"
+	"github.com/nuagenetworks/libvrsdk/api/port"
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// Output formats of inspect command
+const (
+	InspectFormatTable = "table"
+	InspectFormatJSON  = "json"
+)
+
+// InspectEntry describes a Nuage entity and its port on the node
+// along with the inconsistencies found between VRS, the host
+// veth, the pod state record and K8S
+type InspectEntry struct {
+	EntityUUID   string   `json:"entityUUID,omitempty"`
+	EntityName   string   `json:"entityName,omitempty"`
+	PodNamespace string   `json:"podNamespace,omitempty"`
+	PodName      string   `json:"podName,omitempty"`
+	ContainerID  string   `json:"containerID,omitempty"`
+	Port         string   `json:"port,omitempty"`
+	HostVeth     string   `json:"hostVeth,omitempty"`
+	MAC          string   `json:"mac,omitempty"`
+	IP           string   `json:"ip,omitempty"`
+	Gateway      string   `json:"gateway,omitempty"`
+	Mask         string   `json:"mask,omitempty"`
+	Zone         string   `json:"zone,omitempty"`
+	Subnet       string   `json:"subnet,omitempty"`
+	Domain       string   `json:"domain,omitempty"`
+	PolicyGroup  string   `json:"policyGroup,omitempty"`
+	Issues       []string `json:"issues,omitempty"`
+}
+
+// InspectReport lists the Nuage entities and ports on the node
+type InspectReport struct {
+	Hostname        string         `json:"hostname"`
+	GeneratedAt     time.Time      `json:"generatedAt"`
+	ControllerState string         `json:"controllerState"`
+	PodsChecked     bool           `json:"podsChecked"`
+	Entries         []InspectEntry `json:"entries"`
+	Issues          int            `json:"issues"`
+}
+
+// Inspect lists every Nuage entity and port on the node and
+// flags inconsistencies. Active pods are fetched from K8S API
+// server on a best effort basis
+func Inspect(config *config.Config, orchestrator string, format string, w io.Writer) error {
+
+	if format != InspectFormatTable && format != InspectFormatJSON {
+		return fmt.Errorf("unknown output format %q; use %s or %s", format, InspectFormatTable, InspectFormatJSON)
+	}
+
+	var err error
+	orchestratorType = orchestrator
+	stateDir = config.StateDir
+	hostname, err = os.Hostname()
+	if err != nil {
+		log.Errorf("finding hostname failed with error: %v", err)
+		return err
+	}
+
+	vrsConnection, err := connectVRS(config)
+	if err != nil {
+		log.Errorf("Error connecting to VRS: %v", err)
+		return err
+	}
+	defer vrsConnection.Disconnect()
+
+	pods, err := listNodePods()
+	if err != nil {
+		log.Warnf("Unable to list pods on node %s; skipping K8S checks: %v", hostname, err)
+	}
+
+	veths, err := listHostVeths()
+	if err != nil {
+		return err
+	}
+
+	report, err := inspectNode(vrsConnection, pods, veths)
+	if err != nil {
+		return err
+	}
+
+	if format == InspectFormatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	return writeInspectTable(report, w)
+}
+
+// listNodePods lists pods scheduled on the node
+// straight from K8S API server
+func listNodePods() ([]*corev1.Pod, error) {
+
+	var err error
+	kubeClient, err = newKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	podList, err := kubeClient.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector(PodHostField, hostname).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]*corev1.Pod, 0, len(podList.Items))
+	for i := range podList.Items {
+		pods = append(pods, &podList.Items[i])
+	}
+	return pods, nil
+}
+
+// listHostVeths returns Nuage host veths on the node and
+// whether each of them is attached to alubr0
+func listHostVeths() (map[string]bool, error) {
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		log.Errorf("Failed to list host links: %v", err)
+		return nil, err
+	}
+
+	veths := make(map[string]bool)
+	for _, link := range links {
+		name := link.Attrs().Name
+		if link.Type() != "veth" || !strings.HasPrefix(name, "nu") {
+			continue
+		}
+		veths[name] = link.Attrs().MasterIndex != 0
+	}
+	return veths, nil
+}
+
+// inspectNode correlates VRS entities and ports with host veths,
+// pod state records and active pods. A nil pod list skips K8S checks
+func inspectNode(vrsConnection client.VRSConnection, pods []*corev1.Pod, veths map[string]bool) (*InspectReport, error) {
+
+	report := &InspectReport{
+		Hostname:    hostname,
+		GeneratedAt: time.Now(),
+		PodsChecked: pods != nil,
+		Entries:     []InspectEntry{},
+	}
+
+	controllerState, err := vrsConnection.GetControllerState()
+	if err != nil {
+		log.Warnf("Unable to obtain VRS controller state: %v", err)
+	}
+	report.ControllerState = string(controllerState)
+
+	entityIDs, err := vrsConnection.GetAllEntities()
+	if err != nil {
+		log.Errorf("Failed to get entity list from VRS: %v", err)
+		return nil, err
+	}
+	portNames, err := vrsConnection.GetAllPorts()
+	if err != nil {
+		log.Errorf("Failed getting port names from VRS: %v", err)
+		return nil, err
+	}
+	states, err := client.ListPodStates(stateDir)
+	if err != nil {
+		log.Warnf("Failed to list pod state records: %v", err)
+	}
+
+	activePods := make(map[string]*corev1.Pod)
+	for _, pod := range pods {
+		activePods[client.GetEntityName(pod.Namespace, pod.Name)] = pod
+	}
+
+	statesByEntity := make(map[string]*client.PodState)
+	statesByPort := make(map[string]*client.PodState)
+	for _, state := range states {
+		statesByEntity[state.EntityUUID] = state
+		statesByPort[state.PortName] = state
+	}
+
+	vrsPorts := make(map[string]bool)
+	for _, name := range portNames {
+		vrsPorts[name] = true
+	}
+	seenPorts := make(map[string]bool)
+	seenStates := make(map[*client.PodState]bool)
+
+	for _, id := range entityIDs {
+		entry := InspectEntry{EntityUUID: id, ContainerID: id}
+		entry.EntityName, err = vrsConnection.GetEntityName(id)
+		if err != nil {
+			log.Debugf("Error obtaining entity name from OVSDB: %v", err)
+		}
+		entry.PodNamespace, entry.PodName = splitEntityName(entry.EntityName)
+
+		ports, err := vrsConnection.GetEntityPorts(id)
+		if err != nil {
+			log.Debugf("Error obtaining ports of entity %s: %v", id, err)
+		}
+
+		state := statesByEntity[id]
+		if state == nil && len(ports) > 0 {
+			state = statesByPort[ports[0]]
+		}
+		if state != nil {
+			seenStates[state] = true
+		} else {
+			entry.addIssue("no pod state record")
+		}
+
+		switch {
+		case len(ports) == 0:
+			entry.addIssue("entity has no port")
+			if state != nil {
+				entry.Port = state.PortName
+				seenPorts[state.PortName] = true
+			}
+		case len(ports) > 1:
+			entry.addIssue(fmt.Sprintf("entity has %d ports %v", len(ports), ports))
+			fallthrough
+		default:
+			entry.Port = ports[0]
+			for _, name := range ports {
+				seenPorts[name] = true
+			}
+			if !vrsPorts[entry.Port] {
+				entry.addIssue("port missing in Nuage port table")
+			}
+		}
+
+		entry.fill(vrsConnection, state, vrsPorts, veths)
+		entry.checkPod(pods != nil, activePods, state)
+		report.Entries = append(report.Entries, entry)
+	}
+
+	// Ports that do not belong to any entity
+	for _, name := range portNames {
+		if seenPorts[name] {
+			continue
+		}
+		entry := InspectEntry{Port: name}
+		state := statesByPort[name]
+		if state != nil {
+			seenStates[state] = true
+			entry.EntityUUID = state.EntityUUID
+			entry.EntityName = state.EntityName
+			entry.ContainerID = state.ContainerID
+			entry.PodNamespace, entry.PodName = state.PodNamespace, state.PodName
+			entry.addIssue("entity missing in VRS")
+		} else {
+			entry.addIssue("port has no entity")
+		}
+		seenPorts[name] = true
+
+		entry.fill(vrsConnection, state, vrsPorts, veths)
+		entry.checkPod(pods != nil && state != nil, activePods, state)
+		report.Entries = append(report.Entries, entry)
+	}
+
+	// Pod state records left without VRS entries
+	for _, state := range states {
+		if seenStates[state] {
+			continue
+		}
+		entry := InspectEntry{
+			EntityUUID:   state.EntityUUID,
+			EntityName:   state.EntityName,
+			PodNamespace: state.PodNamespace,
+			PodName:      state.PodName,
+			ContainerID:  state.ContainerID,
+			Port:         state.PortName,
+		}
+		entry.addIssue("entity and port missing in VRS")
+		seenPorts[state.PortName] = true
+
+		entry.fill(vrsConnection, state, vrsPorts, veths)
+		entry.checkPod(pods != nil, activePods, state)
+		report.Entries = append(report.Entries, entry)
+	}
+
+	// Host veths unknown to VRS and to the pod state records
+	var orphans []string
+	for name := range veths {
+		if !seenPorts[name] {
+			orphans = append(orphans, name)
+		}
+	}
+	sort.Strings(orphans)
+	for _, name := range orphans {
+		entry := InspectEntry{HostVeth: name}
+		entry.addIssue("host veth not in Nuage port table")
+		report.Entries = append(report.Entries, entry)
+	}
+
+	for _, entry := range report.Entries {
+		report.Issues += len(entry.Issues)
+	}
+	if controllerState != vrsSdk.ControllerConnected {
+		report.Issues++
+	}
+
+	return report, nil
+}
+
+// fill completes the entry with VRS port state, the pod state
+// record and the host veth, flagging what does not line up
+func (entry *InspectEntry) fill(vrsConnection client.VRSConnection, state *client.PodState, vrsPorts map[string]bool, veths map[string]bool) {
+
+	if state != nil {
+		entry.MAC = state.MAC
+		entry.IP = state.IP
+		entry.Gateway = state.Gateway
+		entry.Mask = state.Mask
+		entry.Zone = state.Metadata.Zone
+		entry.Subnet = state.Metadata.Network
+		entry.Domain = state.Metadata.Domain
+		entry.PolicyGroup = state.Metadata.PolicyGroup
+		if entry.PodName == "" {
+			entry.PodNamespace, entry.PodName = state.PodNamespace, state.PodName
+		}
+	}
+
+	if entry.Port == "" {
+		return
+	}
+
+	if attached, ok := veths[entry.Port]; ok {
+		entry.HostVeth = entry.Port
+		if !attached {
+			entry.addIssue("host veth not attached to alubr0")
+		}
+	} else {
+		entry.addIssue("host veth missing")
+	}
+
+	if !vrsPorts[entry.Port] {
+		return
+	}
+
+	portState, err := vrsConnection.GetPortState(entry.Port)
+	if err != nil {
+		log.Debugf("Unable to obtain VRS port state for port %s: %v", entry.Port, err)
+		entry.addIssue("port state unavailable")
+		return
+	}
+
+	ip, _ := portState[port.StateKeyIPAddress].(string)
+	switch {
+	case client.PortResolutionFailed(portState):
+		entry.addIssue("port resolution failed")
+	case ip == "":
+		entry.addIssue("port not resolved")
+	default:
+		if state != nil && state.IP != "" && state.IP != ip {
+			entry.addIssue(fmt.Sprintf("VRS IP %s differs from recorded IP %s", ip, state.IP))
+		}
+		entry.IP = ip
+		entry.Gateway, _ = portState[port.StateKeyGateway].(string)
+		entry.Mask, _ = portState[port.StateKeySubnetMask].(string)
+	}
+
+	metadata := client.GetPortStateMetadata(portState)
+	if metadata.Zone != "" {
+		entry.Zone = metadata.Zone
+	}
+	if metadata.Network != "" {
+		entry.Subnet = metadata.Network
+	}
+	if metadata.Domain != "" {
+		entry.Domain = metadata.Domain
+	}
+}
+
+// checkPod flags entries of pods not running on the node and
+// policy groups, labeled or assigned by Nuage K8S monitor, not applied
+func (entry *InspectEntry) checkPod(check bool, activePods map[string]*corev1.Pod, state *client.PodState) {
+
+	if !check || entry.PodName == "" {
+		return
+	}
+
+	pod, ok := activePods[client.GetEntityName(entry.PodNamespace, entry.PodName)]
+	if !ok {
+		entry.addIssue("pod not running on node")
+		return
+	}
+
+	if state != nil && state.PodUID != "" && state.PodUID != string(pod.UID) {
+		entry.addIssue("pod state record belongs to an earlier pod with the same name")
+	}
+	metadata := client.NuageMetadata{PolicyGroup: entry.PolicyGroup}
+	if state != nil {
+		metadata = state.Metadata
+	}
+	policyGroup, labeled := desiredPolicyGroup(pod, metadata)
+	if policyGroup != entry.PolicyGroup {
+		if labeled {
+			entry.addIssue(fmt.Sprintf("policy group label %q not applied", policyGroup))
+		} else {
+			entry.addIssue(fmt.Sprintf("policy group %q not applied", policyGroup))
+		}
+	}
+}
+
+func (entry *InspectEntry) addIssue(issue string) {
+	entry.Issues = append(entry.Issues, issue)
+}
+
+// splitEntityName returns pod namespace and name from a VRS
+// entity name. Legacy entity names hold the pod name only
+func splitEntityName(entityName string) (string, string) {
+
+	parts := strings.SplitN(entityName, "_", 2)
+	if len(parts) == 1 {
+		return "", entityName
+	}
+	return parts[0], parts[1]
+}
+
+// writeInspectTable prints the inspect report as a table
+func writeInspectTable(report *InspectReport, w io.Writer) error {
+
+	fmt.Fprintf(w, "Node %s, VRS controller %s\n", report.Hostname, report.ControllerState)
+	if !report.PodsChecked {
+		fmt.Fprintln(w, "Pods could not be listed from K8S API server; K8S checks skipped")
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tPOD\tCONTAINER\tPORT\tVETH\tMAC\tIP\tGATEWAY\tZONE\tSUBNET\tPOLICY GROUP\tISSUES")
+	for _, entry := range report.Entries {
+		issues := "-"
+		if len(entry.Issues) > 0 {
+			issues = strings.Join(entry.Issues, "; ")
+		}
+		ip := entry.IP
+		if ip != "" && entry.Mask != "" {
+			ip = ip + "/" + maskLength(entry.Mask)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			orDash(entry.PodNamespace), orDash(entry.PodName), orDash(shortID(entry.ContainerID)), orDash(entry.Port),
+			orDash(entry.HostVeth), orDash(entry.MAC), orDash(ip), orDash(entry.Gateway), orDash(entry.Zone),
+			orDash(entry.Subnet), orDash(entry.PolicyGroup), issues)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n%d entries, %d issues\n", len(report.Entries), report.Issues)
+	return nil
+}
+
+// maskLength returns the prefix length of a dotted subnet mask
+func maskLength(mask string) string {
+	ip := net.ParseIP(mask).To4()
+	if ip == nil {
+		return mask
+	}
+	ones, _ := net.IPMask(ip).Size()
+	return fmt.Sprintf("%d", ones)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// shortID shortens container IDs the way container runtimes do
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,191 @@
+package daemon
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	vrsSdk "github.com/nuagenetworks/libvrsdk/api"
+	"github.com/nuagenetworks/libvrsdk/api/port"
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/client/fake"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInspectNode(t *testing.T) {
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1",
+		Labels: map[string]string{k8s.PolicyGroupLabel: "pg1"}}}
+
+	tests := []struct {
+		name       string
+		setup      func(vrs *fake.VRSConnection)
+		pods       []*corev1.Pod
+		veths      map[string]bool
+		wantIssues map[string][]string
+		wantTotal  int
+	}{
+		{
+			name:       "consistent pod",
+			pods:       []*corev1.Pod{pod},
+			veths:      map[string]bool{"nu1111": true},
+			wantIssues: map[string][]string{"nu1111": nil},
+		},
+		{
+			name:  "pods not checked",
+			veths: map[string]bool{"nu1111": true},
+			setup: func(vrs *fake.VRSConnection) {
+				vrs.ControllerState = vrsSdk.ControllerDisconnected
+			},
+			wantIssues: map[string][]string{"nu1111": nil},
+			wantTotal:  1,
+		},
+		{
+			name:       "unlabeled pod with policy group of Nuage K8S monitor",
+			veths:      map[string]bool{"nu1111": true},
+			pods:       []*corev1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1"}}},
+			wantIssues: map[string][]string{"nu1111": nil},
+		},
+		{
+			name:  "veth detached and pod gone",
+			pods:  []*corev1.Pod{},
+			veths: map[string]bool{"nu1111": false, "nu9999": false},
+			wantIssues: map[string][]string{
+				"nu1111": {"host veth not attached to alubr0", "pod not running on node"},
+				"nu9999": {"host veth not in Nuage port table"},
+			},
+		},
+		{
+			name:  "unresolved port without entity",
+			pods:  []*corev1.Pod{pod},
+			veths: map[string]bool{"nu1111": true, "nu2222": true},
+			setup: func(vrs *fake.VRSConnection) {
+				_ = vrs.CreatePort("nu2222", port.Attributes{}, map[port.MetadataKey]string{})
+			},
+			wantIssues: map[string][]string{
+				"nu1111": nil,
+				"nu2222": {"port has no entity", "port not resolved"},
+			},
+		},
+		{
+			name:  "VRS entries lost",
+			pods:  []*corev1.Pod{pod},
+			veths: map[string]bool{"nu1111": true},
+			setup: func(vrs *fake.VRSConnection) {
+				_ = vrs.DestroyEntity("uuid1")
+				_ = vrs.DestroyPort("nu1111")
+			},
+			wantIssues: map[string][]string{"nu1111": {"entity and port missing in VRS"}},
+		},
+		{
+			name:  "address and policy drift",
+			veths: map[string]bool{"nu1111": true},
+			pods: []*corev1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1",
+				Labels: map[string]string{k8s.PolicyGroupLabel: "pg2"}}}},
+			setup: func(vrs *fake.VRSConnection) {
+				_ = vrs.ResolvePort("nu1111", "10.0.0.9", "10.0.0.1", "255.255.255.0")
+			},
+			wantIssues: map[string][]string{"nu1111": {
+				"VRS IP 10.0.0.9 differs from recorded IP 10.0.0.5",
+				"policy group label \"pg2\" not applied",
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer setupAudit(t, nil)()
+
+			vrs := fake.NewVRSConnection()
+			_ = vrs.CreatePort("nu1111", port.Attributes{}, map[port.MetadataKey]string{
+				port.MetadataKeyZone:    "ns1",
+				port.MetadataKeyNetwork: "subnet1",
+			})
+			_ = vrs.CreateEntity(vrsSdk.EntityInfo{UUID: "uuid1", Name: "ns1_pod1", Ports: []string{"nu1111"}})
+			_ = vrs.ResolvePort("nu1111", "10.0.0.5", "10.0.0.1", "255.255.255.0")
+			state := &client.PodState{
+				ContainerID:  "uuid1",
+				PodNamespace: "ns1",
+				PodName:      "pod1",
+				EntityName:   "ns1_pod1",
+				EntityUUID:   "uuid1",
+				PortName:     "nu1111",
+				MAC:          "00:11:22:33:44:55",
+				IP:           "10.0.0.5",
+				Metadata:     client.NuageMetadata{Zone: "ns1", Network: "subnet1", PolicyGroup: "pg1"},
+			}
+			if err := client.SavePodState(stateDir, state); err != nil {
+				t.Fatal(err)
+			}
+			if test.setup != nil {
+				test.setup(vrs)
+			}
+
+			report, err := inspectNode(vrs, test.pods, test.veths)
+			if err != nil {
+				t.Fatalf("inspect failed: %v", err)
+			}
+
+			issues := make(map[string][]string)
+			total := 0
+			for _, entry := range report.Entries {
+				name := entry.Port
+				if name == "" {
+					name = entry.HostVeth
+				}
+				issues[name] = entry.Issues
+				total += len(entry.Issues)
+			}
+			if !reflect.DeepEqual(issues, test.wantIssues) {
+				t.Errorf("expected issues %v, got %v", test.wantIssues, issues)
+			}
+			if report.Issues != total+test.wantTotal {
+				t.Errorf("expected %d issues in total, got %d", total+test.wantTotal, report.Issues)
+			}
+			if report.PodsChecked != (test.pods != nil) {
+				t.Errorf("expected pods checked %v", test.pods != nil)
+			}
+
+			var out bytes.Buffer
+			if err = writeInspectTable(report, &out); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), "ns1") || !strings.Contains(out.String(), "nu1111") {
+				t.Errorf("table misses pod ns1/pod1:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestInspectNodeWithoutPodState(t *testing.T) {
+
+	defer setupAudit(t, nil)()
+
+	vrs := fake.NewVRSConnection()
+	addEntity(vrs, "uuid1", "ns1_pod1", "nu1111")
+	_ = vrs.ResolvePort("nu1111", "10.0.0.5", "10.0.0.1", "255.255.255.0")
+
+	report, err := inspectNode(vrs, nil, map[string]bool{"nu1111": true})
+	if err != nil {
+		t.Fatalf("inspect failed: %v", err)
+	}
+	want := InspectEntry{
+		EntityUUID:   "uuid1",
+		EntityName:   "ns1_pod1",
+		PodNamespace: "ns1",
+		PodName:      "pod1",
+		ContainerID:  "uuid1",
+		Port:         "nu1111",
+		HostVeth:     "nu1111",
+		IP:           "10.0.0.5",
+		Gateway:      "10.0.0.1",
+		Mask:         "255.255.255.0",
+		Issues:       []string{"no pod state record"},
+	}
+	if len(report.Entries) != 1 || !reflect.DeepEqual(report.Entries[0], want) {
+		t.Errorf("expected entries [%+v], got %+v", want, report.Entries)
+	}
+}
@@ -6,19 +6,18 @@ import (
 	"os/exec"
 	"os/signal"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	vrsSdk "github.com/nuagenetworks/libvrsdk/api"
 	"github.com/nuagenetworks/libvrsdk/api/port"
 	"github.com/nuagenetworks/nuage-cni/client"
 	"github.com/nuagenetworks/nuage-cni/config"
 	"github.com/nuagenetworks/nuage-cni/k8s"
+	"github.com/nuagenetworks/nuage-cni/kubemon"
 	log "github.com/sirupsen/logrus"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
-	kclient "k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 var interruptChannel chan bool
@@ -29,6 +28,9 @@ var staleEntryTimeout int64
 var isAtomic bool
 var hostname string
 var orchestratorType string
+var auditDryRun bool
+var auditReportFile string
+var stateDir string
 
 // filter on host name
 const (
@@ -37,10 +39,11 @@ const (
 
 // cleanupStaleEntities will clear stale
 // entity entries from Nuage tables
-func cleanupStaleEntities(vrsConnection vrsSdk.VRSConnection, orchestrator string) error {
+func cleanupStaleEntities(vrsConnection client.VRSConnection, orchestrator string) error {
 
 	log.Debugf("Cleaning up stale ports and entities in VRS as a part of the audit daemon")
 	var err error
+	currentReport = newAuditReport()
 	var portList []string
 	var k8sActivePortList []string
 	var k8sActivePodNames []string
@@ -67,13 +70,15 @@ func cleanupStaleEntities(vrsConnection vrsSdk.VRSConnection, orchestrator strin
 		return err
 	}
 
-	k8sEntityMap, err := getActiveK8SPods(orchestrator)
+	k8sActivePods, err := getActiveK8SPods(orchestrator)
 	if err != nil {
 		log.Errorf("Error occured while obtaining currently active Pods list: %v", err)
 		return err
 	}
-	log.Debugf("Currently active k8s pods mapping : %v", k8sEntityMap)
-	for _, name := range k8sEntityMap {
+	setDeletionBudget(len(k8sActivePods), len(vrsEntityNames))
+	entityNames, legacyNames := getActiveEntityNames(vrsConnection, k8sActivePods)
+	log.Debugf("Currently active k8s pod entities : %v", entityNames)
+	for _, name := range entityNames {
 		k8sActivePodNames = append(k8sActivePodNames, name)
 		portList, err = vrsConnection.GetEntityPortsByName(name)
 		if err != nil {
@@ -81,6 +86,19 @@ func cleanupStaleEntities(vrsConnection vrsSdk.VRSConnection, orchestrator strin
 		}
 		k8sActivePortList = append(k8sActivePortList, portList...)
 	}
+	for _, name := range legacyNames {
+		k8sActivePodNames = append(k8sActivePodNames, name)
+		portList, err = vrsConnection.GetEntityPortsByName(name)
+		if err == nil {
+			log.Debugf("Found VRS entity %s named after pod name only", name)
+		}
+		k8sActivePortList = append(k8sActivePortList, portList...)
+	}
+
+	err = restoreMissingEntries(vrsConnection, vrsPortsList, k8sActivePods)
+	if err != nil {
+		log.Warnf("Restoring missing VRS entries failed with error %v", err)
+	}
 
 	err = cleanupVMTable(vrsConnection, vrsEntityNames, k8sActivePodNames)
 	if err != nil {
@@ -92,87 +110,75 @@ func cleanupStaleEntities(vrsConnection vrsSdk.VRSConnection, orchestrator strin
 		log.Warnf("Cleaning up port table failed with error %v", err)
 	}
 
-	return err
-}
-
-// getStaleEntityEntriesForDeletion will determine what entity entries
-// need to be actually cleaned up from VRS
-func getStaleEntityEntriesForDeletion(ids []string) []string {
+	err = cleanupOrphanVeths(vrsConnection, vrsPortsList, k8sActivePods)
+	if err != nil {
+		log.Warnf("Cleaning up orphan veths failed with error %v", err)
+	}
 
-	var deleteEntityList []string
-	currentTime := (time.Now().UnixNano()) / 1000000
-	for _, staleID := range ids {
-		if _, ok := staleEntityMap[staleID]; !ok {
-			staleEntityMap[staleID] = currentTime
-		}
+	checkSkippedDeletions()
 
-		timeDiff := (currentTime - staleEntityMap[staleID]) / 1000
-		if timeDiff >= staleEntryTimeout {
-			deleteEntityList = append(deleteEntityList, staleID)
+	if persistAuditState {
+		if stateErr := saveAuditState(); stateErr != nil {
+			log.Warnf("Saving audit state failed with error %v", stateErr)
 		}
 	}
 
-	// Delete resolved entities earlier marked as stale
-	// from stale entity map
-	keyFound := false
-	for key := range staleEntityMap {
-		for _, staleID := range ids {
-			if key == staleID {
-				log.Debugf("Entry %s is still not resolved or is a stale entry", key)
-				keyFound = true
-				break
-			}
-		}
-		if !keyFound {
-			delete(staleEntityMap, key)
-		} else {
-			keyFound = false
+	if auditReportFile != "" {
+		if reportErr := currentReport.write(auditReportFile); reportErr != nil {
+			log.Warnf("Writing audit report failed with error %v", reportErr)
 		}
 	}
 
-	return deleteEntityList
+	return err
 }
 
-// getStalePortEntriesForDeletion will determine what port entries
-// need to be actually cleaned up from VRS
-func getStalePortEntriesForDeletion(ids []string) []string {
-
-	var deletePortList []string
+// getStaleEntriesForDeletion records the time stale ids were first
+// seen in the stale map, prunes ids that are no longer stale from it
+// and returns the ids that have been stale for the timeout
+func getStaleEntriesForDeletion(staleMap map[string]int64, ids []string, currentTime int64) []string {
 
-	currentTime := (time.Now().UnixNano()) / 1000000
+	var deleteList []string
 	for _, staleID := range ids {
-		if _, ok := stalePortMap[staleID]; !ok {
-			stalePortMap[staleID] = currentTime
+		if _, ok := staleMap[staleID]; !ok {
+			staleMap[staleID] = currentTime
 		}
 
-		timeDiff := (currentTime - stalePortMap[staleID]) / 1000
+		timeDiff := (currentTime - staleMap[staleID]) / 1000
 		if timeDiff >= staleEntryTimeout {
-			deletePortList = append(deletePortList, staleID)
+			deleteList = append(deleteList, staleID)
 		}
 	}
 
-	// Delete resolved alubr0 ports earlier marked as stale
-	// from stale port map
-	keyFound := false
-	for key := range stalePortMap {
-		for _, staleID := range ids {
-			if key == staleID {
-				log.Debugf("Entry %s is still not resolved or is a stale entry", key)
-				keyFound = true
-				break
-			}
-		}
-		if !keyFound {
-			delete(staleEntityMap, key)
+	// Delete resolved entries earlier marked as stale
+	// from stale map
+	for key := range staleMap {
+		if containsString(ids, key) {
+			log.Debugf("Entry %s is still not resolved or is a stale entry", key)
 		} else {
-			keyFound = false
+			delete(staleMap, key)
 		}
 	}
 
-	return deletePortList
+	return deleteList
+}
+
+// getStaleEntityEntriesForDeletion will determine what entity entries
+// need to be actually cleaned up from VRS
+func getStaleEntityEntriesForDeletion(ids []string) []string {
+	return getStaleEntriesForDeletion(staleEntityMap, ids, currentTimeMillis())
 }
 
-func auditEntity(vrsConnection vrsSdk.VRSConnection, id string) bool {
+// getStalePortEntriesForDeletion will determine what port entries
+// need to be actually cleaned up from VRS
+func getStalePortEntriesForDeletion(ids []string) []string {
+	return getStaleEntriesForDeletion(stalePortMap, ids, currentTimeMillis())
+}
+
+func currentTimeMillis() int64 {
+	return (time.Now().UnixNano()) / 1000000
+}
+
+func auditEntity(vrsConnection client.VRSConnection, id string) bool {
 
 	vrsPortsList, err := vrsConnection.GetEntityPorts(id)
 	if err != nil {
@@ -189,28 +195,38 @@ func auditEntity(vrsConnection vrsSdk.VRSConnection, id string) bool {
 }
 
 // cleanupVMTable removes stale entity entries from Nuage VM table
-func cleanupVMTable(vrsConnection vrsSdk.VRSConnection, vrsEntityNameList []string, k8sActivePodNames []string) error {
+func cleanupVMTable(vrsConnection client.VRSConnection, vrsEntityNameList []string, k8sActivePodNames []string) error {
 
 	var err error
 	var deleteStaleEntitiesList []string
 	log.Debugf("Cleaning up stale entity entries from Nuage VM table")
 	staleNames := computeStaleEntitiesDiff(vrsEntityNameList, k8sActivePodNames)
 	deleteStaleEntitiesList = getStaleEntityEntriesForDeletion(staleNames)
+	for _, staleName := range staleNames {
+		podNs, podName := client.ParseEntityName(staleName)
+		reason := fmt.Sprintf("no active pod %s under namespace %s on node", podName, podNs)
+		if podNs == "" {
+			reason = fmt.Sprintf("no active pod named %s on node", podName)
+		}
+		if !auditEntity(vrsConnection, staleName) {
+			reason = "entity not created by Nuage CNI; skipped by audit"
+		}
+		ports, _ := vrsConnection.GetEntityPortsByName(staleName)
+		currentReport.addCandidate(vrsConnection, candidateEntity, staleName, reason, staleEntityMap[staleName], containsString(deleteStaleEntitiesList, staleName), ports)
+	}
+
+	if auditDryRun {
+		log.Infof("Audit dry run: stale entities that would be cleaned up from VRS %v", deleteStaleEntitiesList)
+		return nil
+	}
+
 	for _, staleName := range deleteStaleEntitiesList {
 		doAudit := auditEntity(vrsConnection, staleName)
 		if doAudit {
-			log.Infof("Removing stale entity entry %s", staleName)
-			ports, err := vrsConnection.GetEntityPortsByName(staleName)
-			if err != nil {
-				log.Debugf("Failed getting port names from VRS: %v", err)
+			if !allowDeletion(candidateEntity, staleName) {
+				continue
 			}
-			err = vrsConnection.DestroyEntityByVMName(staleName)
-			if err != nil {
-				log.Warnf("Unable to delete entry from nuage VM table: %v", err)
-			} else {
-				sendStaleEntryDeleteNotification(vrsConnection, staleName, ports)
-			}
-			delete(staleEntityMap, staleName)
+			err = removeStaleEntity(vrsConnection, staleName, recordedPodUID(staleName))
 		} else {
 			log.Debugf("Skipping Nuage audit as this is not CNI created entity entry")
 			return nil
@@ -221,9 +237,29 @@ func cleanupVMTable(vrsConnection vrsSdk.VRSConnection, vrsEntityNameList []stri
 	return err
 }
 
+// removeStaleEntity removes an entity entry from Nuage VM table
+// and notifies monitor about the deletion of the pod with the UID
+func removeStaleEntity(vrsConnection client.VRSConnection, staleName string, podUID string) error {
+
+	log.Infof("Removing stale entity entry %s", staleName)
+	ports, err := vrsConnection.GetEntityPortsByName(staleName)
+	if err != nil {
+		log.Debugf("Failed getting port names from VRS: %v", err)
+	}
+	err = vrsConnection.DestroyEntityByVMName(staleName)
+	if err != nil {
+		log.Warnf("Unable to delete entry from nuage VM table: %v", err)
+	} else {
+		sendStaleEntryDeleteNotification(vrsConnection, staleName, podUID, ports)
+	}
+	delete(staleEntityMap, staleName)
+
+	return err
+}
+
 // sendStaleEntryDeleteNotification notifies monitor about
 // stale VRS entity and port entry deletion
-func sendStaleEntryDeleteNotification(vrsConnection vrsSdk.VRSConnection, entityName string, ports []string) {
+func sendStaleEntryDeleteNotification(vrsConnection client.VRSConnection, entityName string, podUID string, ports []string) {
 
 	var err error
 
@@ -235,43 +271,48 @@ func sendStaleEntryDeleteNotification(vrsConnection vrsSdk.VRSConnection, entity
 		}
 	}
 
-	if _, ok := portInfo[port.StateKeyNuageZone].(string); ok {
-		log.Debugf("Sending delete notification for entity %s for zone %s", entityName, portInfo[port.StateKeyNuageZone].(string))
+	nuageMetadata := client.GetPortStateMetadata(portInfo)
+	if nuageMetadata.Zone != "" {
+		podNs, podName := client.ParseEntityName(entityName)
+		pod := &kubemon.Pod{Name: podName, Zone: nuageMetadata.Zone, Subnet: nuageMetadata.Network, Domain: nuageMetadata.Domain}
+		log.Debugf("Sending delete notification for entity %s for zone %s", entityName, pod.Zone)
 		// Send pod deletion notification to Nuage monitor
-		err = k8s.SendPodDeletionNotification(entityName, portInfo[port.StateKeyNuageZone].(string), orchestratorType)
-		if err != nil {
-			log.Errorf("Error occured while sending delete notification for pod %s", entityName)
+		err = k8s.SendPodDeletionNotification(pod, orchestratorType)
+		if err != nil && !kubemon.IsTransient(err) {
+			log.Errorf("Error occured while sending delete notification for pod %s; not retrying it", podName)
+		} else if err != nil {
+			log.Errorf("Error occured while sending delete notification for pod %s; queueing it for retry", podName)
+			queueDeletionNotification(podNs, podUID, pod)
 		}
 	}
 }
 
 // cleanupPortTable removes stale port entries from Nuage VM table
-func cleanupPortTable(vrsConnection vrsSdk.VRSConnection, vrsPortsList []string, entityPortList []string) error {
+func cleanupPortTable(vrsConnection client.VRSConnection, vrsPortsList []string, entityPortList []string) error {
 	var err error
 	var deleteStalePortsList []string
 	log.Debugf("Cleaning up stale entity entries from Nuage VM table")
 	stalePorts := computeStaleEntitiesDiff(vrsPortsList, entityPortList)
 	deleteStalePortsList = getStalePortEntriesForDeletion(stalePorts)
-	for _, stalePort := range deleteStalePortsList {
-		if strings.HasPrefix(stalePort, "nu") {
-			log.Infof("Removing stale port %s", stalePort)
-			err = vrsConnection.DestroyPort(stalePort)
-			if err != nil {
-				log.Warnf("Unable to delete port from Nuage Port table: %v", err)
-			}
+	for _, stalePort := range stalePorts {
+		reason := "port not attached to any active pod entity"
+		if !strings.HasPrefix(stalePort, "nu") {
+			reason = "port not created by Nuage CNI; skipped by audit"
+		}
+		currentReport.addCandidate(vrsConnection, candidatePort, stalePort, reason, stalePortMap[stalePort], containsString(deleteStalePortsList, stalePort), []string{stalePort})
+	}
 
-			// Purging out the veth port from VRS alubr0
-			err = vrsConnection.RemovePortFromAlubr0(stalePort)
-			if err != nil {
-				log.Warnf("Unable to delete veth port as part of cleanup from alubr0: %v", err)
-			}
+	if auditDryRun {
+		log.Infof("Audit dry run: stale ports that would be cleaned up from VRS %v", deleteStalePortsList)
+		return nil
+	}
 
-			err = client.DeleteVethPair(stalePort, "eth0")
-			if err != nil {
-				log.Warnf("Failed to clear veth ports from VRS: %v", err)
+	for _, stalePort := range deleteStalePortsList {
+		if strings.HasPrefix(stalePort, "nu") {
+			if !allowDeletion(candidatePort, stalePort) {
+				continue
 			}
-
-			delete(stalePortMap, stalePort)
+			err = removeStalePort(vrsConnection, stalePort)
 		} else {
 			log.Debugf("Skipping Nuage audit as this is not CNI created port entry")
 			return nil
@@ -282,6 +323,79 @@ func cleanupPortTable(vrsConnection vrsSdk.VRSConnection, vrsPortsList []string,
 	return err
 }
 
+// removeStalePort removes a port entry from Nuage Port table
+// along with its alubr0 port and veth pair
+func removeStalePort(vrsConnection client.VRSConnection, stalePort string) error {
+
+	log.Infof("Removing stale port %s", stalePort)
+	err := vrsConnection.DestroyPort(stalePort)
+	if err != nil {
+		log.Warnf("Unable to delete port from Nuage Port table: %v", err)
+	}
+
+	// Purging out the veth port from VRS alubr0
+	err = vrsConnection.RemovePortFromAlubr0(stalePort)
+	if err != nil {
+		log.Warnf("Unable to delete veth port as part of cleanup from alubr0: %v", err)
+	}
+
+	err = client.DeleteHostVeth(stalePort)
+	if err != nil {
+		log.Warnf("Failed to clear veth ports from VRS: %v", err)
+	}
+
+	delete(stalePortMap, stalePort)
+
+	return err
+}
+
+// cleanupDeletedPod removes VRS entries left behind
+// for a pod deleted from K8S API server
+func cleanupDeletedPod(vrsConnection client.VRSConnection, pod *corev1.Pod) {
+
+	// A pod with the same name could have been created since
+	if _, err := podLister.Pods(pod.Namespace).Get(pod.Name); err == nil {
+		log.Debugf("Pod %s under namespace %s exists again; skipping cleanup", pod.Name, pod.Namespace)
+		return
+	}
+
+	entityName := client.GetEntityName(pod.Namespace, pod.Name)
+	ports, err := vrsConnection.GetEntityPortsByName(entityName)
+	if err != nil || len(ports) == 0 {
+		// Entities created by earlier plugin versions are named after
+		// the pod only and are cleaned up only if no other active pod
+		// has the same name
+		if pods, _ := getActiveK8SPods(orchestratorType); containsString(getPodNames(pods), pod.Name) {
+			log.Debugf("No VRS entries left behind for deleted pod %s under namespace %s", pod.Name, pod.Namespace)
+			return
+		}
+		entityName = pod.Name
+		ports, err = vrsConnection.GetEntityPortsByName(entityName)
+		if err != nil || len(ports) == 0 {
+			log.Debugf("No VRS entries left behind for deleted pod %s under namespace %s", pod.Name, pod.Namespace)
+			return
+		}
+	}
+
+	for _, port := range ports {
+		if !strings.HasPrefix(port, "nu") {
+			log.Debugf("Skipping cleanup of deleted pod %s as this is not CNI created entity entry", pod.Name)
+			return
+		}
+	}
+
+	if auditDryRun {
+		log.Infof("Audit dry run: VRS entries %v left behind for deleted pod %s under namespace %s would be cleaned up", ports, pod.Name, pod.Namespace)
+		return
+	}
+
+	log.Infof("Cleaning up VRS entries left behind for deleted pod %s under namespace %s", pod.Name, pod.Namespace)
+	_ = removeStaleEntity(vrsConnection, entityName, string(pod.UID))
+	for _, port := range ports {
+		_ = removeStalePort(vrsConnection, port)
+	}
+}
+
 // computeStalePortsEntitiesDiff will help determine the
 // stale ports and entities in Nuage tables
 func computeStaleEntitiesDiff(vrsData, orchestratorData []string) []string {
@@ -301,6 +415,25 @@ func computeStaleEntitiesDiff(vrsData, orchestratorData []string) []string {
 	return res
 }
 
+// getPodNames returns names of the given pods
+func getPodNames(pods []*corev1.Pod) []string {
+	var names []string
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	return names
+}
+
+// containsString checks if the string is present in the list
+func containsString(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
+
 // handleDaemonInterrupt will handle any external interrupts
 // to audit daemon and handle stale connection/entities cleanup
 // to have a graceful daemon exit
@@ -318,8 +451,8 @@ func handleDaemonInterrupt() {
 			s := <-signalChannel
 			switch s {
 			case syscall.SIGHUP:
-				log.Errorf("SIGHUP signal interrupted Nuage CNI daemon")
-				interruptChannel <- true
+				log.Infof("SIGHUP signal received; reloading Nuage CNI daemon configuration")
+				requestReload()
 			case syscall.SIGINT:
 				log.Errorf("SIGINT signal interrupted Nuage CNI daemon")
 				interruptChannel <- true
@@ -336,37 +469,91 @@ func handleDaemonInterrupt() {
 	}()
 }
 
-// MonitorAgent will be run as a background audit daemon
-// on k8s agent nodes to clean up stale entities/ports
-// on agent nodes
-func MonitorAgent(config *config.Config, orchestrator string) error {
+// initAudit initializes audit state shared by
+// the audit daemon and one shot audit runs
+func initAudit(config *config.Config, orchestrator string, dryRun bool, reportFile string) error {
 
 	var err error
-	var vrsConnection vrsSdk.VRSConnection
-	interruptChannel = make(chan bool)
-
 	staleEntityMap = make(map[string]int64)
 	stalePortMap = make(map[string]int64)
-	staleEntryTimeout = config.StaleEntryTimeout
+	staleVethMap = make(map[string]int64)
 	orchestratorType = orchestrator
+	stateDir = config.StateDir
+	outboxDir = config.OutboxDir
+	applyAuditConfig(config)
+	auditDryRun = dryRun
+	auditReportFile = reportFile
 
 	hostname, err = os.Hostname()
 	if err != nil {
 		log.Errorf("finding hostname failed with error: %v", err)
 		return err
 	}
-	for {
-		vrsConnection, err = client.ConnectToVRSOVSDB(config)
-		if err != nil {
-			log.Errorf("Error connecting to VRS. Will re-try connection in 5 seconds")
-		} else {
-			break
-		}
-		time.Sleep(time.Duration(5) * time.Second)
+
+	// Resuming stale entry countdowns from an earlier run
+	err = loadAuditState()
+	if err != nil {
+		log.Warnf("Unable to load audit state; stale entry tracking starts afresh: %v", err)
+	}
+
+	return nil
+}
+
+// applyAuditConfig applies the audit parameters that
+// can be changed while the audit daemon is running
+func applyAuditConfig(config *config.Config) {
+	staleEntryTimeout = config.StaleEntryTimeout
+	auditDryRun = config.AuditDryRun
+	auditReportFile = config.AuditReportFile
+	emptyPodsThreshold = config.AuditEmptyPodsThreshold
+	maxDeletions = config.AuditMaxDeletions
+	maxDeletionPercent = config.AuditMaxDeletionPercent
+	nuageSiteID = config.NuageSiteID
+	vrsBridge = config.VRSBridge
+	vrsReconnectMaxInterval = time.Duration(config.VRSReconnectMaxInterval) * time.Second
+	controllerDownThreshold = config.ControllerDownThreshold
+	controllerUpThreshold = config.ControllerUpThreshold
+	nodeTaintOnDisconnect = config.NodeTaintOnDisconnect
+}
+
+// MonitorAgent will be run as a background audit daemon
+// on k8s agent nodes to clean up stale entities/ports
+// on agent nodes
+func MonitorAgent(config *config.Config, orchestrator string) error {
+
+	var err error
+	var vrsConnection client.VRSConnection
+	interruptChannel = make(chan bool)
+	reloadChannel = make(chan bool, 1)
+
+	err = initAudit(config, orchestrator, config.AuditDryRun, config.AuditReportFile)
+	if err != nil {
+		return err
+	}
+	persistAuditState = true
+	if auditDryRun {
+		log.Infof("Audit daemon is running in dry run mode; stale entries will only be reported in %s", auditReportFile)
+	}
+	vrsDisconnectChannel = make(chan uint64, 1)
+	startHealthServer(config.HealthAddress)
+	vrsConnection, err = reconnectToVRS(config)
+	if err != nil {
+		return err
 	}
 
 	log.Infof("Starting Nuage CNI monitoring daemon for %s node with hostname %s", orchestrator, hostname)
 
+	stopChannel := make(chan struct{})
+	defer close(stopChannel)
+	err = startPodInformer(stopChannel)
+	if err != nil {
+		log.Errorf("Error starting pod informer: %v", err)
+		return err
+	}
+	if !cache.WaitForCacheSync(stopChannel, podInformerSynced) {
+		return fmt.Errorf("Unable to sync pods on node %s from k8s api server", hostname)
+	}
+
 	// Cleaning up stale ports/entities when audit daemon starts
 	err = cleanupStaleEntities(vrsConnection, orchestrator)
 	if err != nil {
@@ -384,6 +571,10 @@ func MonitorAgent(config *config.Config, orchestrator string) error {
 
 	vrsStaleEntriesCleanupTicker := time.NewTicker(time.Duration(config.MonitorInterval) * time.Second)
 	vrsConnectionCheckTicker := time.NewTicker(time.Duration(config.VRSConnectionCheckTimer) * time.Second)
+	outboxTicker := time.NewTicker(outboxDrainInterval)
+	controllerCheckTicker := time.NewTicker(time.Duration(config.ControllerCheckTimer) * time.Second)
+	checkControllerState(vrsConnection)
+	podReadinessTicker := time.NewTicker(podReadinessCheckInterval)
 
 	handleDaemonInterrupt()
 
@@ -394,55 +585,93 @@ func MonitorAgent(config *config.Config, orchestrator string) error {
 			if err != nil {
 				log.Errorf("Error cleaning up stale entities and ports on VRS")
 			}
+			reconcileCachedPods()
+			resyncPodPolicies(vrsConnection)
+		case <-outboxTicker.C:
+			_ = drainOutbox(false)
+		case <-controllerCheckTicker.C:
+			checkControllerState(vrsConnection)
+		case <-podReadinessTicker.C:
+			checkPodNetworkReadiness(vrsConnection)
+		case pod := <-podDeletionChannel:
+			cleanupDeletedPod(vrsConnection, pod)
+		case pod := <-podUpdateChannel:
+			updatePodPolicy(vrsConnection, pod)
+		case generation := <-vrsDisconnectChannel:
+			if generation != atomic.LoadUint64(&vrsGeneration) {
+				break
+			}
+			log.Errorf("VRS connection is down; will retry connection")
+			vrsConnection, err = handleVRSDisconnect(vrsConnection, config, orchestrator)
+			if err != nil {
+				return err
+			}
 		case <-vrsConnectionCheckTicker.C:
 			_, err := vrsConnection.GetAllEntities()
 			if err != nil {
-				log.Errorf("VRS connection is down; will retry connection")
-				vrsConnection, err = client.ConnectToVRSOVSDB(config)
+				log.Errorf("VRS connection check failed; will retry connection")
+				vrsConnection, err = handleVRSDisconnect(vrsConnection, config, orchestrator)
 				if err != nil {
-					log.Errorf("Error connecting to VRS. Retry connection in %d seconds", config.VRSConnectionCheckTimer)
-				} else {
-					log.Infof("VRS connection is restored")
+					return err
 				}
 			}
+		case <-reloadChannel:
+			newConfig, err := loadNewConfig(config)
+			if err == nil {
+				err = applyConfig(newConfig)
+			}
+			if err != nil {
+				log.Errorf("Rejected new audit daemon configuration; keeping current settings: %v", err)
+				break
+			}
+			if newConfig.MonitorInterval != config.MonitorInterval {
+				vrsStaleEntriesCleanupTicker.Stop()
+				vrsStaleEntriesCleanupTicker = time.NewTicker(time.Duration(newConfig.MonitorInterval) * time.Second)
+			}
+			if newConfig.VRSConnectionCheckTimer != config.VRSConnectionCheckTimer {
+				vrsConnectionCheckTicker.Stop()
+				vrsConnectionCheckTicker = time.NewTicker(time.Duration(newConfig.VRSConnectionCheckTimer) * time.Second)
+			}
+			if newConfig.ControllerCheckTimer != config.ControllerCheckTimer {
+				controllerCheckTicker.Stop()
+				controllerCheckTicker = time.NewTicker(time.Duration(newConfig.ControllerCheckTimer) * time.Second)
+			}
+			config = newConfig
+			checkControllerState(vrsConnection)
 		case <-interruptChannel:
 			log.Errorf("Daemon was interrupted by an external interrupt; will cleanup before exiting")
-			vrsConnection.Disconnect()
+			disconnectFromVRS(vrsConnection)
 			return fmt.Errorf("Daemon was interrupted by an external interrupt")
 		}
 	}
 }
 
-// getActiveK8SPods will help obtain UUID list
-// for currently active pods on k8s cluster
-func getActiveK8SPods(orchestrator string) (map[string]string, error) {
+// RunAudit runs a single audit cycle and writes the audit report
+// to the given file. With dry run set no VRS entry is deleted
+func RunAudit(config *config.Config, orchestrator string, dryRun bool, reportFile string) error {
 
-	log.Infof("Obtaining currently active K8S pods on agent node")
-
-	// creates the in-cluster config
-	config, err := rest.InClusterConfig()
+	err := initAudit(config, orchestrator, dryRun, reportFile)
 	if err != nil {
-		log.Errorf("creating the in-cluster config failed %v", err)
-		return map[string]string{}, err
+		return err
 	}
-	// creates the clientset
-	kubeClient, err := kclient.NewForConfig(config)
+
+	vrsConnection, err := connectVRS(config)
 	if err != nil {
-		log.Errorf("Error trying to create kubeclient %v", err)
-		return map[string]string{}, err
+		log.Errorf("Error connecting to VRS: %v", err)
+		return err
 	}
-	selector := fields.OneTermEqualSelector(PodHostField, hostname).String()
-	listOpts := metav1.ListOptions{FieldSelector: selector}
-	pods, err := kubeClient.CoreV1().Pods(metav1.NamespaceAll).List(listOpts)
+	defer vrsConnection.Disconnect()
+
+	stopChannel := make(chan struct{})
+	defer close(stopChannel)
+	err = startPodInformer(stopChannel)
 	if err != nil {
-		log.Errorf("Error occured while fetching pods from k8s api server")
-		return map[string]string{}, err
+		log.Errorf("Error starting pod informer: %v", err)
+		return err
 	}
-
-	entityMap := make(map[string]string)
-	for _, pod := range pods.Items {
-		entityMap[string(pod.UID)] = pod.Name
+	if !cache.WaitForCacheSync(stopChannel, podInformerSynced) {
+		return fmt.Errorf("Unable to sync pods on node %s from k8s api server", hostname)
 	}
 
-	return entityMap, err
+	return cleanupStaleEntities(vrsConnection, orchestrator)
 }
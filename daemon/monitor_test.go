@@ -0,0 +1,167 @@
+package daemon
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestComputeStaleEntitiesDiff(t *testing.T) {
+
+	tests := []struct {
+		name             string
+		vrsData          []string
+		orchestratorData []string
+		expected         []string
+	}{
+		{
+			name:             "no vrs entries",
+			vrsData:          nil,
+			orchestratorData: []string{"ns_pod1"},
+			expected:         nil,
+		},
+		{
+			name:             "all entries active",
+			vrsData:          []string{"ns_pod1", "ns_pod2"},
+			orchestratorData: []string{"ns_pod2", "ns_pod1"},
+			expected:         nil,
+		},
+		{
+			name:             "stale entries",
+			vrsData:          []string{"ns_pod1", "ns_pod2", "ns_pod3"},
+			orchestratorData: []string{"ns_pod2"},
+			expected:         []string{"ns_pod1", "ns_pod3"},
+		},
+		{
+			name:             "no active pods",
+			vrsData:          []string{"ns_pod1"},
+			orchestratorData: nil,
+			expected:         []string{"ns_pod1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := computeStaleEntitiesDiff(test.vrsData, test.orchestratorData)
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestGetStaleEntriesForDeletion(t *testing.T) {
+
+	const now = int64(1000000000)
+
+	tests := []struct {
+		name            string
+		timeout         int64
+		staleMap        map[string]int64
+		ids             []string
+		expectedDelete  []string
+		expectedTracked map[string]int64
+	}{
+		{
+			name:            "new stale entries are tracked",
+			timeout:         600,
+			staleMap:        map[string]int64{},
+			ids:             []string{"a", "b"},
+			expectedDelete:  nil,
+			expectedTracked: map[string]int64{"a": now, "b": now},
+		},
+		{
+			name:            "entries stale for the timeout are deleted",
+			timeout:         600,
+			staleMap:        map[string]int64{"a": now - 600*1000, "b": now - 599*1000},
+			ids:             []string{"a", "b"},
+			expectedDelete:  []string{"a"},
+			expectedTracked: map[string]int64{"a": now - 600*1000, "b": now - 599*1000},
+		},
+		{
+			name:            "resolved entries are pruned",
+			timeout:         600,
+			staleMap:        map[string]int64{"a": now - 1000, "b": now - 2000},
+			ids:             []string{"b"},
+			expectedDelete:  nil,
+			expectedTracked: map[string]int64{"b": now - 2000},
+		},
+		{
+			name:            "zero timeout deletes right away",
+			timeout:         0,
+			staleMap:        map[string]int64{},
+			ids:             []string{"a"},
+			expectedDelete:  []string{"a"},
+			expectedTracked: map[string]int64{"a": now},
+		},
+		{
+			name:            "no stale entries empties tracking",
+			timeout:         600,
+			staleMap:        map[string]int64{"a": now - 1000},
+			ids:             nil,
+			expectedDelete:  nil,
+			expectedTracked: map[string]int64{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			staleEntryTimeout = test.timeout
+			result := getStaleEntriesForDeletion(test.staleMap, test.ids, now)
+			sort.Strings(result)
+			if !reflect.DeepEqual(result, test.expectedDelete) {
+				t.Errorf("expected deletion of %v, got %v", test.expectedDelete, result)
+			}
+			if !reflect.DeepEqual(test.staleMap, test.expectedTracked) {
+				t.Errorf("expected tracked entries %v, got %v", test.expectedTracked, test.staleMap)
+			}
+		})
+	}
+}
+
+func TestStalePortPruningKeepsEntityMap(t *testing.T) {
+
+	staleEntryTimeout = 600
+	staleEntityMap = map[string]int64{"ns_pod1": 1, "nuport1": 1}
+	stalePortMap = map[string]int64{"nuport1": 1, "nuport2": 1}
+
+	getStalePortEntriesForDeletion([]string{"nuport2"})
+
+	if _, ok := stalePortMap["nuport1"]; ok {
+		t.Errorf("resolved port nuport1 was not pruned from stale port map")
+	}
+	if _, ok := stalePortMap["nuport2"]; !ok {
+		t.Errorf("stale port nuport2 was pruned from stale port map")
+	}
+	if len(staleEntityMap) != 2 {
+		t.Errorf("stale entity map was modified while pruning ports: %v", staleEntityMap)
+	}
+}
+
+func TestVRSDisconnectGenerations(t *testing.T) {
+
+	vrsDisconnectChannel = make(chan uint64, 1)
+	vrsGeneration = 2
+	defer func() { vrsDisconnectChannel, vrsGeneration = nil, 0 }()
+
+	// A stale disconnect must not take the slot of the current one
+	vrsDisconnectHandler{generation: 1}.Disconnected(nil)
+	vrsDisconnectHandler{generation: 2}.Disconnected(nil)
+	select {
+	case generation := <-vrsDisconnectChannel:
+		if generation != 2 {
+			t.Errorf("expected disconnect of generation 2, got %d", generation)
+		}
+	default:
+		t.Fatal("expected disconnect of current connection to be sent")
+	}
+
+	// Disconnects queued before a reconnect are dropped
+	vrsDisconnectHandler{generation: 2}.Disconnected(nil)
+	vrsGeneration = 3
+	drainVRSDisconnects()
+	vrsDisconnectHandler{generation: 3}.Disconnected(nil)
+	if generation := <-vrsDisconnectChannel; generation != 3 {
+		t.Errorf("expected disconnect of generation 3, got %d", generation)
+	}
+}
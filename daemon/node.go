@@ -0,0 +1,245 @@
+package daemon
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	vrsSdk "github.com/nuagenetworks/libvrsdk/api"
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// Node condition and taint reflecting whether
+// VRS on the node is connected to its VSC controller
+const (
+	NodeNetworkReady          corev1.NodeConditionType = "NuageNetworkReady"
+	NodeNetworkUnavailable                             = "nuage.io/network-unavailable"
+	nodeNetworkReadyReason                             = "NuageVSCConnected"
+	nodeNetworkNotReadyReason                          = "NuageVSCDisconnected"
+)
+
+var controllerDownThreshold int
+var controllerUpThreshold int
+var nodeTaintOnDisconnect bool
+
+// nodeNetworkReady is 1 while the node is reported as having a
+// functional Nuage network, for nuage_cni_node_network_ready
+var nodeNetworkReady int32
+
+// controllerHealth debounces VRS controller state: the node is
+// reported as not ready only after controllerDownThreshold failed
+// checks in a row and as ready again after controllerUpThreshold
+// successful ones. Until the first such streak, e.g. while VRS is
+// still connecting to its controller after a restart, the state
+// is unknown and nothing is reported
+type controllerHealth struct {
+	known     bool
+	ready     bool
+	candidate bool
+	streak    int
+	applied   bool
+	appliedOn nodeNetworkState
+}
+
+// nodeNetworkState is what the daemon last applied to its node
+type nodeNetworkState struct {
+	ready   bool
+	tainted bool
+}
+
+var vscHealth controllerHealth
+
+// observe records a controller state check and returns whether
+// the node network is considered ready, and whether that is known
+func (h *controllerHealth) observe(connected bool) (bool, bool) {
+
+	if h.known && connected == h.ready {
+		h.streak = 0
+		return h.ready, h.known
+	}
+
+	if h.streak > 0 && connected == h.candidate {
+		h.streak++
+	} else {
+		h.candidate = connected
+		h.streak = 1
+	}
+
+	threshold := controllerDownThreshold
+	if connected {
+		threshold = controllerUpThreshold
+	}
+	if h.streak >= threshold {
+		h.known = true
+		h.ready = connected
+		h.streak = 0
+	}
+	return h.ready, h.known
+}
+
+// checkControllerState checks whether VRS is connected to its
+// controller and updates the node condition and taint on changes
+func checkControllerState(vrsConnection client.VRSConnection) {
+
+	state, err := vrsConnection.GetControllerState()
+	if err != nil {
+		log.Warnf("Unable to obtain VRS controller state: %v", err)
+	}
+	connected := err == nil && state == vrsSdk.ControllerConnected
+
+	ready, known := vscHealth.observe(connected)
+	if !known {
+		log.Debugf("VRS controller state is %s; waiting for consistent checks before reporting it", state)
+		return
+	}
+	log.Debugf("VRS controller state is %s; node network ready %t", state, ready)
+
+	desired := nodeNetworkState{ready: ready, tainted: !ready && nodeTaintOnDisconnect}
+	if vscHealth.applied && vscHealth.appliedOn == desired {
+		return
+	}
+
+	err = updateNodeNetworkState(desired, string(state))
+	if err != nil {
+		log.Errorf("Error updating Nuage network state of node %s: %v", hostname, err)
+		return
+	}
+
+	if vscHealth.applied && vscHealth.appliedOn.ready != desired.ready {
+		if desired.ready {
+			log.Infof("VRS on node %s is connected to its controller again; node marked %s", hostname, NodeNetworkReady)
+			recordNodeEvent(corev1.EventTypeNormal, k8s.ReasonVSCReconnected,
+				fmt.Sprintf("VRS is connected to its VSC controller again; %s set to True", NodeNetworkReady))
+		} else {
+			log.Errorf("VRS on node %s lost its controller; node marked not %s", hostname, NodeNetworkReady)
+			recordNodeEvent(corev1.EventTypeWarning, k8s.ReasonVSCDisconnected,
+				fmt.Sprintf("VRS is not connected to its VSC controller; %s set to False", NodeNetworkReady))
+		}
+	}
+	vscHealth.applied = true
+	vscHealth.appliedOn = desired
+	setNodeNetworkReady(desired.ready)
+}
+
+// updateNodeNetworkState sets the Nuage network condition
+// and taint of the node in K8S API server
+func updateNodeNetworkState(desired nodeNetworkState, controllerState string) error {
+
+	if kubeClient == nil {
+		return fmt.Errorf("K8S client is not initialized")
+	}
+	nodes := kubeClient.CoreV1().Nodes()
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := nodes.Get(hostname, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !setNodeNetworkCondition(node, desired.ready, controllerState, time.Now()) {
+			return nil
+		}
+		_, err = nodes.UpdateStatus(node)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := nodes.Get(hostname, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !setNodeNetworkTaint(node, desired.tainted, time.Now()) {
+			return nil
+		}
+		_, err = nodes.Update(node)
+		return err
+	})
+}
+
+// setNodeNetworkCondition sets the Nuage network condition of
+// the node and reports whether the node was changed
+func setNodeNetworkCondition(node *corev1.Node, ready bool, controllerState string, now time.Time) bool {
+
+	condition := corev1.NodeCondition{
+		Type:               NodeNetworkReady,
+		Status:             corev1.ConditionTrue,
+		Reason:             nodeNetworkReadyReason,
+		Message:            "VRS is connected to its VSC controller",
+		LastHeartbeatTime:  metav1.NewTime(now),
+		LastTransitionTime: metav1.NewTime(now),
+	}
+	if !ready {
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = nodeNetworkNotReadyReason
+		condition.Message = fmt.Sprintf("VRS is not connected to its VSC controller (state %q); pods cannot be attached to Nuage networks", controllerState)
+	}
+
+	for i, existing := range node.Status.Conditions {
+		if existing.Type != NodeNetworkReady {
+			continue
+		}
+		if existing.Status == condition.Status && existing.Reason == condition.Reason {
+			return false
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		node.Status.Conditions[i] = condition
+		return true
+	}
+
+	node.Status.Conditions = append(node.Status.Conditions, condition)
+	return true
+}
+
+// setNodeNetworkTaint adds or removes the NoSchedule taint keeping
+// pods off a node without Nuage network and reports whether the
+// node was changed
+func setNodeNetworkTaint(node *corev1.Node, tainted bool, now time.Time) bool {
+
+	for i, taint := range node.Spec.Taints {
+		if taint.Key != NodeNetworkUnavailable || taint.Effect != corev1.TaintEffectNoSchedule {
+			continue
+		}
+		if tainted {
+			return false
+		}
+		node.Spec.Taints = append(node.Spec.Taints[:i], node.Spec.Taints[i+1:]...)
+		return true
+	}
+
+	if !tainted {
+		return false
+	}
+	added := metav1.NewTime(now)
+	node.Spec.Taints = append(node.Spec.Taints, corev1.Taint{
+		Key:       NodeNetworkUnavailable,
+		Effect:    corev1.TaintEffectNoSchedule,
+		TimeAdded: &added,
+	})
+	return true
+}
+
+// recordNodeEvent records an event on the node
+// using the daemon's K8S client
+func recordNodeEvent(eventType string, reason string, message string) {
+	if kubeClient == nil {
+		return
+	}
+	_ = k8s.RecordEvent(kubeClient, k8s.NodeReference(hostname), eventType, reason, message)
+}
+
+func setNodeNetworkReady(ready bool) {
+	if ready {
+		atomic.StoreInt32(&nodeNetworkReady, 1)
+		return
+	}
+	atomic.StoreInt32(&nodeNetworkReady, 0)
+}
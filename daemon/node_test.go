@@ -0,0 +1,202 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestControllerHealth(t *testing.T) {
+
+	// Readiness after each check, "-" while it is not known yet
+	tests := []struct {
+		name      string
+		down      int
+		up        int
+		checks    []bool
+		wantReady []string
+	}{
+		{
+			name:      "disconnected at start",
+			down:      3,
+			up:        3,
+			checks:    []bool{false, false, false},
+			wantReady: []string{"-", "-", "down"},
+		},
+		{
+			name:      "connecting at start",
+			down:      3,
+			up:        2,
+			checks:    []bool{false, true, false, true, true},
+			wantReady: []string{"-", "-", "-", "-", "up"},
+		},
+		{
+			name:      "short disconnects ignored",
+			down:      3,
+			up:        2,
+			checks:    []bool{true, true, false, false, true, false, false, true},
+			wantReady: []string{"-", "up", "up", "up", "up", "up", "up", "up"},
+		},
+		{
+			name:      "disconnect and recovery",
+			down:      2,
+			up:        3,
+			checks:    []bool{true, true, true, false, false, true, true, false, true, true, true},
+			wantReady: []string{"-", "-", "up", "up", "down", "down", "down", "down", "down", "down", "up"},
+		},
+		{
+			name:      "no hysteresis",
+			down:      1,
+			up:        1,
+			checks:    []bool{true, false, true},
+			wantReady: []string{"up", "down", "up"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controllerDownThreshold = test.down
+			controllerUpThreshold = test.up
+			var h controllerHealth
+
+			var states []string
+			for _, connected := range test.checks {
+				ready, known := h.observe(connected)
+				switch {
+				case !known:
+					states = append(states, "-")
+				case ready:
+					states = append(states, "up")
+				default:
+					states = append(states, "down")
+				}
+			}
+			if !reflect.DeepEqual(states, test.wantReady) {
+				t.Errorf("expected readiness %v, got %v", test.wantReady, states)
+			}
+		})
+	}
+}
+
+func TestSetNodeNetworkCondition(t *testing.T) {
+
+	earlier := metav1.NewTime(time.Unix(1000, 0))
+	now := time.Unix(2000, 0)
+
+	tests := []struct {
+		name           string
+		conditions     []corev1.NodeCondition
+		ready          bool
+		wantChanged    bool
+		wantStatus     corev1.ConditionStatus
+		wantTransition metav1.Time
+	}{
+		{
+			name:           "condition added",
+			conditions:     []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			ready:          true,
+			wantChanged:    true,
+			wantStatus:     corev1.ConditionTrue,
+			wantTransition: metav1.NewTime(now),
+		},
+		{
+			name: "condition unchanged",
+			conditions: []corev1.NodeCondition{{Type: NodeNetworkReady, Status: corev1.ConditionFalse,
+				Reason: nodeNetworkNotReadyReason, LastTransitionTime: earlier}},
+			ready:          false,
+			wantStatus:     corev1.ConditionFalse,
+			wantTransition: earlier,
+		},
+		{
+			name: "condition flipped",
+			conditions: []corev1.NodeCondition{{Type: NodeNetworkReady, Status: corev1.ConditionTrue,
+				Reason: nodeNetworkReadyReason, LastTransitionTime: earlier}},
+			ready:          false,
+			wantChanged:    true,
+			wantStatus:     corev1.ConditionFalse,
+			wantTransition: metav1.NewTime(now),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &corev1.Node{Status: corev1.NodeStatus{Conditions: test.conditions}}
+
+			if changed := setNodeNetworkCondition(node, test.ready, "disconnected", now); changed != test.wantChanged {
+				t.Errorf("expected changed %t, got %t", test.wantChanged, changed)
+			}
+
+			var found *corev1.NodeCondition
+			for i := range node.Status.Conditions {
+				if node.Status.Conditions[i].Type == NodeNetworkReady {
+					found = &node.Status.Conditions[i]
+				}
+			}
+			if found == nil {
+				t.Fatalf("condition %s not set in %v", NodeNetworkReady, node.Status.Conditions)
+			}
+			if found.Status != test.wantStatus || !found.LastTransitionTime.Equal(&test.wantTransition) {
+				t.Errorf("expected status %s since %v, got %+v", test.wantStatus, test.wantTransition, found)
+			}
+		})
+	}
+}
+
+func TestSetNodeNetworkTaint(t *testing.T) {
+
+	otherTaint := corev1.Taint{Key: "dedicated", Value: "infra", Effect: corev1.TaintEffectNoSchedule}
+	networkTaint := corev1.Taint{Key: NodeNetworkUnavailable, Effect: corev1.TaintEffectNoSchedule}
+
+	tests := []struct {
+		name        string
+		taints      []corev1.Taint
+		tainted     bool
+		wantChanged bool
+		wantKeys    []string
+	}{
+		{
+			name:        "taint added",
+			taints:      []corev1.Taint{otherTaint},
+			tainted:     true,
+			wantChanged: true,
+			wantKeys:    []string{"dedicated", NodeNetworkUnavailable},
+		},
+		{
+			name:     "taint kept",
+			taints:   []corev1.Taint{networkTaint},
+			tainted:  true,
+			wantKeys: []string{NodeNetworkUnavailable},
+		},
+		{
+			name:        "taint removed",
+			taints:      []corev1.Taint{networkTaint, otherTaint},
+			wantChanged: true,
+			wantKeys:    []string{"dedicated"},
+		},
+		{
+			name:     "no taint",
+			taints:   []corev1.Taint{otherTaint},
+			wantKeys: []string{"dedicated"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &corev1.Node{Spec: corev1.NodeSpec{Taints: test.taints}}
+
+			if changed := setNodeNetworkTaint(node, test.tainted, time.Now()); changed != test.wantChanged {
+				t.Errorf("expected changed %t, got %t", test.wantChanged, changed)
+			}
+			var keys []string
+			for _, taint := range node.Spec.Taints {
+				keys = append(keys, taint.Key)
+			}
+			if !reflect.DeepEqual(keys, test.wantKeys) {
+				t.Errorf("expected taints %v, got %v", test.wantKeys, keys)
+			}
+		})
+	}
+}
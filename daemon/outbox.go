@@ -0,0 +1,228 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/config"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	"github.com/nuagenetworks/nuage-cni/kubemon"
+	"github.com/nuagenetworks/nuage-cni/outbox"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// outboxDrainInterval is how often the audit daemon
+// looks for queued notifications that are due
+const outboxDrainInterval = outbox.InitialBackoff
+
+// The audit daemon drains the outbox from its main loop, so each
+// send and each drain are kept short while Nuage K8S monitor is down
+const (
+	outboxSendTimeout = 5 * time.Second
+	outboxDrainBudget = 10 * time.Second
+)
+
+var outboxDir string
+
+// sendDeletionNotification sends a pod deletion notification
+// to Nuage K8S monitor. Unit tests replace it
+var sendDeletionNotification = k8s.SendPodDeletionNotificationWithin
+var outboxPending int64
+var outboxDelivered counter
+var outboxDropped counter
+
+func setOutboxPending(pending int) {
+	atomic.StoreInt64(&outboxPending, int64(pending))
+}
+
+// queueDeletionNotification queues a pod deletion notification
+// that could not be sent so that it is retried later. The pod UID
+// tells the deleted pod apart from a re-created one
+func queueDeletionNotification(podNs string, podUID string, pod *kubemon.Pod) {
+
+	n := &outbox.Notification{
+		PodName:      pod.Name,
+		PodNamespace: podNs,
+		PodUID:       podUID,
+		Zone:         pod.Zone,
+		Subnet:       pod.Subnet,
+		Domain:       pod.Domain,
+	}
+	if err := outbox.Add(outboxDir, n); err != nil {
+		log.Errorf("Unable to queue delete notification for pod %s under zone %s: %v", pod.Name, pod.Zone, err)
+	}
+}
+
+// recordedPodUID returns the UID of the pod an entity was created
+// for as found in pod state records, or an empty string
+func recordedPodUID(entityName string) string {
+
+	states, err := client.ListPodStates(stateDir)
+	if err != nil {
+		log.Debugf("Unable to list pod state records: %v", err)
+		return ""
+	}
+
+	var latest *client.PodState
+	for _, state := range states {
+		if state.EntityName == entityName && (latest == nil || state.Created.After(latest.Created)) {
+			latest = state
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.PodUID
+}
+
+// drainOutbox sends queued pod deletion notifications to
+// Nuage K8S monitor. With force set backoff is ignored
+func drainOutbox(force bool) error {
+
+	send := sendQueuedNotification
+	if !force {
+		send = budgetedSender(time.Now().Add(outboxDrainBudget))
+	}
+
+	removed, err := outbox.Drain(outboxDir, force, send)
+	if removed > 0 {
+		log.Infof("Removed %d notifications from outbox", removed)
+	}
+	if err != nil {
+		log.Errorf("Error draining outbox %s: %v", outboxDir, err)
+	}
+
+	if pending, listErr := outbox.List(outboxDir); listErr == nil {
+		setOutboxPending(len(pending))
+	}
+
+	return err
+}
+
+// budgetedSender sends queued notifications until the deadline
+// or until Nuage K8S monitor turns out to be unreachable, and
+// defers the remaining notifications to the next drain
+func budgetedSender(deadline time.Time) outbox.Sender {
+
+	unreachable := false
+	return func(n *outbox.Notification) error {
+		if unreachable || time.Now().After(deadline) {
+			return outbox.ErrDeferred
+		}
+		err := sendQueuedNotificationWithin(n, outboxSendTimeout)
+		if err != nil && kubemon.IsTransient(err) {
+			unreachable = true
+		}
+		return err
+	}
+}
+
+// sendQueuedNotification sends a queued notification unless a
+// new pod with the same name replaced the deleted one, since
+// the notification would then remove the new pod from kubemon.
+// Notifications failing for other reasons than Nuage K8S monitor
+// being unreachable are dropped as retrying them will not help
+func sendQueuedNotification(n *outbox.Notification) error {
+	return sendQueuedNotificationWithin(n, k8s.KubemonDeadline)
+}
+
+func sendQueuedNotificationWithin(n *outbox.Notification, timeout time.Duration) error {
+
+	if kubeClient != nil && n.PodNamespace != "" {
+		pod, err := kubeClient.CoreV1().Pods(n.PodNamespace).Get(n.PodName, metav1.GetOptions{})
+		if err == nil && notificationSuperseded(n, pod) {
+			log.Warnf("Dropping queued delete notification for pod %s under namespace %s as the pod was re-created", n.PodName, n.PodNamespace)
+			outboxDropped.inc()
+			return outbox.ErrSuperseded
+		}
+	}
+
+	pod := &kubemon.Pod{Name: n.PodName, Zone: n.Zone, Subnet: n.Subnet, Domain: n.Domain}
+	err := sendDeletionNotification(pod, orchestratorType, timeout)
+	if err != nil && !kubemon.IsTransient(err) {
+		log.Warnf("Dropping queued delete notification for pod %s under namespace %s as it cannot be sent: %v", n.PodName, n.PodNamespace, err)
+		outboxDropped.inc()
+		return outbox.ErrRejected
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Sent queued delete notification for pod %s under namespace %s after %d failed attempts", n.PodName, n.PodNamespace, n.Attempts)
+	outboxDelivered.inc()
+	return nil
+}
+
+// notificationSuperseded reports whether the pod is a new pod that
+// replaced the deleted one. Notifications without a pod UID are
+// never superseded as the deleted pod cannot be told apart
+func notificationSuperseded(n *outbox.Notification, pod *corev1.Pod) bool {
+	return pod.DeletionTimestamp == nil && n.PodUID != "" && string(pod.UID) != n.PodUID
+}
+
+// ListOutbox writes the queued pod deletion notifications
+func ListOutbox(config *config.Config, w io.Writer) error {
+
+	notifications, err := outbox.List(config.OutboxDir)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tPOD\tQUEUED\tATTEMPTS\tNEXT ATTEMPT\tLAST ERROR")
+	for _, n := range notifications {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", n.PodNamespace, n.PodName,
+			n.Created.Format(time.RFC3339), n.Attempts, n.NextAttempt.Format(time.RFC3339), n.LastError)
+	}
+
+	return tw.Flush()
+}
+
+// FlushOutbox sends all queued pod deletion notifications right
+// away. With drop set they are discarded without being sent
+func FlushOutbox(config *config.Config, orchestrator string, drop bool) error {
+
+	var err error
+	orchestratorType = orchestrator
+	outboxDir = config.OutboxDir
+
+	if drop {
+		notifications, err := outbox.List(outboxDir)
+		if err != nil {
+			return err
+		}
+		for _, n := range notifications {
+			if err = outbox.Remove(outboxDir, n.ID); err != nil {
+				return err
+			}
+			log.Infof("Dropped queued delete notification for pod %s under namespace %s", n.PodName, n.PodNamespace)
+		}
+		return nil
+	}
+
+	kubeClient, err = newKubeClient()
+	if err != nil {
+		log.Warnf("Unable to create kube client; re-created pods will not be detected: %v", err)
+	}
+
+	if err = drainOutbox(true); err != nil {
+		return err
+	}
+
+	pending, err := outbox.List(outboxDir)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		fmt.Fprintf(os.Stderr, "%d notifications could not be sent and stay queued\n", len(pending))
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package daemon
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	"github.com/nuagenetworks/nuage-cni/kubemon"
+	"github.com/nuagenetworks/nuage-cni/outbox"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestDrainOutboxBudget(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		sendErr      error
+		force        bool
+		wantSent     int
+		wantAttempts []int
+	}{
+		{
+			name:         "all sent",
+			wantSent:     3,
+			wantAttempts: []int{},
+		},
+		{
+			name:         "monitor unreachable",
+			sendErr:      context.DeadlineExceeded,
+			wantSent:     1,
+			wantAttempts: []int{0, 0, 1},
+		},
+		{
+			name:         "rejected by monitor",
+			sendErr:      &kubemon.ClientError{StatusError: kubemon.StatusError{StatusCode: 404, Status: "404 Not Found"}},
+			wantSent:     3,
+			wantAttempts: []int{},
+		},
+		{
+			name:         "forced flush tries all",
+			sendErr:      context.DeadlineExceeded,
+			force:        true,
+			wantSent:     3,
+			wantAttempts: []int{1, 1, 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer setupAudit(t, nil)()
+			kubeClient = nil
+
+			for _, name := range []string{"pod1", "pod2", "pod3"} {
+				queueDeletionNotification("ns1", "", &kubemon.Pod{Name: name, Zone: "ns1"})
+			}
+
+			sent := 0
+			sendDeletionNotification = func(pod *kubemon.Pod, orchestrator string, timeout time.Duration) error {
+				sent++
+				if !test.force && timeout != outboxSendTimeout {
+					t.Errorf("expected send timeout %v, got %v", outboxSendTimeout, timeout)
+				}
+				return test.sendErr
+			}
+			defer func() { sendDeletionNotification = k8s.SendPodDeletionNotificationWithin }()
+
+			_ = drainOutbox(test.force)
+
+			if sent != test.wantSent {
+				t.Errorf("expected %d notifications sent, got %d", test.wantSent, sent)
+			}
+			left, err := outbox.List(outboxDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			attempts := []int{}
+			for _, n := range left {
+				attempts = append(attempts, n.Attempts)
+			}
+			sort.Ints(attempts)
+			if len(attempts) != len(test.wantAttempts) {
+				t.Fatalf("expected attempts %v, got %v", test.wantAttempts, attempts)
+			}
+			for i := range attempts {
+				if attempts[i] != test.wantAttempts[i] {
+					t.Errorf("expected attempts %v, got %v", test.wantAttempts, attempts)
+				}
+			}
+		})
+	}
+}
+
+func TestNotificationSuperseded(t *testing.T) {
+
+	now := metav1.Now()
+	tests := []struct {
+		name       string
+		queuedUID  string
+		podUID     types.UID
+		deleting   *metav1.Time
+		superseded bool
+	}{
+		{name: "same pod", queuedUID: "uid1", podUID: "uid1"},
+		{name: "re-created pod", queuedUID: "uid1", podUID: "uid2", superseded: true},
+		{name: "re-created pod being deleted", queuedUID: "uid1", podUID: "uid2", deleting: &now},
+		{name: "no UID queued", podUID: "uid2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := &outbox.Notification{PodName: "pod1", PodNamespace: "ns1", PodUID: test.queuedUID}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1",
+				UID: test.podUID, DeletionTimestamp: test.deleting}}
+			if superseded := notificationSuperseded(n, pod); superseded != test.superseded {
+				t.Errorf("expected superseded %v, got %v", test.superseded, superseded)
+			}
+		})
+	}
+}
+
+func TestRecordedPodUID(t *testing.T) {
+
+	defer setupAudit(t, nil)()
+
+	for _, state := range []*client.PodState{
+		{ContainerID: "c1", EntityName: "ns1_pod1", PodUID: "uid1", Created: time.Now().Add(-time.Hour)},
+		{ContainerID: "c2", EntityName: "ns1_pod1", PodUID: "uid2", Created: time.Now()},
+		{ContainerID: "c3", EntityName: "ns1_pod2", PodUID: "uid3", Created: time.Now()},
+	} {
+		if err := client.SavePodState(stateDir, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if uid := recordedPodUID("ns1_pod1"); uid != "uid2" {
+		t.Errorf("expected UID of latest pod uid2, got %q", uid)
+	}
+	if uid := recordedPodUID("ns1_pod3"); uid != "" {
+		t.Errorf("expected no UID for unrecorded entity, got %q", uid)
+	}
+}
@@ -0,0 +1,183 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	kclient "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// podDeletionGracePeriod gives CNI DEL a chance to clean up
+// VRS entries of a deleted pod before the audit daemon does
+const podDeletionGracePeriod = 30 * time.Second
+
+var kubeClient kclient.Interface
+var podLister corelisters.PodLister
+var podInformerSynced cache.InformerSynced
+var podDeletionChannel chan *corev1.Pod
+
+// startPodInformer starts watching pods scheduled
+// on this node and caching them locally
+func startPodInformer(stopChannel <-chan struct{}) error {
+
+	log.Infof("Starting pod informer for pods on node %s", hostname)
+
+	var err error
+	kubeClient, err = newKubeClient()
+	if err != nil {
+		return err
+	}
+
+	podDeletionChannel = make(chan *corev1.Pod, 100)
+	podUpdateChannel = make(chan *corev1.Pod, 100)
+	podInformer := coreinformers.NewFilteredPodInformer(kubeClient, metav1.NamespaceAll, 0, cache.Indexers{},
+		func(listOpts *metav1.ListOptions) {
+			listOpts.FieldSelector = fields.OneTermEqualSelector(PodHostField, hostname).String()
+		})
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: handlePodUpdate,
+		DeleteFunc: handlePodDelete,
+	})
+	podLister = corelisters.NewPodLister(podInformer.GetIndexer())
+	podInformerSynced = podInformer.HasSynced
+
+	go podInformer.Run(stopChannel)
+
+	return nil
+}
+
+// newKubeClient creates a clientset using the in-cluster config and
+// falls back to Nuage kubeconfig on the node when not run in a pod
+func newKubeClient() (kclient.Interface, error) {
+
+	// creates the in-cluster config
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Debugf("creating the in-cluster config failed %v; using Nuage kubeconfig", err)
+		return k8s.NewKubeClient(orchestratorType)
+	}
+	// creates the clientset
+	clientset, err := kclient.NewForConfig(config)
+	if err != nil {
+		log.Errorf("Error trying to create kubeclient %v", err)
+		return nil, err
+	}
+
+	return clientset, nil
+}
+
+// handlePodDelete schedules cleanup of VRS entries
+// of a pod deleted from K8S API server
+func handlePodDelete(obj interface{}) {
+
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			log.Warnf("Unexpected object %v received on pod deletion", obj)
+			return
+		}
+		pod, ok = tombstone.Obj.(*corev1.Pod)
+		if !ok {
+			log.Warnf("Unexpected tombstone object %v received on pod deletion", tombstone.Obj)
+			return
+		}
+	}
+
+	log.Infof("Pod %s under namespace %s was deleted; scheduling cleanup of its VRS entries", pod.Name, pod.Namespace)
+	time.AfterFunc(podDeletionGracePeriod, func() {
+		podDeletionChannel <- pod
+	})
+}
+
+// getActiveK8SPods will help obtain currently
+// active pods on the node from local pod cache
+func getActiveK8SPods(orchestrator string) ([]*corev1.Pod, error) {
+
+	log.Infof("Obtaining currently active K8S pods on agent node")
+
+	if podInformerSynced == nil || !podInformerSynced() {
+		return []*corev1.Pod{}, fmt.Errorf("pods on node %s are not yet synced from k8s api server", hostname)
+	}
+
+	pods, err := podLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("Error occured while listing pods from local cache")
+		return []*corev1.Pod{}, err
+	}
+
+	return pods, err
+}
+
+// getActiveEntityNames returns VRS entity names of active pods.
+// Entities created by earlier plugin versions are named after the
+// pod only. Such legacy names are only returned for pods that own
+// the entity, so that a stale legacy entity is not kept alive by
+// a pod with the same name in another namespace
+func getActiveEntityNames(vrsConnection client.VRSConnection, pods []*corev1.Pod) (entityNames []string, legacyNames []string) {
+
+	legacyStates := make(map[string]*client.PodState)
+	states, err := client.ListPodStates(stateDir)
+	if err != nil {
+		log.Warnf("Failed to list pod state records: %v", err)
+	}
+	for _, state := range states {
+		if state.EntityName != client.GetEntityName(state.PodNamespace, state.PodName) {
+			legacyStates[state.EntityName] = state
+		}
+	}
+
+	for _, pod := range pods {
+		entityNames = append(entityNames, client.GetEntityName(pod.Namespace, pod.Name))
+		if !containsString(legacyNames, pod.Name) && ownsLegacyEntity(vrsConnection, pod, legacyStates[pod.Name]) {
+			legacyNames = append(legacyNames, pod.Name)
+		}
+	}
+
+	return entityNames, legacyNames
+}
+
+// ownsLegacyEntity reports whether the entity named after the pod
+// name only belongs to the pod. The pod state record of the entity
+// tells its namespace, otherwise the zone its ports were resolved
+// in is matched against the pod's zone. Entities whose ports are
+// not resolved yet cannot be told apart and are kept
+func ownsLegacyEntity(vrsConnection client.VRSConnection, pod *corev1.Pod, state *client.PodState) bool {
+
+	if state != nil {
+		return state.PodNamespace == pod.Namespace && (state.PodUID == "" || state.PodUID == string(pod.UID))
+	}
+
+	ports, err := vrsConnection.GetEntityPortsByName(pod.Name)
+	if err != nil || len(ports) == 0 {
+		return false
+	}
+
+	zone := pod.Namespace
+	if label, ok := pod.Labels[k8s.ZoneLabel]; ok {
+		zone = label
+	}
+	for _, portName := range ports {
+		portState, err := vrsConnection.GetPortState(portName)
+		if err != nil {
+			continue
+		}
+		if portZone := client.GetPortStateMetadata(portState).Zone; portZone != "" {
+			return portZone == zone
+		}
+	}
+
+	log.Debugf("Zone of VRS entity %s is not known; keeping it for pod %s under namespace %s", pod.Name, pod.Name, pod.Namespace)
+	return true
+}
@@ -0,0 +1,162 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var podUpdateChannel chan *corev1.Pod
+
+// handlePodUpdate schedules an update of Nuage port metadata when
+// policy group or redirection target labels of a pod change
+func handlePodUpdate(oldObj, newObj interface{}) {
+
+	oldPod, ok := oldObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	newPod, ok := newObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+
+	if oldPod.Labels[k8s.PolicyGroupLabel] == newPod.Labels[k8s.PolicyGroupLabel] &&
+		oldPod.Labels[k8s.RedirectionTargetLabel] == newPod.Labels[k8s.RedirectionTargetLabel] {
+		return
+	}
+
+	// The informer must never wait for the audit daemon. Updates
+	// that do not fit are applied by the next policy resync
+	select {
+	case podUpdateChannel <- newPod:
+		log.Infof("Policy labels of pod %s under namespace %s changed; scheduling update of its Nuage port", newPod.Name, newPod.Namespace)
+	default:
+		log.Warnf("Policy labels of pod %s under namespace %s changed; its Nuage port will be updated by the next policy resync", newPod.Name, newPod.Namespace)
+	}
+}
+
+// updatePodPolicy pushes policy group and redirection target of
+// the pod to its existing rows in Nuage port table
+func updatePodPolicy(vrsConnection client.VRSConnection, pod *corev1.Pod) {
+
+	states, err := client.ListPodStates(stateDir)
+	if err != nil {
+		log.Errorf("Error listing pod state records for pod %s: %v", pod.Name, err)
+		return
+	}
+
+	if !applyPodPolicy(vrsConnection, pod, states) {
+		log.Warnf("No pod state record found for pod %s under namespace %s; unable to update its policy", pod.Name, pod.Namespace)
+	}
+}
+
+// resyncPodPolicies pushes policy labels of all pods on the node
+// to their Nuage ports, catching up on updates that were dropped
+func resyncPodPolicies(vrsConnection client.VRSConnection) {
+
+	if podInformerSynced == nil || !podInformerSynced() {
+		return
+	}
+	pods, err := podLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("Error listing pods from local cache: %v", err)
+		return
+	}
+	states, err := client.ListPodStates(stateDir)
+	if err != nil {
+		log.Errorf("Error listing pod state records: %v", err)
+		return
+	}
+
+	for _, pod := range pods {
+		applyPodPolicy(vrsConnection, pod, states)
+	}
+}
+
+// applyPodPolicy updates the Nuage ports of the pod found in the pod
+// state records and reports whether the pod has any state record
+func applyPodPolicy(vrsConnection client.VRSConnection, pod *corev1.Pod, states []*client.PodState) bool {
+
+	redirectionTarget := pod.Labels[k8s.RedirectionTargetLabel]
+
+	found := false
+	for _, state := range states {
+		if state.PodNamespace != pod.Namespace || state.PodName != pod.Name {
+			continue
+		}
+		found = true
+
+		// CNI ADD records pod IP once the port is resolved by VRS
+		if state.IP == "" {
+			log.Infof("Pod %s is still being attached to port %s; skipping policy update", pod.Name, state.PortName)
+			continue
+		}
+
+		policyGroup, labeled := desiredPolicyGroup(pod, state.Metadata)
+		if state.Metadata.PolicyGroup == policyGroup && state.Metadata.RedirectionTarget == redirectionTarget {
+			log.Debugf("Port %s of pod %s already has the desired policy", state.PortName, pod.Name)
+			continue
+		}
+
+		// Nuage port metadata is replaced as a whole, so it is rebuilt
+		// from the pod state record with only the changed policy set
+		metadata := state.Metadata
+		metadata.PolicyGroup = policyGroup
+		metadata.PolicyGroupLabeled = labeled
+		metadata.RedirectionTarget = redirectionTarget
+
+		portMetadata := make(map[string]string)
+		for key, value := range client.GetPortMetadata(metadata) {
+			portMetadata[string(key)] = value
+		}
+
+		err := vrsConnection.UpdatePortMetadata(state.PortName, portMetadata)
+		if err != nil {
+			log.Errorf("Error updating policy of port %s for pod %s: %v", state.PortName, pod.Name, err)
+			recordPodEvent(pod, corev1.EventTypeWarning, k8s.ReasonPolicyUpdateFailed,
+				fmt.Sprintf("Failed to update Nuage policy of port %s: %v", state.PortName, err))
+			continue
+		}
+
+		message := fmt.Sprintf("Updated port %s policy group from %q to %q and redirection target from %q to %q",
+			state.PortName, state.Metadata.PolicyGroup, policyGroup, state.Metadata.RedirectionTarget, redirectionTarget)
+		log.Infof("%s for pod %s under namespace %s", message, pod.Name, pod.Namespace)
+		recordPodEvent(pod, corev1.EventTypeNormal, k8s.ReasonPolicyUpdated, message)
+
+		state.Metadata = metadata
+		if err = client.SavePodState(stateDir, state); err != nil {
+			log.Warnf("Error recording pod state for port %s: %v", state.PortName, err)
+		}
+	}
+
+	return found
+}
+
+// desiredPolicyGroup returns the policy group the port of the pod should
+// have and whether it is set by the pod's label. Pods without the label
+// keep the policy group Nuage K8S monitor assigned to them
+func desiredPolicyGroup(pod *corev1.Pod, metadata client.NuageMetadata) (string, bool) {
+
+	if label := pod.Labels[k8s.PolicyGroupLabel]; label != "" {
+		return label, true
+	}
+	if metadata.PolicyGroupLabeled {
+		// The label the policy group was taken from was removed
+		return metadata.MonitorPolicyGroup, false
+	}
+	return metadata.PolicyGroup, false
+}
+
+// recordPodEvent records an event on a pod
+// using the daemon's K8S client
+func recordPodEvent(pod *corev1.Pod, eventType string, reason string, message string) {
+	if kubeClient == nil {
+		return
+	}
+	_ = k8s.RecordEvent(kubeClient, k8s.PodReference(pod.Name, pod.Namespace, string(pod.UID)), eventType, reason, message)
+}
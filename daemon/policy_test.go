@@ -0,0 +1,146 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nuagenetworks/libvrsdk/api/port"
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/client/fake"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUpdatePodPolicy(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		metadata        client.NuageMetadata
+		labels          map[string]string
+		wantPolicyGroup string
+		wantRedirection string
+		wantLabeled     bool
+	}{
+		{
+			name:            "redirection target added to pod with monitor policy group",
+			metadata:        client.NuageMetadata{PolicyGroup: "kubemon-pg", MonitorPolicyGroup: "kubemon-pg"},
+			labels:          map[string]string{k8s.RedirectionTargetLabel: "rt1"},
+			wantPolicyGroup: "kubemon-pg",
+			wantRedirection: "rt1",
+		},
+		{
+			name:            "record without monitor policy group",
+			metadata:        client.NuageMetadata{PolicyGroup: "kubemon-pg", RedirectionTarget: "rt1"},
+			labels:          map[string]string{},
+			wantPolicyGroup: "kubemon-pg",
+		},
+		{
+			name:            "policy group label added",
+			metadata:        client.NuageMetadata{PolicyGroup: "kubemon-pg", MonitorPolicyGroup: "kubemon-pg"},
+			labels:          map[string]string{k8s.PolicyGroupLabel: "pg1"},
+			wantPolicyGroup: "pg1",
+			wantLabeled:     true,
+		},
+		{
+			name:            "policy group label removed",
+			metadata:        client.NuageMetadata{PolicyGroup: "pg1", MonitorPolicyGroup: "kubemon-pg", PolicyGroupLabeled: true},
+			labels:          map[string]string{},
+			wantPolicyGroup: "kubemon-pg",
+		},
+		{
+			name:     "policy group label removed without monitor policy group",
+			metadata: client.NuageMetadata{PolicyGroup: "pg1", PolicyGroupLabeled: true},
+			labels:   map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer setupAudit(t, nil)()
+
+			test.metadata.Zone = "ns1"
+			test.metadata.Network = "subnet1"
+			vrs := fake.NewVRSConnection()
+			addEntity(vrs, "c1", "ns1_pod1", "nu1111")
+			portMetadata := make(map[string]string)
+			for key, value := range client.GetPortMetadata(test.metadata) {
+				portMetadata[string(key)] = value
+			}
+			_ = vrs.UpdatePortMetadata("nu1111", portMetadata)
+			state := &client.PodState{ContainerID: "c1", PodNamespace: "ns1", PodName: "pod1",
+				PortName: "nu1111", IP: "10.0.0.5", Metadata: test.metadata}
+			if err := client.SavePodState(stateDir, state); err != nil {
+				t.Fatal(err)
+			}
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1", Labels: test.labels}}
+			updatePodPolicy(vrs, pod)
+
+			metadata := vrs.Ports["nu1111"].Metadata
+			if metadata[port.MetadataNuagePolicyGroup] != test.wantPolicyGroup ||
+				metadata[port.MetadataKeyNuageRedirectionTarget] != test.wantRedirection {
+				t.Errorf("expected policy group %q and redirection target %q on port, got %v", test.wantPolicyGroup, test.wantRedirection, metadata)
+			}
+			if metadata[port.MetadataKeyZone] != "ns1" || metadata[port.MetadataKeyNetwork] != "subnet1" {
+				t.Errorf("port metadata lost zone and subnet: %v", metadata)
+			}
+
+			saved, err := client.LoadPodState(stateDir, "c1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Metadata.PolicyGroup != test.wantPolicyGroup || saved.Metadata.PolicyGroupLabeled != test.wantLabeled {
+				t.Errorf("expected recorded policy group %q labeled %t, got %+v", test.wantPolicyGroup, test.wantLabeled, saved.Metadata)
+			}
+		})
+	}
+}
+
+func TestHandlePodUpdateChannelFull(t *testing.T) {
+
+	podUpdateChannel = make(chan *corev1.Pod, 1)
+	defer func() { podUpdateChannel = nil }()
+
+	oldPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1"}}
+	newPod := oldPod.DeepCopy()
+	newPod.Labels = map[string]string{k8s.PolicyGroupLabel: "pg1"}
+
+	done := make(chan struct{})
+	go func() {
+		handlePodUpdate(oldPod, newPod)
+		handlePodUpdate(oldPod, newPod)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pod update handler blocked on a full channel")
+	}
+	if len(podUpdateChannel) != 1 {
+		t.Errorf("expected one queued pod update, got %d", len(podUpdateChannel))
+	}
+}
+
+func TestResyncPodPolicies(t *testing.T) {
+
+	labeled := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1",
+		Labels: map[string]string{k8s.PolicyGroupLabel: "pg1"}}}
+	unrecorded := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod2", Namespace: "ns1",
+		Labels: map[string]string{k8s.PolicyGroupLabel: "pg2"}}}
+	defer setupAudit(t, []*corev1.Pod{labeled, unrecorded})()
+
+	vrs := fake.NewVRSConnection()
+	addEntity(vrs, "c1", "ns1_pod1", "nu1111")
+	state := &client.PodState{ContainerID: "c1", PodNamespace: "ns1", PodName: "pod1",
+		PortName: "nu1111", IP: "10.0.0.5", Metadata: client.NuageMetadata{Zone: "ns1", Network: "subnet1"}}
+	if err := client.SavePodState(stateDir, state); err != nil {
+		t.Fatal(err)
+	}
+
+	resyncPodPolicies(vrs)
+
+	if pg := vrs.Ports["nu1111"].Metadata[port.MetadataNuagePolicyGroup]; pg != "pg1" {
+		t.Errorf("expected policy group pg1 on port after resync, got %q", pg)
+	}
+}
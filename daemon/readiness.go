@@ -0,0 +1,341 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nuagenetworks/libvrsdk/api/port"
+	"github.com/nuagenetworks/nuage-cni/client"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+// PodNetworkReady is the pod readiness gate condition set by the
+// audit daemon once the Nuage datapath of the pod is verified
+const PodNetworkReady corev1.PodConditionType = "nuage.io/network-ready"
+
+// Reasons of the pod network readiness condition
+const (
+	podNetworkVerified           = "NuageDatapathVerified"
+	podNetworkHostNetwork        = "NuageHostNetwork"
+	podNetworkPending            = "NuagePortPending"
+	podNetworkNotResolved        = "NuagePortNotResolved"
+	podNetworkResolutionFailed   = "NuagePortResolutionFailed"
+	podNetworkPolicyPending      = "NuagePolicyGroupPending"
+	podNetworkGatewayUnreachable = "NuageGatewayUnreachable"
+)
+
+// podReadinessCheckInterval is how often the datapath of gated
+// pods is verified, gatewayProbeTimeout how long the gateway of a
+// pod is given to answer ARP requests and gatewayProbesPerCheck
+// how many gateways are probed at most in each check
+const (
+	podReadinessCheckInterval = 5 * time.Second
+	gatewayProbeTimeout       = time.Second
+	gatewayProbesPerCheck     = 8
+	defaultPodIfName          = "eth0"
+)
+
+var probeGateway = client.ProbeGateway
+
+// lastGatewayProbes holds when the gateway of each pod was last
+// probed so that pods waiting longest are probed first
+var lastGatewayProbes = make(map[types.UID]time.Time)
+
+// verifiedSandboxes holds the container IDs of pod sandboxes whose
+// datapath was verified since the daemon started, so that pods are
+// verified again when their sandbox is re-created
+var verifiedSandboxes = make(map[string]bool)
+
+// checkPodNetworkReadiness verifies the Nuage datapath of pods on
+// the node that have the PodNetworkReady readiness gate and sets
+// the condition on them. Gateway probes run concurrently and at
+// most gatewayProbesPerCheck of them per check so that pods whose
+// gateway does not answer never hold up the audit daemon loop
+func checkPodNetworkReadiness(vrsConnection client.VRSConnection) {
+
+	if podInformerSynced == nil || !podInformerSynced() {
+		return
+	}
+	pods, err := podLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("Error listing pods from local cache: %v", err)
+		return
+	}
+
+	states, err := client.ListPodStates(stateDir)
+	if err != nil {
+		log.Errorf("Error listing pod state records: %v", err)
+		return
+	}
+	sandboxes := make(map[string]*client.PodState)
+	current := make(map[string]bool)
+	for _, state := range states {
+		current[state.ContainerID] = true
+		name := client.GetEntityName(state.PodNamespace, state.PodName)
+		if latest, ok := sandboxes[name]; !ok || state.Created.After(latest.Created) {
+			sandboxes[name] = state
+		}
+	}
+	for id := range verifiedSandboxes {
+		if !current[id] {
+			delete(verifiedSandboxes, id)
+		}
+	}
+	active := make(map[types.UID]bool)
+	for _, pod := range pods {
+		active[pod.UID] = true
+	}
+	for uid := range lastGatewayProbes {
+		if !active[uid] {
+			delete(lastGatewayProbes, uid)
+		}
+	}
+
+	var probes []*gatewayProbe
+	for _, pod := range pods {
+		if !hasNetworkReadinessGate(pod) || pod.DeletionTimestamp != nil ||
+			pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		state := sandboxes[client.GetEntityName(pod.Namespace, pod.Name)]
+		if state != nil && state.PodUID != "" && state.PodUID != string(pod.UID) {
+			// Record of an earlier pod with the same name
+			state = nil
+		}
+		condition := getPodNetworkCondition(pod)
+		if condition != nil && condition.Status == corev1.ConditionTrue &&
+			(state == nil || verifiedSandboxes[state.ContainerID]) {
+			continue
+		}
+
+		probe, ready, reason, message := checkPodPort(vrsConnection, pod, state)
+		if probe != nil {
+			probe.pod, probe.state = pod, state
+			probes = append(probes, probe)
+			continue
+		}
+		setPodNetworkReadiness(pod, state, ready, reason, message)
+	}
+
+	// Pods probed longest ago go first so that all pods get
+	// their turn while more gateways than the limit are probed
+	sort.SliceStable(probes, func(i, j int) bool {
+		return lastGatewayProbes[probes[i].pod.UID].Before(lastGatewayProbes[probes[j].pod.UID])
+	})
+	if len(probes) > gatewayProbesPerCheck {
+		log.Debugf("Probing gateways of %d of %d pods; the others are left for the next check", gatewayProbesPerCheck, len(probes))
+		probes = probes[:gatewayProbesPerCheck]
+	}
+
+	var wg sync.WaitGroup
+	for _, probe := range probes {
+		wg.Add(1)
+		go func(probe *gatewayProbe) {
+			defer wg.Done()
+			probe.run()
+		}(probe)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	for _, probe := range probes {
+		lastGatewayProbes[probe.pod.UID] = now
+		setPodNetworkReadiness(probe.pod, probe.state, probe.ready, probe.reason, probe.message)
+	}
+}
+
+// setPodNetworkReadiness sets the PodNetworkReady condition
+// of the pod to the result of verifying its datapath
+func setPodNetworkReadiness(pod *corev1.Pod, state *client.PodState, ready bool, reason string, message string) {
+
+	if ready && state != nil {
+		verifiedSandboxes[state.ContainerID] = true
+	}
+	if !setPodNetworkCondition(pod.DeepCopy(), ready, reason, message, time.Now()) {
+		return
+	}
+
+	if err := updatePodNetworkCondition(pod, ready, reason, message); err != nil {
+		log.Errorf("Error setting %s condition of pod %s under namespace %s: %v", PodNetworkReady, pod.Name, pod.Namespace, err)
+		return
+	}
+	if ready {
+		log.Infof("Nuage datapath of pod %s under namespace %s verified; %s set to True", pod.Name, pod.Namespace, PodNetworkReady)
+	} else {
+		log.Infof("Nuage datapath of pod %s under namespace %s not ready: %s", pod.Name, pod.Namespace, message)
+	}
+}
+
+// gatewayProbe is the ARP probe of the gateway of a pod whose
+// port passed the VRS checks, along with the probe result
+type gatewayProbe struct {
+	pod      *corev1.Pod
+	state    *client.PodState
+	netns    string
+	ifName   string
+	gateway  string
+	portName string
+	ip       string
+	ready    bool
+	reason   string
+	message  string
+}
+
+func (p *gatewayProbe) run() {
+
+	if err := probeGateway(p.netns, p.ifName, p.gateway, gatewayProbeTimeout); err != nil {
+		p.ready, p.reason, p.message = false, podNetworkGatewayUnreachable, err.Error()
+		return
+	}
+	p.ready, p.reason = true, podNetworkVerified
+	p.message = fmt.Sprintf("Port %s resolved with IP %s and gateway %s reachable", p.portName, p.ip, p.gateway)
+}
+
+// verifyPodNetwork checks that the port of the pod sandbox is
+// resolved by VRS, that the policy group of the pod is applied to
+// it and that the pod can ARP its gateway
+func verifyPodNetwork(vrsConnection client.VRSConnection, pod *corev1.Pod, state *client.PodState) (bool, string, string) {
+
+	probe, ready, reason, message := checkPodPort(vrsConnection, pod, state)
+	if probe == nil {
+		return ready, reason, message
+	}
+	probe.run()
+	return probe.ready, probe.reason, probe.message
+}
+
+// checkPodPort runs the VRS checks of verifyPodNetwork and returns
+// the gateway probe left to run once the checks passed
+func checkPodPort(vrsConnection client.VRSConnection, pod *corev1.Pod, state *client.PodState) (*gatewayProbe, bool, string, string) {
+
+	if pod.Spec.HostNetwork {
+		return nil, true, podNetworkHostNetwork, "Pod uses host network"
+	}
+	if state == nil || state.PortName == "" {
+		return nil, false, podNetworkPending, "Waiting for Nuage CNI to attach the pod"
+	}
+
+	portState, err := vrsConnection.GetPortState(state.PortName)
+	if err != nil {
+		return nil, false, podNetworkNotResolved, fmt.Sprintf("Port %s not found in VRS: %v", state.PortName, err)
+	}
+	ip, _ := portState[port.StateKeyIPAddress].(string)
+	if client.PortResolutionFailed(portState) {
+		return nil, false, podNetworkResolutionFailed, fmt.Sprintf("VRS failed to resolve port %s", state.PortName)
+	}
+	if ip == "" || state.IP == "" {
+		return nil, false, podNetworkNotResolved, fmt.Sprintf("Port %s is not resolved by VRS yet", state.PortName)
+	}
+
+	policyGroup, _ := desiredPolicyGroup(pod, state.Metadata)
+	if applied := appliedPolicyGroup(state); applied != policyGroup {
+		return nil, false, podNetworkPolicyPending, fmt.Sprintf("Policy group %q not applied to port %s yet; port has %q", policyGroup, state.PortName, applied)
+	}
+
+	gateway, _ := portState[port.StateKeyGateway].(string)
+	if gateway == "" {
+		gateway = state.Gateway
+	}
+	ifName := state.IfName
+	if ifName == "" {
+		ifName = defaultPodIfName
+	}
+
+	return &gatewayProbe{netns: state.Netns, ifName: ifName, gateway: gateway, portName: state.PortName, ip: ip}, false, "", ""
+}
+
+// appliedPolicyGroup returns the policy group in VRS port metadata
+// and falls back to the pod state record when it cannot be read
+func appliedPolicyGroup(state *client.PodState) string {
+
+	metadata, err := readPortMetadata(state.PortName)
+	if err != nil {
+		log.Debugf("Unable to read metadata of port %s from VRS: %v", state.PortName, err)
+		return state.Metadata.PolicyGroup
+	}
+	return metadata[string(port.MetadataNuagePolicyGroup)]
+}
+
+// hasNetworkReadinessGate reports whether the pod
+// waits for the PodNetworkReady condition
+func hasNetworkReadinessGate(pod *corev1.Pod) bool {
+	for _, gate := range pod.Spec.ReadinessGates {
+		if gate.ConditionType == PodNetworkReady {
+			return true
+		}
+	}
+	return false
+}
+
+func getPodNetworkCondition(pod *corev1.Pod) *corev1.PodCondition {
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == PodNetworkReady {
+			return &pod.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// updatePodNetworkCondition sets the PodNetworkReady
+// condition of the pod in K8S API server
+func updatePodNetworkCondition(pod *corev1.Pod, ready bool, reason string, message string) error {
+
+	if kubeClient == nil {
+		return fmt.Errorf("K8S client is not initialized")
+	}
+	pods := kubeClient.CoreV1().Pods(pod.Namespace)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := pods.Get(pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if latest.UID != pod.UID {
+			return fmt.Errorf("Pod %s under namespace %s was re-created", pod.Name, pod.Namespace)
+		}
+		if !setPodNetworkCondition(latest, ready, reason, message, time.Now()) {
+			return nil
+		}
+		_, err = pods.UpdateStatus(latest)
+		return err
+	})
+}
+
+// setPodNetworkCondition sets the PodNetworkReady condition
+// of the pod and reports whether the pod was changed
+func setPodNetworkCondition(pod *corev1.Pod, ready bool, reason string, message string, now time.Time) bool {
+
+	condition := corev1.PodCondition{
+		Type:               PodNetworkReady,
+		Status:             corev1.ConditionFalse,
+		Reason:             reason,
+		Message:            message,
+		LastProbeTime:      metav1.NewTime(now),
+		LastTransitionTime: metav1.NewTime(now),
+	}
+	if ready {
+		condition.Status = corev1.ConditionTrue
+	}
+
+	existing := getPodNetworkCondition(pod)
+	if existing == nil {
+		pod.Status.Conditions = append(pod.Status.Conditions, condition)
+		return true
+	}
+	if existing.Status == condition.Status && existing.Reason == condition.Reason && existing.Message == condition.Message {
+		return false
+	}
+	if existing.Status == condition.Status {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+	*existing = condition
+	return true
+}
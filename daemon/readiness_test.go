@@ -0,0 +1,301 @@
+package daemon
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nuagenetworks/libvrsdk/api/port"
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/client/fake"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestVerifyPodNetwork(t *testing.T) {
+
+	// Pods without a policy group are not labeled
+	newPod := func(policyGroup string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1", Labels: map[string]string{}},
+			Spec:       corev1.PodSpec{ReadinessGates: []corev1.PodReadinessGate{{ConditionType: PodNetworkReady}}},
+		}
+		if policyGroup != "" {
+			pod.Labels[k8s.PolicyGroupLabel] = policyGroup
+		}
+		return pod
+	}
+	newState := func() *client.PodState {
+		return &client.PodState{ContainerID: "c1", PodNamespace: "ns1", PodName: "pod1", Netns: "/var/run/netns/c1",
+			PortName: "nu1111", IP: "10.0.0.5", Gateway: "10.0.0.1", Metadata: client.NuageMetadata{PolicyGroup: "pg1"}}
+	}
+
+	tests := []struct {
+		name       string
+		pod        *corev1.Pod
+		state      *client.PodState
+		setup      func(vrs *fake.VRSConnection)
+		kubemonPG  string
+		gatewayErr error
+		wantReady  bool
+		wantReason string
+		wantProbe  bool
+	}{
+		{
+			name:       "datapath verified",
+			pod:        newPod("pg1"),
+			state:      newState(),
+			wantReady:  true,
+			wantReason: podNetworkVerified,
+			wantProbe:  true,
+		},
+		{
+			name:       "kubemon policy group not in VRS",
+			pod:        newPod(""),
+			state:      newState(),
+			setup:      func(vrs *fake.VRSConnection) { delete(vrs.Ports["nu1111"].Metadata, port.MetadataNuagePolicyGroup) },
+			wantReason: podNetworkPolicyPending,
+		},
+		{
+			name:  "kubemon policy group applied to unlabeled pod",
+			pod:   newPod(""),
+			state: newState(),
+			setup: func(vrs *fake.VRSConnection) {
+				_ = vrs.UpdatePortMetadata("nu1111", map[string]string{string(port.MetadataNuagePolicyGroup): "kubemon-pg"})
+			},
+			kubemonPG:  "kubemon-pg",
+			wantReady:  true,
+			wantReason: podNetworkVerified,
+			wantProbe:  true,
+		},
+		{
+			name:       "host network",
+			pod:        &corev1.Pod{Spec: corev1.PodSpec{HostNetwork: true}},
+			wantReady:  true,
+			wantReason: podNetworkHostNetwork,
+		},
+		{
+			name:       "not attached yet",
+			pod:        newPod("pg1"),
+			wantReason: podNetworkPending,
+		},
+		{
+			name:  "port not resolved",
+			pod:   newPod("pg1"),
+			state: newState(),
+			setup: func(vrs *fake.VRSConnection) {
+				_ = vrs.DestroyPort("nu1111")
+				_ = vrs.CreatePort("nu1111", port.Attributes{}, map[port.MetadataKey]string{})
+			},
+			wantReason: podNetworkNotResolved,
+		},
+		{
+			name:  "port resolution failed",
+			pod:   newPod("pg1"),
+			state: newState(),
+			setup: func(vrs *fake.VRSConnection) {
+				_ = vrs.DestroyPort("nu1111")
+				_ = vrs.CreatePort("nu1111", port.Attributes{}, map[port.MetadataKey]string{})
+				_ = vrs.FailPort("nu1111")
+			},
+			wantReason: podNetworkResolutionFailed,
+		},
+		{
+			name:       "policy group label not applied",
+			pod:        newPod("pg2"),
+			state:      newState(),
+			wantReason: podNetworkPolicyPending,
+		},
+		{
+			name:  "policy group recorded but not in VRS",
+			pod:   newPod("pg1"),
+			state: newState(),
+			setup: func(vrs *fake.VRSConnection) {
+				_ = vrs.UpdatePortMetadata("nu1111", map[string]string{string(port.MetadataNuagePolicyGroup): "pg0"})
+			},
+			wantReason: podNetworkPolicyPending,
+		},
+		{
+			name:       "gateway unreachable",
+			pod:        newPod("pg1"),
+			state:      newState(),
+			gatewayErr: fmt.Errorf("Gateway 10.0.0.1 did not answer ARP requests"),
+			wantReason: podNetworkGatewayUnreachable,
+			wantProbe:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vrs := fake.NewVRSConnection()
+			addEntity(vrs, "c1", "ns1_pod1", "nu1111")
+			_ = vrs.ResolvePort("nu1111", "10.0.0.5", "10.0.0.1", "255.255.255.0")
+			_ = vrs.UpdatePortMetadata("nu1111", map[string]string{string(port.MetadataNuagePolicyGroup): "pg1"})
+			if test.setup != nil {
+				test.setup(vrs)
+			}
+			if test.state != nil && test.kubemonPG != "" {
+				test.state.Metadata.PolicyGroup = test.kubemonPG
+			}
+
+			readPortMetadata = func(name string) (map[string]string, error) {
+				p, ok := vrs.Ports[name]
+				if !ok {
+					return nil, fmt.Errorf("port %s not found", name)
+				}
+				metadata := make(map[string]string)
+				for key, value := range p.Metadata {
+					metadata[string(key)] = value
+				}
+				return metadata, nil
+			}
+			defer func() { readPortMetadata = readVRSPortMetadata }()
+
+			probed := false
+			probeGateway = func(netns string, ifName string, gateway string, timeout time.Duration) error {
+				probed = true
+				if netns != "/var/run/netns/c1" || ifName != defaultPodIfName || gateway != "10.0.0.1" {
+					t.Errorf("unexpected gateway probe of %s on %s in %s", gateway, ifName, netns)
+				}
+				return test.gatewayErr
+			}
+			defer func() { probeGateway = client.ProbeGateway }()
+
+			ready, reason, message := verifyPodNetwork(vrs, test.pod, test.state)
+			if ready != test.wantReady || reason != test.wantReason {
+				t.Errorf("expected ready %t with reason %s, got %t with %s: %s", test.wantReady, test.wantReason, ready, reason, message)
+			}
+			if probed != test.wantProbe {
+				t.Errorf("expected gateway probed %t, got %t", test.wantProbe, probed)
+			}
+		})
+	}
+}
+
+func TestSetPodNetworkCondition(t *testing.T) {
+
+	earlier := metav1.NewTime(time.Unix(1000, 0))
+	now := time.Unix(2000, 0)
+	pending := corev1.PodCondition{Type: PodNetworkReady, Status: corev1.ConditionFalse,
+		Reason: podNetworkPending, Message: "waiting", LastTransitionTime: earlier}
+
+	tests := []struct {
+		name           string
+		conditions     []corev1.PodCondition
+		ready          bool
+		reason         string
+		message        string
+		wantChanged    bool
+		wantTransition metav1.Time
+	}{
+		{
+			name:           "condition added",
+			conditions:     []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+			reason:         podNetworkPending,
+			message:        "waiting",
+			wantChanged:    true,
+			wantTransition: metav1.NewTime(now),
+		},
+		{
+			name:           "condition unchanged",
+			conditions:     []corev1.PodCondition{pending},
+			reason:         podNetworkPending,
+			message:        "waiting",
+			wantTransition: earlier,
+		},
+		{
+			name:           "reason changed",
+			conditions:     []corev1.PodCondition{pending},
+			reason:         podNetworkGatewayUnreachable,
+			message:        "no answer",
+			wantChanged:    true,
+			wantTransition: earlier,
+		},
+		{
+			name:           "condition flipped",
+			conditions:     []corev1.PodCondition{pending},
+			ready:          true,
+			reason:         podNetworkVerified,
+			message:        "verified",
+			wantChanged:    true,
+			wantTransition: metav1.NewTime(now),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{Status: corev1.PodStatus{Conditions: append([]corev1.PodCondition{}, test.conditions...)}}
+
+			if changed := setPodNetworkCondition(pod, test.ready, test.reason, test.message, now); changed != test.wantChanged {
+				t.Errorf("expected changed %t, got %t", test.wantChanged, changed)
+			}
+			condition := getPodNetworkCondition(pod)
+			if condition == nil {
+				t.Fatalf("condition %s not set in %v", PodNetworkReady, pod.Status.Conditions)
+			}
+			wantStatus := corev1.ConditionFalse
+			if test.ready {
+				wantStatus = corev1.ConditionTrue
+			}
+			if condition.Status != wantStatus || condition.Reason != test.reason || !condition.LastTransitionTime.Equal(&test.wantTransition) {
+				t.Errorf("expected status %s with reason %s since %v, got %+v", wantStatus, test.reason, test.wantTransition, condition)
+			}
+		})
+	}
+}
+
+func TestCheckPodNetworkReadinessProbeLimit(t *testing.T) {
+
+	var pods []*corev1.Pod
+	for i := 0; i < gatewayProbesPerCheck+2; i++ {
+		pods = append(pods, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod%d", i), Namespace: "ns1", UID: types.UID(fmt.Sprintf("uid%d", i))},
+			Spec:       corev1.PodSpec{ReadinessGates: []corev1.PodReadinessGate{{ConditionType: PodNetworkReady}}},
+		})
+	}
+	defer setupAudit(t, pods)()
+	kubeClient = nil
+	lastGatewayProbes = make(map[types.UID]time.Time)
+	verifiedSandboxes = make(map[string]bool)
+
+	vrs := fake.NewVRSConnection()
+	for i, pod := range pods {
+		id, portName := fmt.Sprintf("c%d", i), fmt.Sprintf("nu%d", i)
+		addEntity(vrs, id, client.GetEntityName(pod.Namespace, pod.Name), portName)
+		_ = vrs.ResolvePort(portName, "10.0.0.5", "10.0.0.1", "255.255.255.0")
+		state := &client.PodState{ContainerID: id, PodNamespace: pod.Namespace, PodName: pod.Name, PodUID: string(pod.UID),
+			Netns: "/var/run/netns/" + id, PortName: portName, IP: "10.0.0.5", Gateway: "10.0.0.1"}
+		if err := client.SavePodState(stateDir, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mutex sync.Mutex
+	probed := make(map[string]int)
+	probeGateway = func(netns string, ifName string, gateway string, timeout time.Duration) error {
+		time.Sleep(100 * time.Millisecond)
+		mutex.Lock()
+		defer mutex.Unlock()
+		probed[netns]++
+		return fmt.Errorf("gateway %s did not answer", gateway)
+	}
+	defer func() { probeGateway = client.ProbeGateway }()
+
+	start := time.Now()
+	checkPodNetworkReadiness(vrs)
+	if elapsed := time.Since(start); elapsed > time.Duration(gatewayProbesPerCheck)*100*time.Millisecond/2 {
+		t.Errorf("expected gateways to be probed concurrently, check took %v", elapsed)
+	}
+	if len(probed) != gatewayProbesPerCheck {
+		t.Errorf("expected %d gateway probes in a check, got %d", gatewayProbesPerCheck, len(probed))
+	}
+
+	// Pods left out are probed first in the next check
+	checkPodNetworkReadiness(vrs)
+	if len(probed) != len(pods) {
+		t.Errorf("expected all %d pods probed after two checks, got %d", len(pods), len(probed))
+	}
+}
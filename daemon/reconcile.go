@@ -0,0 +1,62 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	"github.com/nuagenetworks/nuage-cni/kubemon"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// reconcileCachedPods registers pods attached using cached
+// subnet decisions with Nuage K8S monitor once it is reachable
+func reconcileCachedPods() {
+
+	states, err := client.ListPodStates(stateDir)
+	if err != nil {
+		log.Errorf("Unable to list pod states for reconciliation: %v", err)
+		return
+	}
+
+	for _, state := range states {
+		if !state.MetadataCached || state.IP == "" {
+			continue
+		}
+
+		subnet, err := k8s.ReconcilePodMetadata(state, orchestratorType)
+		if err != nil {
+			if kubemon.IsTransient(err) {
+				log.Debugf("Nuage K8S monitor still unreachable; postponing reconciliation of cached subnets: %v", err)
+				return
+			}
+			log.Errorf("Unable to reconcile cached subnet of pod %s under namespace %s: %v", state.PodName, state.PodNamespace, err)
+			continue
+		}
+
+		pod := &corev1.Pod{}
+		pod.Name, pod.Namespace = state.PodName, state.PodNamespace
+		if podLister != nil {
+			if current, err := podLister.Pods(state.PodNamespace).Get(state.PodName); err == nil {
+				pod = current
+			}
+		}
+
+		if subnet == state.Metadata.Network {
+			log.Infof("Reconciled cached subnet %s of pod %s under namespace %s with Nuage K8S monitor", subnet, state.PodName, state.PodNamespace)
+			recordPodEvent(pod, corev1.EventTypeNormal, k8s.ReasonSubnetReconciled,
+				fmt.Sprintf("Cached subnet %s was confirmed by Nuage monitor", subnet))
+		} else {
+			log.Warnf("Pod %s under namespace %s is attached to cached subnet %s but Nuage K8S monitor assigned subnet %s",
+				state.PodName, state.PodNamespace, state.Metadata.Network, subnet)
+			recordPodEvent(pod, corev1.EventTypeWarning, k8s.ReasonSubnetMismatch,
+				fmt.Sprintf("Pod is attached to cached subnet %s but Nuage monitor assigned subnet %s; restart the pod to move it", state.Metadata.Network, subnet))
+		}
+
+		state.MetadataCached = false
+		if err = client.SavePodState(stateDir, state); err != nil {
+			log.Warnf("Unable to update pod state of %s: %v", state.PodName, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/config"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	"github.com/nuagenetworks/nuage-cni/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+var reloadChannel chan bool
+
+// requestReload asks the audit daemon to reload its configuration.
+// Requests made while a reload is pending are merged into it
+func requestReload() {
+	select {
+	case reloadChannel <- true:
+	default:
+	}
+}
+
+// loadNewConfig reads and validates Nuage CNI parameter file and
+// Nuage VSP yaml file. Settings that need a daemon restart keep
+// their current values
+func loadNewConfig(current *config.Config) (*config.Config, error) {
+
+	newConfig, err := config.LoadConfig(config.GetConfigFile())
+	if err != nil {
+		return nil, err
+	}
+
+	if newConfig.LogLevel == "" {
+		newConfig.LogLevel = "info"
+	}
+	client.SetDefaultsForNuageCNIConfig(newConfig)
+
+	if err = validateConfig(newConfig); err != nil {
+		return nil, err
+	}
+
+	if err = k8s.ValidateVSPConfig(orchestratorType); err != nil {
+		return nil, err
+	}
+
+	keepStaticSetting("vrsendpoint", &newConfig.VRSEndpoint, current.VRSEndpoint)
+	keepStaticSetting("vrsbridge", &newConfig.VRSBridge, current.VRSBridge)
+	keepStaticSetting("statedir", &newConfig.StateDir, current.StateDir)
+	keepStaticSetting("outboxdir", &newConfig.OutboxDir, current.OutboxDir)
+	keepStaticSetting("healthaddress", &newConfig.HealthAddress, current.HealthAddress)
+	keepStaticSetting("logformat", &newConfig.LogFormat, current.LogFormat)
+	keepStaticSetting("daemonlogfile", &newConfig.DaemonLogFile, current.DaemonLogFile)
+
+	return newConfig, nil
+}
+
+// keepStaticSetting restores a setting that can
+// only be changed by restarting the audit daemon
+func keepStaticSetting(name string, setting *string, current string) {
+	if *setting != "" && *setting != current {
+		log.Warnf("Changing %s from %s to %s requires a restart of the audit daemon", name, current, *setting)
+	}
+	*setting = current
+}
+
+// validateConfig rejects Nuage CNI parameters
+// the audit daemon is unable to run with
+func validateConfig(conf *config.Config) error {
+
+	if _, err := logging.ParseLevel(conf.LogLevel); err != nil {
+		return err
+	}
+
+	if conf.MonitorInterval < 0 {
+		return fmt.Errorf("Invalid monitor interval %d", conf.MonitorInterval)
+	}
+
+	if conf.VRSConnectionCheckTimer < 0 {
+		return fmt.Errorf("Invalid VRS connection check timer %d", conf.VRSConnectionCheckTimer)
+	}
+
+	if conf.VRSReconnectMaxInterval < 0 {
+		return fmt.Errorf("Invalid VRS reconnect max interval %d", conf.VRSReconnectMaxInterval)
+	}
+
+	if conf.ControllerCheckTimer < 0 {
+		return fmt.Errorf("Invalid controller check timer %d", conf.ControllerCheckTimer)
+	}
+
+	if conf.ControllerDownThreshold < 0 || conf.ControllerUpThreshold < 0 {
+		return fmt.Errorf("Invalid controller thresholds %d and %d", conf.ControllerDownThreshold, conf.ControllerUpThreshold)
+	}
+
+	if conf.StaleEntryTimeout < 0 {
+		return fmt.Errorf("Invalid stale entry timeout %d", conf.StaleEntryTimeout)
+	}
+
+	if conf.AuditMaxDeletionPercent > 100 {
+		return fmt.Errorf("Invalid audit max deletion percent %d", conf.AuditMaxDeletionPercent)
+	}
+
+	return nil
+}
+
+// applyConfig applies the reloaded configuration
+// without dropping the VRS connection
+func applyConfig(newConfig *config.Config) error {
+
+	if err := logging.SetLevel(newConfig.LogLevel); err != nil {
+		return err
+	}
+	applyAuditConfig(newConfig)
+
+	log.Infof("Reloaded audit daemon configuration: monitor interval %ds, VRS connection check %ds, stale entry timeout %ds, dry run %t",
+		newConfig.MonitorInterval, newConfig.VRSConnectionCheckTimer, newConfig.StaleEntryTimeout, newConfig.AuditDryRun)
+	return nil
+}
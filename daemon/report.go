@@ -0,0 +1,113 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nuagenetworks/nuage-cni/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of VRS entries flagged by the audit. Missing entries
+// belong to running pods and are restored instead of deleted
+const (
+	candidateEntity  = "entity"
+	candidatePort    = "port"
+	candidateVeth    = "veth"
+	candidateMissing = "missing"
+)
+
+// AuditCandidate describes a VRS entry flagged as stale by the audit
+type AuditCandidate struct {
+	Kind       string                 `json:"kind"`
+	Name       string                 `json:"name"`
+	Reason     string                 `json:"reason"`
+	FirstSeen  time.Time              `json:"firstSeen"`
+	AgeSeconds int64                  `json:"ageSeconds"`
+	Eligible   bool                   `json:"eligibleForDeletion"`
+	Ports      []string               `json:"ports,omitempty"`
+	PortState  map[string]interface{} `json:"portState,omitempty"`
+}
+
+// AuditReport is the machine readable result of an audit cycle
+type AuditReport struct {
+	Hostname          string           `json:"hostname"`
+	GeneratedAt       time.Time        `json:"generatedAt"`
+	DryRun            bool             `json:"dryRun"`
+	StaleEntryTimeout int64            `json:"staleEntryTimeout"`
+	Candidates        []AuditCandidate `json:"candidates"`
+	Alerts            []string         `json:"alerts,omitempty"`
+}
+
+var currentReport *AuditReport
+
+func newAuditReport() *AuditReport {
+	return &AuditReport{
+		Hostname:          hostname,
+		GeneratedAt:       time.Now(),
+		DryRun:            auditDryRun,
+		StaleEntryTimeout: staleEntryTimeout,
+		Candidates:        []AuditCandidate{},
+	}
+}
+
+// addCandidate records a stale VRS entry along with
+// its age and VRS port state
+func (r *AuditReport) addCandidate(vrsConnection client.VRSConnection, kind string, name string, reason string, firstSeen int64, eligible bool, ports []string) {
+
+	candidate := AuditCandidate{
+		Kind:       kind,
+		Name:       name,
+		Reason:     reason,
+		FirstSeen:  time.Unix(0, firstSeen*int64(time.Millisecond)),
+		AgeSeconds: (r.GeneratedAt.UnixNano()/1000000 - firstSeen) / 1000,
+		Eligible:   eligible,
+		Ports:      ports,
+	}
+
+	if len(ports) > 0 {
+		portState, err := vrsConnection.GetPortState(ports[0])
+		if err != nil {
+			log.Debugf("Unable to obtain VRS port state for port %s: %v", ports[0], err)
+		} else {
+			candidate.PortState = make(map[string]interface{})
+			for key, value := range portState {
+				candidate.PortState[string(key)] = value
+			}
+		}
+	}
+
+	r.Candidates = append(r.Candidates, candidate)
+}
+
+// write stores the report as JSON in the given file
+// or prints it on stdout if file is "-"
+func (r *AuditReport) write(file string) error {
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling audit report: %v", err)
+	}
+	data = append(data, '\n')
+
+	if file == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("Error creating audit report folder: %v", err)
+	}
+
+	// Write to a temporary file first so that readers
+	// never see a partially written report
+	tmpFile := file + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("Error writing audit report: %v", err)
+	}
+	return os.Rename(tmpFile, file)
+}
@@ -0,0 +1,176 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+
+	vrsSdk "github.com/nuagenetworks/libvrsdk/api"
+	"github.com/nuagenetworks/libvrsdk/api/entity"
+	"github.com/nuagenetworks/libvrsdk/api/port"
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var nuageSiteID int
+var vrsBridge string
+
+// getRunningPodStates returns the latest pod state record
+// of each active pod that has been attached by CNI ADD
+func getRunningPodStates(activePods []*corev1.Pod) ([]*client.PodState, error) {
+
+	states, err := client.ListPodStates(stateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]*client.PodState)
+	for _, state := range states {
+		// CNI ADD records pod IP once the port is resolved by VRS
+		if state.IP == "" || state.PortName == "" {
+			continue
+		}
+		key := client.GetEntityName(state.PodNamespace, state.PodName)
+		if current, ok := latest[key]; !ok || state.Updated.After(current.Updated) {
+			latest[key] = state
+		}
+	}
+
+	var running []*client.PodState
+	for _, pod := range activePods {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		if state, ok := latest[client.GetEntityName(pod.Namespace, pod.Name)]; ok {
+			running = append(running, state)
+		}
+	}
+
+	return running, nil
+}
+
+// restoreMissingEntries recreates VRS entries of running pods
+// that were lost by VRS, e.g. after a VRS restart
+func restoreMissingEntries(vrsConnection client.VRSConnection, vrsPortsList []string, activePods []*corev1.Pod) error {
+
+	log.Debugf("Restoring missing VRS entries of running pods")
+	states, err := getRunningPodStates(activePods)
+	if err != nil {
+		log.Errorf("Error listing pod state records: %v", err)
+		return err
+	}
+
+	podsByName := make(map[string]*corev1.Pod)
+	for _, pod := range activePods {
+		podsByName[client.GetEntityName(pod.Namespace, pod.Name)] = pod
+	}
+
+	var restored []string
+	for _, state := range states {
+		portExists := containsString(vrsPortsList, state.PortName)
+		entityExists, err := vrsConnection.CheckEntityExists(state.EntityUUID)
+		if err != nil {
+			log.Warnf("Error checking VRS entity %s: %v", state.EntityName, err)
+			continue
+		}
+		if portExists && entityExists {
+			continue
+		}
+
+		var missing []string
+		if !portExists {
+			missing = append(missing, "port "+state.PortName)
+		}
+		if !entityExists {
+			missing = append(missing, "entity")
+		}
+		reason := fmt.Sprintf("running pod %s under namespace %s is missing its VRS %s", state.PodName, state.PodNamespace, strings.Join(missing, " and "))
+		currentReport.addCandidate(vrsConnection, candidateMissing, state.EntityName, reason, currentTimeMillis(), true, nil)
+
+		if auditDryRun {
+			log.Infof("Audit dry run: VRS entries of entity %s would be restored", state.EntityName)
+			continue
+		}
+
+		pod := podsByName[client.GetEntityName(state.PodNamespace, state.PodName)]
+		err = restorePodEntries(vrsConnection, state, portExists, entityExists)
+		if err != nil {
+			log.Errorf("Error restoring VRS entries of entity %s: %v", state.EntityName, err)
+			recordPodEvent(pod, corev1.EventTypeWarning, k8s.ReasonNetworkRestoreFailed,
+				fmt.Sprintf("Failed to restore Nuage VRS entries of port %s: %v", state.PortName, err))
+			continue
+		}
+
+		recordPodEvent(pod, corev1.EventTypeNormal, k8s.ReasonNetworkRestored,
+			fmt.Sprintf("Restored Nuage VRS entries of port %s with IP %s", state.PortName, state.IP))
+		restored = append(restored, state.EntityName)
+	}
+
+	if len(restored) > 0 {
+		log.Infof("Missing VRS entries restored for entities %v", restored)
+	}
+	return nil
+}
+
+// restorePodEntries recreates the alubr0 attachment, Nuage port and
+// entity of a pod using its pod state record. The recorded IP is
+// requested as static IP so that the pod keeps its address
+func restorePodEntries(vrsConnection client.VRSConnection, state *client.PodState, portExists bool, entityExists bool) error {
+
+	hostVeth, err := netlink.LinkByName(state.PortName)
+	if err != nil {
+		return fmt.Errorf("Host veth %s of the pod is missing: %v", state.PortName, err)
+	}
+
+	info := vrsSdk.EntityInfo{
+		UUID: state.EntityUUID,
+		Name: state.EntityName,
+	}
+
+	if !portExists {
+		log.Infof("Restoring VRS port %s of entity %s with IP %s", state.PortName, state.EntityName, state.IP)
+
+		// Ports attached to alubr0 have the OVS
+		// datapath device as their master
+		if hostVeth.Attrs().MasterIndex == 0 {
+			err = vrsConnection.AddPortToAlubr0(state.PortName, info)
+			if err != nil {
+				return fmt.Errorf("Unable to add port %s to %s: %v", state.PortName, vrsBridge, err)
+			}
+		}
+
+		portAttributes := port.Attributes{
+			Platform: entity.Container,
+			MAC:      state.MAC,
+			Bridge:   vrsBridge,
+		}
+		metadata := state.Metadata
+		metadata.StaticIP = state.IP
+		err = vrsConnection.CreatePort(state.PortName, portAttributes, client.GetPortMetadata(metadata))
+		if err != nil {
+			return fmt.Errorf("Unable to create port %s in Nuage port table: %v", state.PortName, err)
+		}
+	}
+
+	if !entityExists {
+		log.Infof("Restoring VRS entity %s", state.EntityName)
+		info.Domain = entity.Docker
+		info.Type = entity.Container
+		info.Ports = []string{state.PortName}
+		info.Metadata = client.GetEntityMetadata(state.Metadata, nuageSiteID)
+		info.Events = &entity.EntityEvents{
+			EntityEventCategory: entity.EventCategoryStarted,
+			EntityEventType:     entity.EventStartedBooted,
+			EntityState:         entity.Running,
+			EntityReason:        entity.RunningBooted,
+		}
+		err = vrsConnection.CreateEntity(info)
+		if err != nil {
+			return fmt.Errorf("Unable to create entity %s in Nuage VM table: %v", state.EntityName, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/nuagenetworks/nuage-cni/k8s"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var emptyPodsThreshold int
+var maxDeletions int
+var maxDeletionPercent int
+
+// deletionBudget holds the number of deletions still allowed
+// per kind of VRS entry in the current audit cycle. A negative
+// budget means deletions are not limited
+var deletionBudget map[string]int
+var skippedDeletions []string
+
+// setDeletionBudget limits the deletions of the audit cycle so that
+// an empty or partial pod list from K8S API server does not tear
+// down every pod on the node
+func setDeletionBudget(numActivePods int, numVRSEntities int) {
+
+	skippedDeletions = nil
+	limit := -1
+	if maxDeletions > 0 {
+		limit = maxDeletions
+	}
+	if maxDeletionPercent > 0 {
+		percentLimit := numVRSEntities * maxDeletionPercent / 100
+		if percentLimit < 1 {
+			percentLimit = 1
+		}
+		if limit < 0 || percentLimit < limit {
+			limit = percentLimit
+		}
+	}
+
+	if numActivePods == 0 && emptyPodsThreshold > 0 && numVRSEntities >= emptyPodsThreshold {
+		raiseAuditAlert(fmt.Sprintf("K8S API server returned no active pods on node %s while VRS has %d entities; refusing to delete any VRS entry in this audit cycle",
+			hostname, numVRSEntities))
+		limit = 0
+	}
+
+	deletionBudget = map[string]int{
+		candidateEntity: limit,
+		candidatePort:   limit,
+		candidateVeth:   limit,
+	}
+}
+
+// allowDeletion consumes deletion budget for a stale
+// VRS entry and reports whether it may be deleted
+func allowDeletion(kind string, name string) bool {
+
+	budget, ok := deletionBudget[kind]
+	if !ok || budget < 0 {
+		return true
+	}
+	if budget == 0 {
+		skippedDeletions = append(skippedDeletions, fmt.Sprintf("%s %s", kind, name))
+		return false
+	}
+	deletionBudget[kind] = budget - 1
+	return true
+}
+
+// checkSkippedDeletions raises an alert when stale
+// VRS entries were kept by the deletion limits
+func checkSkippedDeletions() {
+
+	if len(skippedDeletions) == 0 {
+		return
+	}
+	raiseAuditAlert(fmt.Sprintf("Audit deletion limit reached on node %s; %d stale VRS entries were not deleted: %v",
+		hostname, len(skippedDeletions), skippedDeletions))
+}
+
+// raiseAuditAlert logs the alert, adds it to the audit
+// report and records a warning event on the node
+func raiseAuditAlert(message string) {
+
+	log.Errorf("AUDIT ALERT: %s", message)
+	if currentReport != nil {
+		currentReport.Alerts = append(currentReport.Alerts, message)
+	}
+	if kubeClient != nil {
+		_ = k8s.RecordEvent(kubeClient, k8s.NodeReference(hostname), corev1.EventTypeWarning, k8s.ReasonAuditDeletionsBlocked, message)
+	}
+}
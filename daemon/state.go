@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const auditStateFileName = "audit-state.json"
+
+// persistAuditState is set when stale entry tracking
+// has to be saved for later runs of the audit daemon
+var persistAuditState bool
+
+// auditState holds the time in milliseconds at which
+// stale candidates were first seen by the audit
+type auditState struct {
+	Entities map[string]int64 `json:"entities"`
+	Ports    map[string]int64 `json:"ports"`
+	Veths    map[string]int64 `json:"veths"`
+}
+
+func auditStateFile() string {
+	return filepath.Join(stateDir, auditStateFileName)
+}
+
+// loadAuditState restores stale entry tracking
+// saved by an earlier run of the audit daemon
+func loadAuditState() error {
+
+	data, err := ioutil.ReadFile(auditStateFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	state := auditState{}
+	if err = json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("Error unmarshalling audit state: %v", err)
+	}
+
+	for key, firstSeen := range state.Entities {
+		staleEntityMap[key] = firstSeen
+	}
+	for key, firstSeen := range state.Ports {
+		stalePortMap[key] = firstSeen
+	}
+	for key, firstSeen := range state.Veths {
+		staleVethMap[key] = firstSeen
+	}
+
+	log.Infof("Restored audit state with %d stale entities, %d stale ports and %d stale veths", len(staleEntityMap), len(stalePortMap), len(staleVethMap))
+	return nil
+}
+
+// saveAuditState saves stale entry tracking so that stale
+// entry countdowns survive restarts of the audit daemon
+func saveAuditState() error {
+
+	state := auditState{
+		Entities: staleEntityMap,
+		Ports:    stalePortMap,
+		Veths:    staleVethMap,
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling audit state: %v", err)
+	}
+
+	if err = os.MkdirAll(stateDir, 0700); err != nil {
+		return fmt.Errorf("Error creating audit state folder: %v", err)
+	}
+
+	// Write to a temporary file first so that a crash
+	// never leaves a partially written state file
+	file := auditStateFile()
+	if err = ioutil.WriteFile(file+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("Error writing audit state: %v", err)
+	}
+
+	return os.Rename(file+".tmp", file)
+}
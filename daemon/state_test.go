@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAuditStateRoundTrip(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "nuage-audit-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		entities map[string]int64
+		ports    map[string]int64
+		veths    map[string]int64
+	}{
+		{
+			name:     "empty state",
+			entities: map[string]int64{},
+			ports:    map[string]int64{},
+			veths:    map[string]int64{},
+		},
+		{
+			name:     "stale candidates",
+			entities: map[string]int64{"ns_pod1": 1000, "pod2": 2000},
+			ports:    map[string]int64{"nu0123456789abc": 3000},
+			veths:    map[string]int64{"nu0123456789def": 4000},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stateDir = dir
+			staleEntityMap = test.entities
+			stalePortMap = test.ports
+			staleVethMap = test.veths
+			if err := saveAuditState(); err != nil {
+				t.Fatalf("saving audit state failed: %v", err)
+			}
+
+			staleEntityMap = map[string]int64{}
+			stalePortMap = map[string]int64{}
+			staleVethMap = map[string]int64{}
+			if err := loadAuditState(); err != nil {
+				t.Fatalf("loading audit state failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(staleEntityMap, test.entities) {
+				t.Errorf("expected entities %v, got %v", test.entities, staleEntityMap)
+			}
+			if !reflect.DeepEqual(stalePortMap, test.ports) {
+				t.Errorf("expected ports %v, got %v", test.ports, stalePortMap)
+			}
+			if !reflect.DeepEqual(staleVethMap, test.veths) {
+				t.Errorf("expected veths %v, got %v", test.veths, staleVethMap)
+			}
+		})
+	}
+}
+
+func TestLoadAuditStateMissingFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "nuage-audit-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stateDir = filepath.Join(dir, "missing")
+	staleEntityMap = map[string]int64{}
+	stalePortMap = map[string]int64{}
+	staleVethMap = map[string]int64{}
+	if err := loadAuditState(); err != nil {
+		t.Errorf("loading missing audit state should not fail: %v", err)
+	}
+	if len(staleEntityMap)+len(stalePortMap)+len(staleVethMap) != 0 {
+		t.Errorf("expected empty stale maps after loading missing audit state")
+	}
+}
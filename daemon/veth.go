@@ -0,0 +1,120 @@
+package daemon
+
+import (
+	"strings"
+
+	"github.com/nuagenetworks/nuage-cni/client"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var staleVethMap map[string]int64
+
+// getOrphanVeths returns Nuage host veths that are neither in
+// Nuage port table nor attached to alubr0 and do not belong
+// to a sandbox of an active pod
+func getOrphanVeths(vrsConnection client.VRSConnection, vrsPortsList []string, activePods []*corev1.Pod) ([]string, error) {
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		log.Errorf("Failed to list host links: %v", err)
+		return nil, err
+	}
+
+	liveSandboxPorts := getLiveSandboxPorts(vrsConnection, activePods)
+
+	var orphans []string
+	for _, link := range links {
+		name := link.Attrs().Name
+		if link.Type() != "veth" || !strings.HasPrefix(name, "nu") {
+			continue
+		}
+
+		if containsString(vrsPortsList, name) {
+			continue
+		}
+
+		// Ports attached to alubr0 have the OVS
+		// datapath device as their master
+		if link.Attrs().MasterIndex != 0 {
+			continue
+		}
+
+		if containsString(liveSandboxPorts, name) {
+			log.Debugf("Host veth %s belongs to a sandbox of an active pod", name)
+			continue
+		}
+
+		orphans = append(orphans, name)
+	}
+
+	return orphans, nil
+}
+
+// getLiveSandboxPorts returns host veth names recorded
+// by CNI for sandboxes of active pods
+func getLiveSandboxPorts(vrsConnection client.VRSConnection, activePods []*corev1.Pod) []string {
+
+	states, err := client.ListPodStates(stateDir)
+	if err != nil {
+		log.Warnf("Failed to list pod state records: %v", err)
+		return nil
+	}
+
+	activeEntities, legacyEntities := getActiveEntityNames(vrsConnection, activePods)
+	activeEntities = append(activeEntities, legacyEntities...)
+
+	var ports []string
+	for _, state := range states {
+		if !containsString(activeEntities, state.EntityName) {
+			continue
+		}
+		ports = append(ports, state.PortName)
+	}
+
+	return ports
+}
+
+// getStaleVethEntriesForDeletion will determine what host
+// veths need to be actually cleaned up from the node
+func getStaleVethEntriesForDeletion(ids []string) []string {
+	return getStaleEntriesForDeletion(staleVethMap, ids, currentTimeMillis())
+}
+
+// cleanupOrphanVeths removes Nuage host veths left
+// behind on the node by an interrupted CNI DEL
+func cleanupOrphanVeths(vrsConnection client.VRSConnection, vrsPortsList []string, activePods []*corev1.Pod) error {
+
+	log.Debugf("Cleaning up orphan Nuage veths on the node")
+	orphans, err := getOrphanVeths(vrsConnection, vrsPortsList, activePods)
+	if err != nil {
+		return err
+	}
+
+	deleteVethList := getStaleVethEntriesForDeletion(orphans)
+	for _, orphan := range orphans {
+		currentReport.addCandidate(vrsConnection, candidateVeth, orphan, "host veth not in Nuage port table or alubr0 and not used by an active pod sandbox",
+			staleVethMap[orphan], containsString(deleteVethList, orphan), nil)
+	}
+
+	if auditDryRun {
+		log.Infof("Audit dry run: orphan veths that would be cleaned up from the node %v", deleteVethList)
+		return nil
+	}
+
+	for _, orphan := range deleteVethList {
+		if !allowDeletion(candidateVeth, orphan) {
+			continue
+		}
+		log.Infof("Removing orphan veth %s", orphan)
+		err = client.DeleteHostVeth(orphan)
+		if err != nil {
+			log.Warnf("Failed to remove orphan veth %s: %v", orphan, err)
+		}
+		delete(staleVethMap, orphan)
+	}
+
+	log.Infof("Orphan veths cleaned up from the node %v", deleteVethList)
+	return err
+}
@@ -0,0 +1,228 @@
+package daemon
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/nuagenetworks/libvrsdk/ovsdb"
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/socketplane/libovsdb"
+)
+
+// Backoff between attempts to reconnect to VRS
+const (
+	vrsReconnectInitialInterval = 1 * time.Second
+	vrsReconnectJitter          = 0.2
+)
+
+var vrsReconnectMaxInterval time.Duration
+
+// connectVRS opens a VRS connection. Unit tests
+// replace it to run the audit against a fake VRS
+var connectVRS = client.ConnectToVRSOVSDB
+
+// vrsWatchClient is a separate OVSDB connection used to learn
+// about VRS disconnects right away since libvrsdk ignores them
+var vrsWatchClient *libovsdb.OvsdbClient
+
+// readPortMetadata reads the metadata of a port. Unit
+// tests replace it to read it from a fake VRS
+var readPortMetadata = readVRSPortMetadata
+
+// vrsGeneration counts VRS connections. It is read by disconnect
+// handlers on libovsdb goroutines so it is accessed atomically
+var vrsGeneration uint64
+var vrsDisconnectChannel chan uint64
+
+// vrsDisconnectHandler notifies the audit daemon
+// when its OVSDB watch connection goes down
+type vrsDisconnectHandler struct {
+	generation uint64
+}
+
+// Disconnected is called by libovsdb with its connection lock
+// held, so the notification must never block. Disconnects of
+// older connections are not sent so that they cannot take the
+// place of a disconnect of the current one
+func (h vrsDisconnectHandler) Disconnected(*libovsdb.OvsdbClient) {
+	if h.generation != atomic.LoadUint64(&vrsGeneration) {
+		return
+	}
+	select {
+	case vrsDisconnectChannel <- h.generation:
+	default:
+	}
+}
+
+// Update is a placeholder function for table updates
+func (h vrsDisconnectHandler) Update(interface{}, libovsdb.TableUpdates) {
+}
+
+// Locked is a placeholder function for table updates
+func (h vrsDisconnectHandler) Locked([]interface{}) {
+}
+
+// Stolen is a placeholder function for table updates
+func (h vrsDisconnectHandler) Stolen([]interface{}) {
+}
+
+// Echo is a placeholder function for table updates
+func (h vrsDisconnectHandler) Echo([]interface{}) {
+}
+
+// connectToVRS opens the VRS connection used by the audit
+// along with the OVSDB watch connection for disconnects
+func connectToVRS(config *config.Config) (client.VRSConnection, error) {
+
+	vrsConnection, err := connectVRS(config)
+	if err != nil {
+		return nil, err
+	}
+
+	watchClient, err := libovsdb.ConnectWithUnixSocket(config.VRSEndpoint)
+	if err != nil {
+		vrsConnection.Disconnect()
+		return nil, fmt.Errorf("Couldn't open VRS watch connection: %v", err)
+	}
+
+	generation := atomic.AddUint64(&vrsGeneration, 1)
+	drainVRSDisconnects()
+	watchClient.Register(vrsDisconnectHandler{generation: generation})
+	vrsWatchClient = watchClient
+	setVRSConnected(true)
+
+	return vrsConnection, nil
+}
+
+// disconnectFromVRS closes the VRS connection and its watch
+// connection. Disconnects of older generations are ignored
+func disconnectFromVRS(vrsConnection client.VRSConnection) {
+
+	atomic.AddUint64(&vrsGeneration, 1)
+	if vrsWatchClient != nil {
+		vrsWatchClient.Disconnect()
+		vrsWatchClient = nil
+	}
+	vrsConnection.Disconnect()
+}
+
+// drainVRSDisconnects drops disconnects of older
+// connections still waiting in the channel
+func drainVRSDisconnects() {
+	for {
+		select {
+		case <-vrsDisconnectChannel:
+		default:
+			return
+		}
+	}
+}
+
+// reconnectToVRS retries connecting to VRS with exponential backoff
+// and jitter until it succeeds or the daemon is interrupted
+func reconnectToVRS(config *config.Config) (client.VRSConnection, error) {
+
+	interval := vrsReconnectInitialInterval
+	for {
+		vrsConnection, err := connectToVRS(config)
+		if err == nil {
+			return vrsConnection, nil
+		}
+
+		delay := jitter(interval)
+		log.Errorf("Error connecting to VRS: %v. Will re-try connection in %s", err, delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-interruptChannel:
+			return nil, fmt.Errorf("Daemon was interrupted while reconnecting to VRS")
+		}
+
+		interval *= 2
+		if interval > vrsReconnectMaxInterval {
+			interval = vrsReconnectMaxInterval
+		}
+	}
+}
+
+// jitter spreads reconnect attempts of all nodes
+// by randomly varying the interval by up to 20%
+func jitter(interval time.Duration) time.Duration {
+	delta := float64(interval) * vrsReconnectJitter
+	return interval + time.Duration(delta*(2*rand.Float64()-1))
+}
+
+// handleVRSDisconnect replaces a broken VRS connection and
+// runs an immediate audit once VRS is reachable again
+func handleVRSDisconnect(vrsConnection client.VRSConnection, config *config.Config, orchestrator string) (client.VRSConnection, error) {
+
+	setVRSConnected(false)
+	vrsDisconnects.inc()
+	disconnectFromVRS(vrsConnection)
+
+	vrsConnection, err := reconnectToVRS(config)
+	if err != nil {
+		return vrsConnection, err
+	}
+	vrsReconnects.inc()
+	log.Infof("VRS connection is restored; running an audit")
+
+	err = cleanupStaleEntities(vrsConnection, orchestrator)
+	if err != nil {
+		log.Errorf("Error cleaning up stale entities and ports on VRS")
+	}
+
+	return vrsConnection, nil
+}
+
+// readVRSPortMetadata reads the metadata column of a port from Nuage
+// port table over the watch connection as libvrsdk cannot read it
+func readVRSPortMetadata(name string) (map[string]string, error) {
+
+	if vrsWatchClient == nil {
+		return nil, fmt.Errorf("VRS watch connection is not open")
+	}
+
+	selectOp := libovsdb.Operation{
+		Op:      "select",
+		Table:   ovsdb.NuagePortTable,
+		Where:   []interface{}{libovsdb.NewCondition(ovsdb.NuagePortTableColumnName, "==", name)},
+		Columns: []string{ovsdb.NuagePortTableColumnMetadata},
+	}
+	reply, err := vrsWatchClient.Transact(ovsdb.OvsDBName, selectOp)
+	if err != nil || len(reply) != 1 || reply[0].Error != "" {
+		return nil, fmt.Errorf("Problem reading port %s from Nuage port table: %v %+v", name, err, reply)
+	}
+	if len(reply[0].Rows) != 1 {
+		return nil, fmt.Errorf("Port %s not found in Nuage port table", name)
+	}
+
+	return ovsMapToStrings(reply[0].Rows[0][ovsdb.NuagePortTableColumnMetadata])
+}
+
+// ovsMapToStrings converts an OVSDB map of
+// strings as found in JSON-RPC replies
+func ovsMapToStrings(value interface{}) (map[string]string, error) {
+
+	m := make(map[string]string)
+	ovsMap, ok := value.([]interface{})
+	if !ok || len(ovsMap) != 2 || ovsMap[0] != "map" {
+		return nil, fmt.Errorf("Unexpected OVSDB map %v", value)
+	}
+	pairs, ok := ovsMap[1].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Unexpected OVSDB map %v", value)
+	}
+	for _, pair := range pairs {
+		kv, ok := pair.([]interface{})
+		if !ok || len(kv) != 2 {
+			return nil, fmt.Errorf("Unexpected OVSDB map pair %v", pair)
+		}
+		key, _ := kv[0].(string)
+		m[key], _ = kv[1].(string)
+	}
+	return m, nil
+}
@@ -0,0 +1,179 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/nuagenetworks/nuage-cni/config"
+	"github.com/vishvananda/netlink"
+)
+
+func TestMain(m *testing.M) {
+
+	dir, err := ioutil.TempDir("", "nuage-cni-e2e-bin")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	pluginBinary, err = buildPlugin(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestAddDel(t *testing.T) {
+
+	h := newHarness(t, nil)
+	defer h.close()
+	p := h.newPod("e2e-ns", "nginx")
+
+	done := h.add(p)
+	row := h.waitForPort(p)
+	if row["nuage_zone"] != p.namespace || row["nuage_network"] != testSubnet || row["nuage_domain"] != testDomain {
+		t.Errorf("port created with zone %v, subnet %v and domain %v", row["nuage_zone"], row["nuage_network"], row["nuage_domain"])
+	}
+	if err := h.ovsdb.resolvePort(p.portName, "10.10.0.5", "10.10.0.1", "255.255.255.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	var added cniResult
+	select {
+	case added = <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("ADD did not complete")
+	}
+	if added.err != nil {
+		t.Fatalf("ADD failed: %s: %s", added.err.Msg, added.err.Details)
+	}
+	if ip := added.result.IP4.IP.String(); ip != "10.10.0.5/24" {
+		t.Errorf("expected IP 10.10.0.5/24 in result, got %s", ip)
+	}
+
+	// Host end of the veth pair is up and attached to alubr0
+	hostVeth, err := netlink.LinkByName(p.portName)
+	if err != nil {
+		t.Fatalf("host veth %s not found: %v", p.portName, err)
+	}
+	if hostVeth.Attrs().Flags&net.FlagUp == 0 {
+		t.Errorf("host veth %s is down", p.portName)
+	}
+	if h.ovsdb.findRow(ovsPortTable, "name", p.portName) == nil {
+		t.Errorf("host veth %s was not added to alubr0", p.portName)
+	}
+	entity := h.ovsdb.findRow(vmTable, "vm_uuid", p.containerID)
+	if entity == nil || entity["vm_name"] != p.namespace+"_"+p.name {
+		t.Errorf("unexpected entity %v", entity)
+	}
+
+	err = ns.WithNetNSPath(p.netns, func(ns.NetNS) error {
+		link, err := netlink.LinkByName("eth0")
+		if err != nil {
+			return err
+		}
+		if link.Attrs().Flags&net.FlagUp == 0 {
+			t.Errorf("eth0 is down")
+		}
+		if link.Attrs().MTU != testMTU {
+			t.Errorf("expected MTU %d, got %d", testMTU, link.Attrs().MTU)
+		}
+		if mac := link.Attrs().HardwareAddr.String(); mac != row["mac"] {
+			t.Errorf("eth0 MAC %s does not match port MAC %v", mac, row["mac"])
+		}
+
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		if err != nil {
+			return err
+		}
+		if len(addrs) != 1 || addrs[0].IPNet.String() != "10.10.0.5/24" {
+			t.Errorf("expected address 10.10.0.5/24, got %v", addrs)
+		}
+
+		routes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+		if err != nil {
+			return err
+		}
+		var defaultRoute, gatewayRoute bool
+		for _, route := range routes {
+			if route.Dst == nil && route.Gw.String() == "10.10.0.1" {
+				defaultRoute = true
+			}
+			if route.Dst != nil && route.Dst.String() == "10.10.0.1/32" && route.Scope == netlink.SCOPE_LINK {
+				gatewayRoute = true
+			}
+		}
+		if !defaultRoute || !gatewayRoute {
+			t.Errorf("expected default route and link route to gateway 10.10.0.1, got %v", routes)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("inspecting pod network namespace failed: %v", err)
+	}
+
+	deleted := h.del(p)
+	if deleted.err != nil {
+		t.Fatalf("DEL failed: %s: %s", deleted.err.Msg, deleted.err.Details)
+	}
+	if _, err = netlink.LinkByName(p.portName); err == nil {
+		t.Errorf("host veth %s was not removed", p.portName)
+	}
+	for _, table := range []string{portTable, vmTable, ovsPortTable} {
+		if rows := h.ovsdb.rows(table); len(rows) != 0 {
+			t.Errorf("rows left behind in %s: %v", table, rows)
+		}
+	}
+	if ports := setElements(h.ovsdb.findRow(bridgeTable, "name", "alubr0")["ports"]); len(ports) != 0 {
+		t.Errorf("ports left behind on alubr0: %v", ports)
+	}
+
+	deletions := h.kubemon.deletions()
+	if len(deletions) != 1 || deletions[0].Name != p.name || deletions[0].Zone != p.namespace || deletions[0].Subnet != testSubnet {
+		t.Errorf("unexpected pod deletion notifications %+v", deletions)
+	}
+}
+
+func TestAddPortNotResolved(t *testing.T) {
+
+	h := newHarness(t, func(conf *config.Config) {
+		conf.PortResolveTimer = 2
+		conf.SubnetExhaustionRetries = -1
+	})
+	defer h.close()
+	p := h.newPod("e2e-ns", "unresolved")
+
+	var added cniResult
+	select {
+	case added = <-h.add(p):
+	case <-time.After(time.Minute):
+		t.Fatal("ADD did not complete")
+	}
+	if added.err == nil || added.err.Code != 100 {
+		t.Fatalf("expected port resolution error, got %+v", added.err)
+	}
+
+	// The runtime cleans up a failed ADD with DEL
+	deleted := h.del(p)
+	if deleted.err != nil {
+		t.Fatalf("DEL failed: %s: %s", deleted.err.Msg, deleted.err.Details)
+	}
+	if _, err := netlink.LinkByName(p.portName); err == nil {
+		t.Errorf("host veth %s was not removed", p.portName)
+	}
+	if rows := h.ovsdb.rows(portTable); len(rows) != 0 {
+		t.Errorf("rows left behind in %s: %v", portTable, rows)
+	}
+}
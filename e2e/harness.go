@@ -0,0 +1,316 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/nuagenetworks/nuage-cni/config"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+)
+
+// Settings of the node the plugin runs on
+const (
+	testSubnet     = "e2e-subnet"
+	testEnterprise = "e2e-enterprise"
+	testDomain     = "e2e-domain"
+	testUser       = "e2e-admin"
+	testMTU        = 1450
+	netconf        = `{"cniVersion": "0.3.0", "name": "nuage-net", "type": "nuage-cni-k8s"}`
+)
+
+// pluginBinary is the plugin built by TestMain. It is
+// named so that it runs for k8s orchestrator
+var pluginBinary string
+
+// harness runs the plugin binary on a node made of a local
+// OVSDB server, a fake K8S API server and Nuage K8S monitor
+type harness struct {
+	t          *testing.T
+	dir        string
+	configFile string
+	ovsdb      *ovsdbServer
+	api        *apiServer
+	kubemon    *kubemonServer
+	netns      []string
+}
+
+// pod is a pod sandbox set up by the harness
+type pod struct {
+	namespace   string
+	name        string
+	uid         string
+	containerID string
+	netnsName   string
+	netns       string
+	portName    string
+}
+
+// buildPlugin builds the plugin binary into the directory
+func buildPlugin(dir string) (string, error) {
+
+	binary := filepath.Join(dir, "nuage-cni-k8s")
+	cmd := exec.Command("go", "build", "-o", binary, "github.com/nuagenetworks/nuage-cni")
+	cmd.Dir = ".."
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("building plugin failed: %v: %s", err, output)
+	}
+	return binary, nil
+}
+
+// newHarness starts the node services and writes Nuage CNI and
+// Nuage VSP configuration. The config is adjusted by the caller
+func newHarness(t *testing.T, adjust func(*config.Config)) *harness {
+
+	if os.Geteuid() != 0 {
+		t.Skip("e2e tests need root to create network namespaces")
+	}
+
+	dir, err := ioutil.TempDir("", "nuage-cni-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &harness{t: t, dir: dir, configFile: filepath.Join(dir, "nuage-cni.yaml")}
+
+	if h.ovsdb, err = newOVSDBServer(filepath.Join(dir, "db.sock")); err != nil {
+		h.close()
+		t.Fatal(err)
+	}
+	h.api = newAPIServer()
+	if h.kubemon, err = newKubemonServer(dir, testSubnet); err != nil {
+		h.close()
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{
+		VRSEndpoint:      h.ovsdb.socket,
+		LogLevel:         "debug",
+		CNILogFile:       filepath.Join(dir, "nuage-cni.log"),
+		PortResolveTimer: 10,
+		MTU:              testMTU,
+		StateDir:         filepath.Join(dir, "state"),
+		OutboxDir:        filepath.Join(dir, "outbox"),
+		SubnetCacheFile:  filepath.Join(dir, "subnet-cache.json"),
+		AuditReportFile:  filepath.Join(dir, "audit-report.json"),
+	}
+	if adjust != nil {
+		adjust(conf)
+	}
+	if err = writeYAML(h.configFile, conf); err != nil {
+		h.close()
+		t.Fatal(err)
+	}
+
+	kubeconfig := filepath.Join(dir, "nuage.kubeconfig")
+	if err = h.api.writeKubeConfig(kubeconfig); err != nil {
+		h.close()
+		t.Fatal(err)
+	}
+	vspConfig := &config.NuageVSPK8SConfig{
+		EnterpriseName:            testEnterprise,
+		DomainName:                testDomain,
+		VSDUser:                   testUser,
+		NuageK8SMonServer:         h.kubemon.server.URL,
+		NuageK8SMonClientCertFile: filepath.Join(dir, "nuageMonClient.crt"),
+		NuageK8SMonClientKeyFile:  filepath.Join(dir, "nuageMonClient.key"),
+		NuageK8SMonCAFile:         filepath.Join(dir, "nuageMonCA.crt"),
+		NuageK8SMonServerName:     kubemonServerName,
+		KubeConfig:                kubeconfig,
+	}
+	if err = os.MkdirAll(filepath.Join(dir, "vsp-k8s"), 0700); err != nil {
+		h.close()
+		t.Fatal(err)
+	}
+	if err = writeYAML(filepath.Join(dir, "vsp-k8s", "vsp-k8s.yaml"), vspConfig); err != nil {
+		h.close()
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+// close removes network namespaces, veths left behind and
+// the node services. The plugin log is kept on failures
+func (h *harness) close() {
+
+	for _, name := range h.netns {
+		_ = exec.Command("ip", "netns", "del", name).Run()
+	}
+	if h.ovsdb != nil {
+		for _, row := range h.ovsdb.rows(portTable) {
+			if name, ok := row["name"].(string); ok {
+				_ = exec.Command("ip", "link", "del", name).Run()
+			}
+		}
+		h.ovsdb.close()
+	}
+	if h.api != nil {
+		h.api.close()
+	}
+	if h.kubemon != nil {
+		h.kubemon.close()
+	}
+
+	if h.t.Failed() {
+		if data, err := ioutil.ReadFile(filepath.Join(h.dir, "nuage-cni.log")); err == nil {
+			h.t.Logf("plugin log:\n%s", data)
+		}
+	}
+	os.RemoveAll(h.dir)
+}
+
+// newPod registers a pod with the K8S API server and
+// creates the network namespace of its sandbox
+func (h *harness) newPod(namespace string, name string) *pod {
+
+	p := &pod{
+		namespace:   namespace,
+		name:        name,
+		uid:         randomHex(16),
+		containerID: randomHex(32),
+		netnsName:   "nuage-e2e-" + randomHex(4),
+	}
+	p.netns = "/var/run/netns/" + p.netnsName
+	p.portName = client.GetNuagePortName(p.containerID)
+
+	if output, err := exec.Command("ip", "netns", "add", p.netnsName).CombinedOutput(); err != nil {
+		h.t.Fatalf("creating network namespace failed: %v: %s", err, output)
+	}
+	h.netns = append(h.netns, p.netnsName)
+
+	h.api.addPod(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace: namespace,
+		Name:      name,
+		UID:       k8stypes.UID(p.uid),
+	}})
+	return p
+}
+
+// cniResult is the outcome of a plugin invocation
+type cniResult struct {
+	result *types.Result
+	err    *types.Error
+}
+
+// add runs CNI ADD for the pod in the background
+func (h *harness) add(p *pod) <-chan cniResult {
+
+	done := make(chan cniResult, 1)
+	go func() {
+		done <- h.run("ADD", p)
+	}()
+	return done
+}
+
+// del runs CNI DEL for the pod
+func (h *harness) del(p *pod) cniResult {
+	return h.run("DEL", p)
+}
+
+// run invokes the plugin the way a container runtime does, with
+// CNI parameters in the environment and network config on stdin
+func (h *harness) run(command string, p *pod) cniResult {
+
+	cniArgs := strings.Join([]string{
+		"IgnoreUnknown=1",
+		"K8S_POD_NAMESPACE=" + p.namespace,
+		"K8S_POD_NAME=" + p.name,
+		"K8S_POD_INFRA_CONTAINER_ID=" + p.containerID,
+		"K8S_POD_UID=" + p.uid,
+	}, ";")
+
+	cmd := exec.Command(pluginBinary)
+	cmd.Env = append(os.Environ(),
+		"CNI_COMMAND="+command,
+		"CNI_CONTAINERID="+p.containerID,
+		"CNI_NETNS="+p.netns,
+		"CNI_IFNAME=eth0",
+		"CNI_ARGS="+cniArgs,
+		"CNI_PATH="+filepath.Dir(pluginBinary),
+		config.ConfigFileEnv+"="+h.configFile,
+		config.DataDirEnv+"="+h.dir,
+	)
+	cmd.Stdin = strings.NewReader(netconf)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err == nil {
+		if stdout.Len() == 0 {
+			return cniResult{}
+		}
+		result := &types.Result{}
+		if err = json.Unmarshal(stdout.Bytes(), result); err != nil {
+			return cniResult{err: &types.Error{Msg: fmt.Sprintf("invalid result %q: %v", stdout.String(), err)}}
+		}
+		return cniResult{result: result}
+	}
+
+	cniErr := &types.Error{}
+	if json.Unmarshal(stdout.Bytes(), cniErr) != nil {
+		cniErr.Msg = fmt.Sprintf("%v: %s %s", err, stdout.String(), stderr.String())
+	}
+	return cniResult{err: cniErr}
+}
+
+// admin runs an admin subcommand of the plugin
+// and returns what it printed on stdout
+func (h *harness) admin(args ...string) (string, error) {
+
+	cmd := exec.Command(pluginBinary, args...)
+	cmd.Env = append(os.Environ(),
+		config.ConfigFileEnv+"="+h.configFile,
+		config.DataDirEnv+"="+h.dir,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("%s %v failed: %v: %s", filepath.Base(pluginBinary), args, err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// waitForPort waits for the plugin to create the Nuage port of the pod
+func (h *harness) waitForPort(p *pod) map[string]interface{} {
+
+	row, err := h.ovsdb.waitForRow(portTable, "name", p.portName, 30*time.Second)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return row
+}
+
+func writeYAML(file string, v interface{}) error {
+
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
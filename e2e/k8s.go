@@ -0,0 +1,320 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuagenetworks/nuage-cni/kubemon"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// kubemonServerName is the name the Nuage K8S
+// monitor certificate is issued for
+const kubemonServerName = "kubemon.e2e"
+
+// apiServer is a fake K8S API server serving pods
+// and recording the events posted by the plugin
+type apiServer struct {
+	sync.Mutex
+	server *httptest.Server
+	pods   map[string]*corev1.Pod
+	events []corev1.Event
+}
+
+func newAPIServer() *apiServer {
+
+	s := &apiServer{pods: make(map[string]*corev1.Pod)}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// addPod makes a pod known to the API server
+func (s *apiServer) addPod(pod *corev1.Pod) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	pod.Kind = "Pod"
+	pod.APIVersion = "v1"
+	s.pods[pod.Namespace+"/"+pod.Name] = pod
+}
+
+// eventReasons returns the reasons of events
+// recorded on the pod in the order posted
+func (s *apiServer) eventReasons(ns string, name string) []string {
+
+	s.Lock()
+	defer s.Unlock()
+
+	var reasons []string
+	for _, event := range s.events {
+		if event.InvolvedObject.Namespace == ns && event.InvolvedObject.Name == name {
+			reasons = append(reasons, event.Reason)
+		}
+	}
+	return reasons
+}
+
+func (s *apiServer) handle(w http.ResponseWriter, r *http.Request) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Paths are /api/v1/namespaces/<ns>/<resource>[/<name>]
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/")
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[1] == "pods":
+		pod, ok := s.pods[parts[0]+"/"+parts[2]]
+		if !ok {
+			writeStatus(w, http.StatusNotFound, fmt.Sprintf("pods %q not found", parts[2]))
+			return
+		}
+		writeJSON(w, http.StatusOK, pod)
+
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "events":
+		var event corev1.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			writeStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.events = append(s.events, event)
+		writeJSON(w, http.StatusCreated, &event)
+
+	default:
+		writeStatus(w, http.StatusNotFound, "not found")
+	}
+}
+
+// writeKubeConfig writes a kubeconfig pointing to the API server
+func (s *apiServer) writeKubeConfig(file string) error {
+
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: e2e
+  cluster:
+    server: %s
+contexts:
+- name: e2e
+  context:
+    cluster: e2e
+    user: e2e
+current-context: e2e
+users:
+- name: e2e
+  user: {}
+`, s.server.URL)
+	return ioutil.WriteFile(file, []byte(kubeconfig), 0600)
+}
+
+func (s *apiServer) close() {
+	s.server.Close()
+}
+
+// kubemonServer is a fake Nuage K8S monitor requiring client
+// certificates. It assigns subnets and records notifications
+type kubemonServer struct {
+	sync.Mutex
+	server   *httptest.Server
+	subnet   string
+	requests []kubemon.Pod
+}
+
+// newKubemonServer starts Nuage K8S monitor assigning the subnet and
+// writes the CA and the plugin's client certificate to the directory
+func newKubemonServer(dir string, subnet string) (*kubemonServer, error) {
+
+	ca, err := newCA()
+	if err != nil {
+		return nil, err
+	}
+	serverCert, err := ca.issue(kubemonServerName, x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return nil, err
+	}
+	clientCert, err := ca.issue("nuage-cni", x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if err = ca.write(filepath.Join(dir, "nuageMonCA.crt"), ""); err != nil {
+		return nil, err
+	}
+	if err = clientCert.write(filepath.Join(dir, "nuageMonClient.crt"), filepath.Join(dir, "nuageMonClient.key")); err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	s := &kubemonServer{subnet: subnet}
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(s.handle))
+	s.server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	s.server.StartTLS()
+	return s, nil
+}
+
+func (s *kubemonServer) handle(w http.ResponseWriter, r *http.Request) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	var pod kubemon.Pod
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/namespaces/") || !strings.HasSuffix(r.URL.Path, "/pods") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&pod); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.requests = append(s.requests, pod)
+
+	writeJSON(w, http.StatusOK, &kubemon.PodMetadata{Subnet: s.subnet, PG: []string{}})
+}
+
+// deletions returns the pod deletion notifications received
+func (s *kubemonServer) deletions() []kubemon.Pod {
+
+	s.Lock()
+	defer s.Unlock()
+
+	var pods []kubemon.Pod
+	for _, pod := range s.requests {
+		if pod.Action == "delete" {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
+func (s *kubemonServer) close() {
+	s.server.Close()
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeStatus(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]interface{}{
+		"kind":       "Status",
+		"apiVersion": "v1",
+		"status":     "Failure",
+		"message":    message,
+		"code":       code,
+	})
+}
+
+// certificate is a PEM encoded certificate along with its key
+type certificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newCA creates a self signed CA
+func newCA() (*certificate, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "nuage-cni-e2e-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	return newCertificate(template, template, key, key)
+}
+
+// issue returns a certificate signed by the CA
+func (ca *certificate) issue(commonName string, usage x509.ExtKeyUsage) (*certificate, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	return newCertificate(template, ca.cert, key, ca.key)
+}
+
+func newCertificate(template *x509.Certificate, parent *x509.Certificate, key *ecdsa.PrivateKey, parentKey *ecdsa.PrivateKey) (*certificate, error) {
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &certificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}, nil
+}
+
+// write writes the PEM encoded certificate
+// and, if a key file is given, its key
+func (c *certificate) write(certFile string, keyFile string) error {
+
+	if err := ioutil.WriteFile(certFile, c.certPEM, 0600); err != nil {
+		return err
+	}
+	if keyFile == "" {
+		return nil
+	}
+	return ioutil.WriteFile(keyFile, c.keyPEM, 0600)
+}
+
+func (c *certificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
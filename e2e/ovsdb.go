@@ -0,0 +1,593 @@
+//go:build e2e
+// +build e2e
+
+// Package e2e runs Nuage CNI plugin binary in network namespaces
+// against a local OVSDB server standing in for VRS and fake K8S
+// API server and Nuage K8S monitor
+
+package e2e
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/cenk/rpc2"
+	"github.com/cenk/rpc2/jsonrpc"
+	"github.com/socketplane/libovsdb"
+)
+
+// Names of the VRS database and tables used by libvrsdk
+const (
+	vrsDatabase     = "Open_vSwitch"
+	portTable       = "Nuage_Port_Table"
+	vmTable         = "Nuage_VM_Table"
+	controllerTable = "Controller"
+	bridgeTable     = "Bridge"
+	ovsPortTable    = "Port"
+	interfaceTable  = "Interface"
+)
+
+// Column types of the VRS schema
+var (
+	stringMapType = map[string]interface{}{"key": "string", "value": "string", "min": 0, "max": "unlimited"}
+	stringSetType = map[string]interface{}{"key": "string", "min": 0, "max": "unlimited"}
+	uuidSetType   = map[string]interface{}{"key": map[string]interface{}{"type": "uuid"}, "min": 0, "max": "unlimited"}
+)
+
+// vrsTables lists the columns of every table libvrsdk uses
+var vrsTables = map[string]map[string]interface{}{
+	portTable: {
+		"name": "string", "mac": "string", "ip_addr": "string", "subnet_mask": "string",
+		"gateway": "string", "bridge": "string", "alias": "string", "nuage_domain": "string",
+		"nuage_network": "string", "nuage_zone": "string", "nuage_network_type": "string",
+		"evpn_id": "integer", "vrf_id": "integer", "vm_domain": "integer",
+		"metadata": stringMapType, "dirty": "integer",
+	},
+	vmTable: {
+		"type": "integer", "event": "integer", "event_type": "integer", "state": "integer",
+		"reason": "integer", "vm_uuid": "string", "domain": "integer", "vm_name": "string",
+		"nuage_user": "string", "nuage_enterprise": "string", "metadata": stringMapType,
+		"ports": stringSetType, "dirty": "integer",
+	},
+	controllerTable: {"role": "string"},
+	bridgeTable:     {"name": "string", "ports": uuidSetType},
+	ovsPortTable:    {"name": "string", "interfaces": uuidSetType, "external_ids": stringMapType},
+	interfaceTable:  {"name": "string", "external_ids": stringMapType},
+}
+
+// ovsdbServer is a minimal OVSDB JSON-RPC server holding the
+// VRS tables in memory. It implements the transact operations
+// and the monitor notifications libvrsdk relies on
+type ovsdbServer struct {
+	sync.Mutex
+	socket   string
+	listener net.Listener
+	tables   map[string]map[string]map[string]interface{}
+	monitors map[*rpc2.Client]*monitor
+}
+
+// monitor is the Nuage port table monitor of a client
+type monitor struct {
+	context interface{}
+	columns map[string][]string
+}
+
+// rowChange is a row inserted, modified or deleted by a transaction
+type rowChange struct {
+	table string
+	uuid  string
+	old   map[string]interface{}
+	new   map[string]interface{}
+}
+
+// newOVSDBServer starts an OVSDB server on the unix socket with
+// alubr0 and a controller connection in master role
+func newOVSDBServer(socket string) (*ovsdbServer, error) {
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ovsdbServer{
+		socket:   socket,
+		listener: listener,
+		tables:   make(map[string]map[string]map[string]interface{}),
+		monitors: make(map[*rpc2.Client]*monitor),
+	}
+	for table := range vrsTables {
+		s.tables[table] = make(map[string]map[string]interface{})
+	}
+
+	_, err = s.transact([]libovsdb.Operation{
+		{Op: "insert", Table: controllerTable, Row: map[string]interface{}{"role": "master"}},
+		{Op: "insert", Table: bridgeTable, Row: map[string]interface{}{"name": "alubr0", "ports": []interface{}{"set", []interface{}{}}}},
+	})
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	server := rpc2.NewServer()
+	server.Handle("list_dbs", s.listDbs)
+	server.Handle("get_schema", s.getSchema)
+	server.Handle("transact", s.handleTransact)
+	server.Handle("monitor", s.handleMonitor)
+	server.Handle("echo", s.echo)
+	server.OnDisconnect(func(client *rpc2.Client) {
+		s.Lock()
+		defer s.Unlock()
+		delete(s.monitors, client)
+	})
+
+	// rpc2 Accept exits the process once the listener is closed
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(jsonrpc.NewJSONCodec(conn))
+		}
+	}()
+
+	return s, nil
+}
+
+// close stops accepting OVSDB connections
+func (s *ovsdbServer) close() {
+	s.listener.Close()
+	os.Remove(s.socket)
+}
+
+func (s *ovsdbServer) listDbs(client *rpc2.Client, args []interface{}, reply *[]string) error {
+	*reply = []string{vrsDatabase}
+	return nil
+}
+
+func (s *ovsdbServer) getSchema(client *rpc2.Client, args []interface{}, reply *libovsdb.DatabaseSchema) error {
+
+	if len(args) != 1 || args[0] != vrsDatabase {
+		return fmt.Errorf("unknown database %v", args)
+	}
+
+	schema := libovsdb.DatabaseSchema{Name: vrsDatabase, Version: "7.12.1", Tables: make(map[string]libovsdb.TableSchema)}
+	for table, columns := range vrsTables {
+		tableSchema := libovsdb.TableSchema{Columns: make(map[string]libovsdb.ColumnSchema)}
+		for column, columnType := range columns {
+			tableSchema.Columns[column] = libovsdb.ColumnSchema{Name: column, Type: columnType}
+		}
+		schema.Tables[table] = tableSchema
+	}
+	*reply = schema
+	return nil
+}
+
+func (s *ovsdbServer) echo(client *rpc2.Client, args []interface{}, reply *[]interface{}) error {
+	*reply = args
+	return nil
+}
+
+func (s *ovsdbServer) handleTransact(client *rpc2.Client, args []interface{}, reply *[]interface{}) error {
+
+	if len(args) < 1 || args[0] != vrsDatabase {
+		return fmt.Errorf("unknown database %v", args)
+	}
+
+	var operations []libovsdb.Operation
+	data, err := json.Marshal(args[1:])
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &operations); err != nil {
+		return err
+	}
+
+	results, err := s.transact(operations)
+	if err != nil {
+		*reply = append(results, map[string]interface{}{"error": "constraint violation", "details": err.Error()})
+		return nil
+	}
+	*reply = results
+	return nil
+}
+
+// transact runs the operations as a single transaction and
+// notifies monitoring clients of the rows it changed
+func (s *ovsdbServer) transact(operations []libovsdb.Operation) ([]interface{}, error) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Operations work on a copy of the tables
+	// which is kept only if all of them succeed
+	tables := make(map[string]map[string]map[string]interface{})
+	for table, rows := range s.tables {
+		tables[table] = make(map[string]map[string]interface{})
+		for uuid, row := range rows {
+			tables[table][uuid] = row
+		}
+	}
+
+	namedUUIDs := make(map[string]string)
+	var changes []rowChange
+	var results []interface{}
+	for _, op := range operations {
+		rows, ok := tables[op.Table]
+		if !ok {
+			return results, fmt.Errorf("unknown table %s", op.Table)
+		}
+		row := resolveNamedUUIDs(op.Row, namedUUIDs).(map[string]interface{})
+
+		switch op.Op {
+		case "insert":
+			uuid := newUUID()
+			if op.UUIDName != "" {
+				namedUUIDs[op.UUIDName] = uuid
+			}
+			rows[uuid] = row
+			changes = append(changes, rowChange{table: op.Table, uuid: uuid, new: row})
+			results = append(results, map[string]interface{}{"uuid": []interface{}{"uuid", uuid}})
+
+		case "select":
+			var selected []map[string]interface{}
+			for uuid, current := range rows {
+				match, err := matches(uuid, current, op.Where)
+				if err != nil {
+					return results, err
+				}
+				if match {
+					selected = append(selected, project(uuid, current, op.Columns))
+				}
+			}
+			results = append(results, map[string]interface{}{"rows": selected})
+
+		case "update", "mutate", "delete":
+			count := 0
+			for uuid, current := range rows {
+				match, err := matches(uuid, current, op.Where)
+				if err != nil {
+					return results, err
+				}
+				if !match {
+					continue
+				}
+				count++
+
+				var updated map[string]interface{}
+				switch op.Op {
+				case "update":
+					updated = copyRow(current)
+					for column, value := range row {
+						updated[column] = value
+					}
+				case "mutate":
+					mutations := resolveNamedUUIDs(op.Mutations, namedUUIDs).([]interface{})
+					if updated, err = mutate(current, mutations); err != nil {
+						return results, err
+					}
+				}
+
+				if updated == nil {
+					delete(rows, uuid)
+				} else {
+					rows[uuid] = updated
+				}
+				changes = append(changes, rowChange{table: op.Table, uuid: uuid, old: current, new: updated})
+			}
+			results = append(results, map[string]interface{}{"count": count})
+
+		default:
+			return results, fmt.Errorf("unsupported operation %s", op.Op)
+		}
+	}
+
+	s.tables = tables
+	s.notify(changes)
+	return results, nil
+}
+
+func (s *ovsdbServer) handleMonitor(client *rpc2.Client, args []interface{}, reply *map[string]map[string]interface{}) error {
+
+	if len(args) != 3 || args[0] != vrsDatabase {
+		return fmt.Errorf("invalid monitor request %v", args)
+	}
+
+	var requests map[string]libovsdb.MonitorRequest
+	data, err := json.Marshal(args[2])
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &requests); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	m := &monitor{context: args[1], columns: make(map[string][]string)}
+	initial := make(map[string]map[string]interface{})
+	for table, request := range requests {
+		rows, ok := s.tables[table]
+		if !ok {
+			return fmt.Errorf("unknown table %s", table)
+		}
+		m.columns[table] = request.Columns
+		if !request.Select.Initial {
+			continue
+		}
+		initial[table] = make(map[string]interface{})
+		for uuid, row := range rows {
+			initial[table][uuid] = map[string]interface{}{"new": project(uuid, row, request.Columns)}
+		}
+	}
+	s.monitors[client] = m
+
+	*reply = initial
+	return nil
+}
+
+// notify sends the changed rows, in order, to every client monitoring
+// their table. Changes of unmonitored columns are skipped
+func (s *ovsdbServer) notify(changes []rowChange) {
+
+	for client, m := range s.monitors {
+		updates := make(map[string]map[string]interface{})
+		for _, change := range changes {
+			columns, ok := m.columns[change.table]
+			if !ok {
+				continue
+			}
+
+			update := make(map[string]interface{})
+			if change.old != nil {
+				update["old"] = project(change.uuid, change.old, columns)
+			}
+			if change.new != nil {
+				update["new"] = project(change.uuid, change.new, columns)
+			}
+			if change.old != nil && change.new != nil && reflect.DeepEqual(update["old"], update["new"]) {
+				continue
+			}
+
+			if updates[change.table] == nil {
+				updates[change.table] = make(map[string]interface{})
+			}
+			updates[change.table][change.uuid] = update
+		}
+
+		if len(updates) > 0 {
+			_ = client.Notify("update", []interface{}{m.context, updates})
+		}
+	}
+}
+
+// rows returns the rows of a table
+func (s *ovsdbServer) rows(table string) []map[string]interface{} {
+
+	s.Lock()
+	defer s.Unlock()
+
+	var rows []map[string]interface{}
+	for uuid, row := range s.tables[table] {
+		rows = append(rows, project(uuid, row, nil))
+	}
+	return rows
+}
+
+// findRow returns the row of a table with the given name
+// column value or nil if there is none
+func (s *ovsdbServer) findRow(table string, column string, value string) map[string]interface{} {
+
+	for _, row := range s.rows(table) {
+		if row[column] == value {
+			return row
+		}
+	}
+	return nil
+}
+
+// waitForRow waits for a row with the given column value to
+// be added to a table, e.g. for the plugin to create a port
+func (s *ovsdbServer) waitForRow(table string, column string, value string, timeout time.Duration) (map[string]interface{}, error) {
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if row := s.findRow(table, column, value); row != nil {
+			return row, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("no row with %s %s in %s after %s", column, value, table, timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// resolvePort writes the address of a port into its Nuage port
+// table row the way VRS does once the controller resolved it
+func (s *ovsdbServer) resolvePort(name string, ip string, gateway string, mask string) error {
+
+	results, err := s.transact([]libovsdb.Operation{{
+		Op:    "update",
+		Table: portTable,
+		Row: map[string]interface{}{
+			"ip_addr":     ip,
+			"gateway":     gateway,
+			"subnet_mask": mask,
+			"vrf_id":      1,
+		},
+		Where: []interface{}{libovsdb.NewCondition("name", "==", name)},
+	}})
+	if err != nil {
+		return err
+	}
+	if results[0].(map[string]interface{})["count"] != 1 {
+		return fmt.Errorf("port %s not found", name)
+	}
+	return nil
+}
+
+// matches evaluates the where clause of an operation on a row
+func matches(uuid string, row map[string]interface{}, where []interface{}) (bool, error) {
+
+	for _, clause := range where {
+		condition, ok := clause.([]interface{})
+		if !ok || len(condition) != 3 {
+			return false, fmt.Errorf("invalid condition %v", clause)
+		}
+		column, _ := condition[0].(string)
+		value := row[column]
+		if column == "_uuid" {
+			value = []interface{}{"uuid", uuid}
+		}
+
+		equal := reflect.DeepEqual(value, condition[2])
+		switch condition[1] {
+		case "==":
+			if !equal {
+				return false, nil
+			}
+		case "!=":
+			if equal {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("unsupported condition function %v", condition[1])
+		}
+	}
+	return true, nil
+}
+
+// mutate applies set insert and delete mutations to a row
+func mutate(row map[string]interface{}, mutations []interface{}) (map[string]interface{}, error) {
+
+	updated := copyRow(row)
+	for _, m := range mutations {
+		mutation, ok := m.([]interface{})
+		if !ok || len(mutation) != 3 {
+			return nil, fmt.Errorf("invalid mutation %v", m)
+		}
+		column, _ := mutation[0].(string)
+		elements := setElements(updated[column])
+
+		switch mutation[1] {
+		case "insert":
+			for _, element := range setElements(mutation[2]) {
+				if !containsElement(elements, element) {
+					elements = append(elements, element)
+				}
+			}
+		case "delete":
+			var kept []interface{}
+			for _, element := range elements {
+				if !containsElement(setElements(mutation[2]), element) {
+					kept = append(kept, element)
+				}
+			}
+			elements = kept
+		default:
+			return nil, fmt.Errorf("unsupported mutator %v", mutation[1])
+		}
+
+		if elements == nil {
+			elements = []interface{}{}
+		}
+		updated[column] = []interface{}{"set", elements}
+	}
+	return updated, nil
+}
+
+// setElements returns the elements of an OVSDB set, which
+// is either a single atom or a ["set", [atoms]] array
+func setElements(value interface{}) []interface{} {
+
+	if value == nil {
+		return nil
+	}
+	if set, ok := value.([]interface{}); ok && len(set) == 2 && set[0] == "set" {
+		elements, _ := set[1].([]interface{})
+		return append([]interface{}{}, elements...)
+	}
+	return []interface{}{value}
+}
+
+func containsElement(elements []interface{}, element interface{}) bool {
+	for _, e := range elements {
+		if reflect.DeepEqual(e, element) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveNamedUUIDs replaces references to rows inserted
+// earlier in the transaction by their UUIDs
+func resolveNamedUUIDs(value interface{}, namedUUIDs map[string]string) interface{} {
+
+	switch v := value.(type) {
+	case []interface{}:
+		if len(v) == 2 && v[0] == "named-uuid" {
+			if name, ok := v[1].(string); ok {
+				return []interface{}{"uuid", namedUUIDs[name]}
+			}
+		}
+		resolved := make([]interface{}, len(v))
+		for i, element := range v {
+			resolved[i] = resolveNamedUUIDs(element, namedUUIDs)
+		}
+		return resolved
+	case map[string]interface{}:
+		resolved := make(map[string]interface{})
+		for key, element := range v {
+			resolved[key] = resolveNamedUUIDs(element, namedUUIDs)
+		}
+		return resolved
+	}
+	return value
+}
+
+// project returns the given columns of a row along with its
+// UUID. All columns are returned if none are given
+func project(uuid string, row map[string]interface{}, columns []string) map[string]interface{} {
+
+	projected := make(map[string]interface{})
+	if len(columns) == 0 {
+		for column, value := range row {
+			projected[column] = value
+		}
+		projected["_uuid"] = []interface{}{"uuid", uuid}
+		return projected
+	}
+
+	for _, column := range columns {
+		if column == "_uuid" {
+			projected[column] = []interface{}{"uuid", uuid}
+		} else if value, ok := row[column]; ok {
+			projected[column] = value
+		}
+	}
+	return projected
+}
+
+func copyRow(row map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{})
+	for column, value := range row {
+		copied[column] = value
+	}
+	return copied
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,54 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nuagenetworks/nuage-cni/client"
+	"github.com/vishvananda/netlink"
+)
+
+func TestProbeGateway(t *testing.T) {
+
+	h := newHarness(t, nil)
+	defer h.close()
+	p := h.newPod("e2e-ns", "nginx")
+
+	done := h.add(p)
+	h.waitForPort(p)
+	if err := h.ovsdb.resolvePort(p.portName, "10.10.0.5", "10.10.0.1", "255.255.255.0"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case added := <-done:
+		if added.err != nil {
+			t.Fatalf("ADD failed: %s: %s", added.err.Msg, added.err.Details)
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("ADD did not complete")
+	}
+
+	// Nothing answers for the gateway until the host end
+	// of the veth pair gets the gateway address
+	if err := client.ProbeGateway(p.netns, "eth0", "10.10.0.1", 600*time.Millisecond); err == nil {
+		t.Error("expected probe of an absent gateway to fail")
+	}
+
+	hostVeth, err := netlink.LinkByName(p.portName)
+	if err != nil {
+		t.Fatalf("host veth %s not found: %v", p.portName, err)
+	}
+	gateway := &netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP("10.10.0.1"), Mask: net.CIDRMask(32, 32)}}
+	if err = netlink.AddrAdd(hostVeth, gateway); err != nil {
+		t.Fatal(err)
+	}
+	defer netlink.AddrDel(hostVeth, gateway)
+
+	if err = client.ProbeGateway(p.netns, "eth0", "10.10.0.1", 3*time.Second); err != nil {
+		t.Errorf("gateway probe failed: %v", err)
+	}
+}
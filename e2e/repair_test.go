@@ -0,0 +1,195 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/nuagenetworks/nuage-cni/config"
+	"github.com/socketplane/libovsdb"
+	"github.com/vishvananda/netlink"
+)
+
+func TestForceDetach(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		target func(p *pod) string
+		setup  func(h *harness, p *pod)
+	}{
+		{
+			name:   "by pod name",
+			target: func(p *pod) string { return p.namespace + "/" + p.name },
+		},
+		{
+			name:   "by container ID",
+			target: func(p *pod) string { return p.containerID[:12] },
+		},
+		{
+			name:   "entity already gone",
+			target: func(p *pod) string { return p.namespace + "/" + p.name },
+			setup: func(h *harness, p *pod) {
+				_, err := h.ovsdb.transact([]libovsdb.Operation{{
+					Op:    "delete",
+					Table: vmTable,
+					Where: []interface{}{libovsdb.NewCondition("vm_uuid", "==", p.containerID)},
+				}})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t, func(conf *config.Config) {
+				conf.PortResolveTimer = 2
+				conf.SubnetExhaustionRetries = -1
+			})
+			defer h.close()
+			p := h.newPod("e2e-ns", "stuck")
+
+			// ADD fails and leaves the pod's VRS entries behind
+			// as no DEL follows
+			select {
+			case added := <-h.add(p):
+				if added.err == nil {
+					t.Fatal("expected ADD to fail")
+				}
+			case <-time.After(time.Minute):
+				t.Fatal("ADD did not complete")
+			}
+			if h.ovsdb.findRow(portTable, "name", p.portName) == nil {
+				t.Fatalf("port %s not left behind by ADD", p.portName)
+			}
+			if test.setup != nil {
+				test.setup(h, p)
+			}
+
+			if _, err := h.admin("force-detach", test.target(p)); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := netlink.LinkByName(p.portName); err == nil {
+				t.Errorf("host veth %s was not removed", p.portName)
+			}
+			for _, table := range []string{portTable, vmTable, ovsPortTable} {
+				if rows := h.ovsdb.rows(table); len(rows) != 0 {
+					t.Errorf("rows left behind in %s: %v", table, rows)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(h.dir, "state", "pods", p.containerID+".json")); !os.IsNotExist(err) {
+				t.Errorf("pod state record left behind: %v", err)
+			}
+			deletions := h.kubemon.deletions()
+			if len(deletions) != 1 || deletions[0].Name != p.name || deletions[0].Zone != p.namespace {
+				t.Errorf("unexpected pod deletion notifications %+v", deletions)
+			}
+		})
+	}
+}
+
+func TestForceDetachUnknownPod(t *testing.T) {
+
+	h := newHarness(t, nil)
+	defer h.close()
+
+	if _, err := h.admin("force-detach", "e2e-ns/unknown"); err == nil {
+		t.Error("expected force-detach of an unknown pod to fail")
+	}
+}
+
+func TestReResolve(t *testing.T) {
+
+	h := newHarness(t, nil)
+	defer h.close()
+	p := h.newPod("e2e-ns", "nginx")
+
+	done := h.add(p)
+	old := h.waitForPort(p)
+	if err := h.ovsdb.resolvePort(p.portName, "10.10.0.5", "10.10.0.1", "255.255.255.0"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case added := <-done:
+		if added.err != nil {
+			t.Fatalf("ADD failed: %s: %s", added.err.Msg, added.err.Details)
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("ADD did not complete")
+	}
+
+	type output struct {
+		stdout string
+		err    error
+	}
+	reResolved := make(chan output, 1)
+	go func() {
+		stdout, err := h.admin("re-resolve", p.namespace+"/"+p.name)
+		reResolved <- output{stdout, err}
+	}()
+
+	// The port is re-created and resolved with another address
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		row := h.ovsdb.findRow(portTable, "name", p.portName)
+		if row != nil && !reflect.DeepEqual(row["_uuid"], old["_uuid"]) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("port %s was not re-created", p.portName)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := h.ovsdb.resolvePort(p.portName, "10.10.0.7", "10.10.0.1", "255.255.255.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	var out output
+	select {
+	case out = <-reResolved:
+	case <-time.After(time.Minute):
+		t.Fatal("re-resolve did not complete")
+	}
+	if out.err != nil {
+		t.Fatal(out.err)
+	}
+	result := &types.Result{}
+	if err := json.Unmarshal([]byte(out.stdout), result); err != nil {
+		t.Fatalf("invalid result %q: %v", out.stdout, err)
+	}
+	if ip := result.IP4.IP.String(); ip != "10.10.0.7/24" {
+		t.Errorf("expected IP 10.10.0.7/24 in result, got %s", ip)
+	}
+
+	err := ns.WithNetNSPath(p.netns, func(ns.NetNS) error {
+		link, err := netlink.LinkByName("eth0")
+		if err != nil {
+			return err
+		}
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		if err != nil {
+			return err
+		}
+		if len(addrs) != 1 || addrs[0].IPNet.String() != "10.10.0.7/24" {
+			return fmt.Errorf("expected address 10.10.0.7/24, got %v", addrs)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("inspecting pod network namespace failed: %v", err)
+	}
+	if rows := h.ovsdb.rows(vmTable); len(rows) != 1 {
+		t.Errorf("expected a single entity, got %v", rows)
+	}
+}
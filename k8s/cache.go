@@ -0,0 +1,193 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/nuagenetworks/nuage-cni/config"
+	"github.com/nuagenetworks/nuage-cni/kubemon"
+	log "github.com/sirupsen/logrus"
+)
+
+var subnetCacheFile string
+var subnetCacheTTL time.Duration
+var subnetCacheGracePeriod time.Duration
+var podMetadataCached bool
+
+// subnetDecision is a subnet and policy group
+// decision made by Nuage K8S monitor
+type subnetDecision struct {
+	Subnet  string    `json:"subnet"`
+	PG      []string  `json:"policyGroups,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// subnetCache holds the last decisions of Nuage K8S monitor
+// and when it was last reached from the node
+type subnetCache struct {
+	LastContact time.Time                  `json:"lastContact"`
+	Decisions   map[string]*subnetDecision `json:"decisions"`
+}
+
+// ConfigureSubnetCache sets up the node local cache of Nuage K8S
+// monitor decisions used while the monitor is unreachable. A
+// negative grace period disables the fallback to the cache
+func ConfigureSubnetCache(conf *config.Config) {
+	subnetCacheFile = conf.SubnetCacheFile
+	subnetCacheTTL = time.Duration(conf.SubnetCacheTTL) * time.Second
+	subnetCacheGracePeriod = time.Duration(conf.SubnetCacheGracePeriod) * time.Second
+}
+
+// IsPodMetadataCached reports whether the last pod metadata
+// was taken from the subnet cache instead of Nuage K8S monitor
+func IsPodMetadataCached() bool {
+	return podMetadataCached
+}
+
+// subnetCacheKey identifies a decision by the pod namespace
+// and the zone and subnet requested through pod labels
+func subnetCacheKey(ns string, pod *kubemon.Pod) string {
+	return ns + "/" + pod.Zone + "/" + pod.Subnet
+}
+
+func loadSubnetCache() (*subnetCache, error) {
+
+	cache := &subnetCache{Decisions: make(map[string]*subnetDecision)}
+	data, err := ioutil.ReadFile(subnetCacheFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, cache); err != nil {
+		return nil, fmt.Errorf("Error parsing subnet cache %s: %v", subnetCacheFile, err)
+	}
+	if cache.Decisions == nil {
+		cache.Decisions = make(map[string]*subnetDecision)
+	}
+
+	return cache, nil
+}
+
+// lockSubnetCache takes an exclusive lock on the subnet cache so
+// that concurrent CNI invocations do not lose each other's updates
+func lockSubnetCache() (func(), error) {
+
+	if err := os.MkdirAll(filepath.Dir(subnetCacheFile), 0700); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(subnetCacheFile+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+		lock.Close()
+	}, nil
+}
+
+func saveSubnetCache(cache *subnetCache) error {
+
+	dir := filepath.Dir(subnetCacheFile)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Readers do not take the lock and see either
+	// the previous or the new cache
+	tmp, err := ioutil.TempFile(dir, filepath.Base(subnetCacheFile))
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+
+	return os.Rename(tmp.Name(), subnetCacheFile)
+}
+
+// storeSubnetDecision records a decision of Nuage K8S monitor
+// and drops decisions that were not refreshed within the TTL
+func storeSubnetDecision(ns string, pod *kubemon.Pod, result *kubemon.PodMetadata) {
+
+	if subnetCacheFile == "" {
+		return
+	}
+
+	unlock, err := lockSubnetCache()
+	if err != nil {
+		log.Warnf("Unable to lock subnet cache %s: %v", subnetCacheFile, err)
+		return
+	}
+	defer unlock()
+
+	cache, err := loadSubnetCache()
+	if err != nil {
+		log.Warnf("Discarding subnet cache: %v", err)
+		cache = &subnetCache{Decisions: make(map[string]*subnetDecision)}
+	}
+
+	now := time.Now()
+	cache.LastContact = now
+	cache.Decisions[subnetCacheKey(ns, pod)] = &subnetDecision{Subnet: result.Subnet, PG: result.PG, Updated: now}
+	for key, decision := range cache.Decisions {
+		if now.Sub(decision.Updated) > subnetCacheTTL {
+			delete(cache.Decisions, key)
+		}
+	}
+
+	if err = saveSubnetCache(cache); err != nil {
+		log.Warnf("Unable to update subnet cache %s: %v", subnetCacheFile, err)
+	}
+}
+
+// lookupSubnetDecision returns the cached decision for the pod if
+// Nuage K8S monitor failed with a transient error, was last reached
+// within the grace period and the decision is within its TTL
+func lookupSubnetDecision(ns string, pod *kubemon.Pod, kubemonErr error) *kubemon.PodMetadata {
+
+	if subnetCacheFile == "" || subnetCacheGracePeriod < 0 || !kubemon.IsTransient(kubemonErr) {
+		return nil
+	}
+
+	cache, err := loadSubnetCache()
+	if err != nil {
+		log.Warnf("Unable to use subnet cache: %v", err)
+		return nil
+	}
+
+	now := time.Now()
+	if now.Sub(cache.LastContact) > subnetCacheGracePeriod {
+		log.Warnf("Nuage K8S monitor unreachable since %s; subnet cache grace period of %s expired",
+			cache.LastContact.Format(time.RFC3339), subnetCacheGracePeriod)
+		return nil
+	}
+
+	decision, ok := cache.Decisions[subnetCacheKey(ns, pod)]
+	if !ok || now.Sub(decision.Updated) > subnetCacheTTL {
+		log.Warnf("No cached subnet decision for pod %s under namespace %s", pod.Name, ns)
+		return nil
+	}
+
+	return &kubemon.PodMetadata{Subnet: decision.Subnet, PG: decision.PG}
+}
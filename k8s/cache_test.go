@@ -0,0 +1,148 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nuagenetworks/nuage-cni/kubemon"
+)
+
+func TestLookupSubnetDecision(t *testing.T) {
+
+	transientErr := &url.Error{Op: "Post", URL: "https://kubemon:9443", Err: errors.New("connection refused")}
+	clientErr := &kubemon.ClientError{StatusError: kubemon.StatusError{StatusCode: 404, Status: "404 Not Found"}}
+	pod := &kubemon.Pod{Name: "nginx"}
+
+	tests := []struct {
+		name        string
+		err         error
+		lastContact time.Duration
+		updated     time.Duration
+		grace       time.Duration
+		wantSubnet  string
+	}{
+		{name: "transient error", err: transientErr, wantSubnet: "subnet1"},
+		{name: "client error", err: clientErr},
+		{name: "grace period expired", err: transientErr, lastContact: 2 * time.Hour},
+		{name: "decision expired", err: transientErr, updated: 48 * time.Hour},
+		{name: "fallback disabled", err: transientErr, grace: -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "subnetcache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			subnetCacheFile = filepath.Join(dir, "subnet-cache.json")
+			subnetCacheTTL = 24 * time.Hour
+			subnetCacheGracePeriod = time.Hour
+			if test.grace != 0 {
+				subnetCacheGracePeriod = test.grace
+			}
+
+			now := time.Now()
+			cache := &subnetCache{
+				LastContact: now.Add(-test.lastContact),
+				Decisions: map[string]*subnetDecision{
+					subnetCacheKey("default", pod): {Subnet: "subnet1", Updated: now.Add(-test.updated)},
+				},
+			}
+			if err = saveSubnetCache(cache); err != nil {
+				t.Fatal(err)
+			}
+
+			result := lookupSubnetDecision("default", pod, test.err)
+			if test.wantSubnet == "" {
+				if result != nil {
+					t.Errorf("expected no cached decision, got subnet %s", result.Subnet)
+				}
+				return
+			}
+			if result == nil || result.Subnet != test.wantSubnet {
+				t.Errorf("expected cached subnet %s, got %+v", test.wantSubnet, result)
+			}
+		})
+	}
+}
+
+func TestStoreSubnetDecision(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "subnetcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	subnetCacheFile = filepath.Join(dir, "subnet-cache.json")
+	subnetCacheTTL = time.Hour
+	subnetCacheGracePeriod = time.Hour
+
+	stale := &kubemon.Pod{Name: "old"}
+	cache := &subnetCache{
+		Decisions: map[string]*subnetDecision{
+			subnetCacheKey("default", stale): {Subnet: "subnet0", Updated: time.Now().Add(-2 * time.Hour)},
+		},
+	}
+	if err = saveSubnetCache(cache); err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &kubemon.Pod{Name: "nginx", Zone: "zone1", Subnet: "subnet1"}
+	storeSubnetDecision("default", pod, &kubemon.PodMetadata{Subnet: "subnet1", PG: []string{"pg1"}})
+
+	cache, err = loadSubnetCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(cache.LastContact) > time.Minute {
+		t.Errorf("last contact with Nuage K8S monitor not updated")
+	}
+	if _, ok := cache.Decisions[subnetCacheKey("default", stale)]; ok {
+		t.Errorf("expired decision was not dropped")
+	}
+	decision, ok := cache.Decisions[subnetCacheKey("default", pod)]
+	if !ok || decision.Subnet != "subnet1" || len(decision.PG) != 1 {
+		t.Errorf("unexpected cached decision %+v", decision)
+	}
+}
+
+func TestStoreSubnetDecisionConcurrent(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "subnetcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	subnetCacheFile = filepath.Join(dir, "subnet-cache.json")
+	subnetCacheTTL = time.Hour
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pod := &kubemon.Pod{Name: "nginx", Zone: fmt.Sprintf("zone%d", i)}
+			storeSubnetDecision("default", pod, &kubemon.PodMetadata{Subnet: "subnet1"})
+		}(i)
+	}
+	wg.Wait()
+
+	cache, err := loadSubnetCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.Decisions) != 10 {
+		t.Errorf("expected 10 cached decisions, got %d", len(cache.Decisions))
+	}
+}
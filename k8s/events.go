@@ -0,0 +1,171 @@
+package k8s
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Reasons for K8S events recorded by Nuage CNI plugin and audit daemon
+const (
+	ReasonVSCDisconnected       = "NuageVSCDisconnected"
+	ReasonVSCReconnected        = "NuageVSCReconnected"
+	ReasonSubnetUnavailable     = "NuageSubnetUnavailable"
+	ReasonPortResolveTimeout    = "NuagePortResolveTimeout"
+	ReasonPortResolveSlow       = "NuagePortResolveSlow"
+	ReasonMetadataMissing       = "NuageMetadataMissing"
+	ReasonNetworkAttached       = "NuageNetworkAttached"
+	ReasonPolicyUpdated         = "NuagePolicyUpdated"
+	ReasonPolicyUpdateFailed    = "NuagePolicyUpdateFailed"
+	ReasonAuditDeletionsBlocked = "NuageAuditDeletionsBlocked"
+	ReasonNetworkRestored       = "NuageNetworkRestored"
+	ReasonNetworkRestoreFailed  = "NuageNetworkRestoreFailed"
+	ReasonSubnetFromCache       = "NuageSubnetFromCache"
+	ReasonSubnetReconciled      = "NuageSubnetReconciled"
+	ReasonSubnetMismatch        = "NuageSubnetMismatch"
+	ReasonSubnetExhausted       = "NuageSubnetExhausted"
+)
+
+// EventSourceComponent is the component reported as source of Nuage events
+const EventSourceComponent = "nuage-cni"
+
+// SubnetUnavailableError is returned when Nuage K8S monitor
+// could not provide a subnet for the pod
+type SubnetUnavailableError struct {
+	Err error
+}
+
+func (e *SubnetUnavailableError) Error() string {
+	return e.Err.Error()
+}
+
+// RecordEvent creates a K8S event for the referenced object
+func RecordEvent(kubeClient kclient.Interface, ref *corev1.ObjectReference, eventType string, reason string, message string) error {
+
+	host, _ := os.Hostname()
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+			Namespace: ref.Namespace,
+		},
+		InvolvedObject: *ref,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: EventSourceComponent, Host: host},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if event.Namespace == "" {
+		event.Namespace = metav1.NamespaceDefault
+	}
+
+	_, err := kubeClient.CoreV1().Events(event.Namespace).Create(event)
+	if err != nil {
+		log.Warnf("Error recording event %s for %s %s: %v", reason, ref.Kind, ref.Name, err)
+		return err
+	}
+
+	log.Debugf("Recorded event %s for %s %s: %s", reason, ref.Kind, ref.Name, message)
+	return nil
+}
+
+// PodReference returns an object reference to the pod
+// that is used to record events against it
+func PodReference(name string, ns string, uid string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Name:       name,
+		Namespace:  ns,
+		UID:        types.UID(uid),
+	}
+}
+
+// NodeReference returns an object reference to the node
+// that is used to record events against it
+func NodeReference(name string) *corev1.ObjectReference {
+	// kubectl describe matches node events using the node name as UID
+	return &corev1.ObjectReference{
+		Kind:       "Node",
+		APIVersion: "v1",
+		Name:       name,
+		UID:        types.UID(name),
+	}
+}
+
+// Pod events are recorded on the CNI path, so API server requests
+// time out quickly and recording as a whole is given a bounded wait
+const (
+	eventRequestTimeout = 2 * time.Second
+	eventRecordDeadline = 3 * time.Second
+)
+
+// eventClient is the clientset used to record pod events,
+// built once from Nuage kubeconfig on the node
+var eventClient kclient.Interface
+
+func getEventClient(orchestrator string) (kclient.Interface, error) {
+
+	if eventClient != nil {
+		return eventClient, nil
+	}
+	if kubeconfFile == "" {
+		if err := initNuageConfig(orchestrator); err != nil {
+			return nil, err
+		}
+	}
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfFile)
+	if err != nil {
+		return nil, err
+	}
+	restConfig.Timeout = eventRequestTimeout
+	eventClient, err = kclient.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return eventClient, nil
+}
+
+// RecordPodEvent records an event on the pod with the UID using
+// Nuage kubeconfig on the node. Failures are only logged since
+// events are informational, and it returns once the event is
+// recorded or the bounded wait for the API server is over
+func RecordPodEvent(name string, ns string, uid string, eventType string, reason string, message string, orchestrator string) {
+
+	kubeClient, err := getEventClient(orchestrator)
+	if err != nil {
+		log.Warnf("Unable to record event %s for pod %s: %v", reason, name, err)
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// kubectl describe matches events to pods using the pod UID
+		if uid == "" {
+			pod, err := kubeClient.CoreV1().Pods(ns).Get(name, metav1.GetOptions{})
+			if err == nil {
+				uid = string(pod.UID)
+			}
+		}
+		_ = RecordEvent(kubeClient, PodReference(name, ns, uid), eventType, reason, message)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(eventRecordDeadline):
+		log.Warnf("Gave up recording event %s for pod %s after %v", reason, name, eventRecordDeadline)
+	}
+}
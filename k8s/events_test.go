@@ -0,0 +1,78 @@
+package k8s
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kclient "k8s.io/client-go/kubernetes"
+	krestclient "k8s.io/client-go/rest"
+)
+
+func TestRecordPodEvent(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		hang     bool
+		wantUID  string
+		wantGets int
+	}{
+		{name: "recorded with given UID", wantUID: "uid1"},
+		{name: "API server hangs", hang: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			release := make(chan struct{})
+			events := make(chan *corev1.Event, 1)
+			gets := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.hang {
+					<-release
+					return
+				}
+				if r.Method == http.MethodGet {
+					gets++
+				}
+				event := &corev1.Event{}
+				_ = json.NewDecoder(r.Body).Decode(event)
+				events <- event
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(event)
+			}))
+			defer server.Close()
+			defer close(release)
+
+			var err error
+			eventClient, err = kclient.NewForConfig(&krestclient.Config{Host: server.URL, Timeout: eventRequestTimeout})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { eventClient = nil }()
+
+			start := time.Now()
+			RecordPodEvent("pod1", "ns1", test.wantUID, corev1.EventTypeNormal, ReasonNetworkAttached, "attached", "k8s")
+			if elapsed := time.Since(start); elapsed > eventRecordDeadline+time.Second {
+				t.Errorf("expected recording to give up within %v, took %v", eventRecordDeadline, elapsed)
+			}
+			if test.hang {
+				return
+			}
+
+			if gets != test.wantGets {
+				t.Errorf("expected %d pod lookups, got %d", test.wantGets, gets)
+			}
+			select {
+			case event := <-events:
+				if string(event.InvolvedObject.UID) != test.wantUID {
+					t.Errorf("expected event on pod UID %q, got %q", test.wantUID, event.InvolvedObject.UID)
+				}
+			default:
+				t.Error("expected event to be recorded")
+			}
+		})
+	}
+}
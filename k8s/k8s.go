@@ -1,17 +1,15 @@
 package k8s
 
 import (
-	"bytes"
-	"crypto/tls"
-	"crypto/x509"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
+	"time"
 
 	"github.com/nuagenetworks/nuage-cni/client"
 	"github.com/nuagenetworks/nuage-cni/config"
+	"github.com/nuagenetworks/nuage-cni/kubemon"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -20,10 +18,22 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// Pod labels used to assign Nuage zone, policy
+// group and redirection target to pods
+const (
+	ZoneLabel              = "nuage.io/zone"
+	PolicyGroupLabel       = "nuage.io/policy-group"
+	RedirectionTargetLabel = "nuage.io/redirection-target"
+)
+
 var vspK8SConfig = &config.NuageVSPK8SConfig{}
 var podNetwork string
 var podZone string
 var podPG string
+var podMonitorPG string
+var podPGLabeled bool
+var podRT string
+var podUID string
 var adminUser string
 var k8RESTConfig *krestclient.Config
 
@@ -34,44 +44,26 @@ var nuageMonClientKeyFile string
 var nuageMonClientCACertFile string
 
 var isHostAtomic bool
+var kubemonClient *kubemon.Client
 
-// NuageKubeMonResp will unmarshal JSON
-// response from Nuage kubemon service
-type NuageKubeMonResp struct {
-	Subnet string   `json:"subnetName"`
-	PG     []string `json:"policyGroups"`
-}
-
-// Pod will hold fields necessary to query
-// Nuage kubemon service to obtain pod metadata
-type Pod struct {
-	Name   string `json:"podName"`
-	Zone   string `json:"desiredZone,omitempty"`
-	Subnet string `json:"desiredSubnet,omitempty"`
-	Action string `json:"action,omitempty"`
-}
+// KubemonDeadline bounds a Nuage K8S monitor
+// request including its retries
+const KubemonDeadline = 30 * time.Second
 
 func getK8SLabelsPodUIDFromAPIServer(podNs string, podname string) error {
 
 	log.Infof("Obtaining labels from API server for pod %s under namespace %s", podname, podNs)
-	kubeConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfFile)
+	kubeClient, err := getKubeClient()
 	if err != nil {
-		log.Errorf("Error loading kubeconfig file: %v", err)
 		return err
 	}
-	// creates the clientset
-	k8RESTConfig = kubeConfig
-	kubeClient, err := kclient.NewForConfig(k8RESTConfig)
-	if err != nil {
-		log.Errorf("Error trying to create kubeclient: %v", err)
-		panic(err.Error())
-	}
 
 	pod, err := kubeClient.CoreV1().Pods(podNs).Get(podname, metav1.GetOptions{})
 	if err != nil {
 		log.Errorf("Error occured while querying pod %s under pod namespace %s: %v", podname, podNs, err)
 		return err
 	}
+	podUID = string(pod.UID)
 
 	if _, ok := pod.Labels["nuage.io/subnet"]; !ok {
 		podNetwork = ""
@@ -79,10 +71,10 @@ func getK8SLabelsPodUIDFromAPIServer(podNs string, podname string) error {
 		podNetwork = pod.Labels["nuage.io/subnet"]
 	}
 
-	if _, ok := pod.Labels["nuage.io/zone"]; !ok {
+	if _, ok := pod.Labels[ZoneLabel]; !ok {
 		podZone = podNs
 	} else {
-		podZone = pod.Labels["nuage.io/zone"]
+		podZone = pod.Labels[ZoneLabel]
 	}
 
 	if _, ok := pod.Labels["nuage.io/user"]; !ok {
@@ -91,15 +83,113 @@ func getK8SLabelsPodUIDFromAPIServer(podNs string, podname string) error {
 		adminUser = pod.Labels["nuage.io/user"]
 	}
 
-	if _, ok := pod.Labels["nuage.io/policy-group"]; !ok {
+	if _, ok := pod.Labels[PolicyGroupLabel]; !ok {
 		podPG = ""
 	} else {
-		podPG = pod.Labels["nuage.io/policy-group"]
+		podPG = pod.Labels[PolicyGroupLabel]
+	}
+	podPGLabeled = podPG != ""
+
+	if _, ok := pod.Labels[RedirectionTargetLabel]; !ok {
+		podRT = ""
+	} else {
+		podRT = pod.Labels[RedirectionTargetLabel]
 	}
 
 	return err
 }
 
+// getKubeClient creates a clientset for K8S API server
+// using Nuage kubeconfig file on the node
+func getKubeClient() (kclient.Interface, error) {
+
+	kubeConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfFile)
+	if err != nil {
+		log.Errorf("Error loading kubeconfig file: %v", err)
+		return nil, err
+	}
+	// creates the clientset
+	k8RESTConfig = kubeConfig
+	kubeClient, err := kclient.NewForConfig(k8RESTConfig)
+	if err != nil {
+		log.Errorf("Error trying to create kubeclient: %v", err)
+		return nil, err
+	}
+
+	return kubeClient, nil
+}
+
+// NewKubeClient creates a clientset for K8S API server
+// using Nuage kubeconfig file on the node
+func NewKubeClient(orchestrator string) (kclient.Interface, error) {
+
+	if err := initNuageConfig(orchestrator); err != nil {
+		return nil, err
+	}
+
+	return getKubeClient()
+}
+
+// initNuageConfig parses Nuage config file on agent nodes
+// and populates kubeconfig and certificate locations
+func initNuageConfig(orchestrator string) error {
+
+	initDataDir(orchestrator)
+
+	// Parsing Nuage VSP K8S yaml file on K8S agent nodes
+	err := getVSPK8SConfig()
+	if err != nil {
+		log.Errorf("Error in parsing Nuage k8s yaml file")
+		return fmt.Errorf("Error in parsing Nuage k8s yaml file: %s", err)
+	}
+
+	// Populating certificate and kubeconfig locations
+	// only for k8s as orchestrator
+	if orchestrator == "k8s" {
+		kubeconfFile = vspK8SConfig.KubeConfig
+		nuageMonClientCertFile = vspK8SConfig.NuageK8SMonClientCertFile
+		nuageMonClientKeyFile = vspK8SConfig.NuageK8SMonClientKeyFile
+		nuageMonClientCACertFile = vspK8SConfig.NuageK8SMonCAFile
+	}
+
+	return nil
+}
+
+// ValidateVSPConfig checks that Nuage VSP yaml file on the node
+// can be parsed and that the files it refers to exist
+func ValidateVSPConfig(orchestrator string) error {
+
+	initDataDir(orchestrator)
+
+	data, err := ioutil.ReadFile(vspK8sConfigFile)
+	if err != nil {
+		return fmt.Errorf("Error in reading from Nuage VSP k8s yaml file: %s", err)
+	}
+
+	vspConfig := &config.NuageVSPK8SConfig{}
+	if err = yaml.Unmarshal(data, vspConfig); err != nil {
+		return fmt.Errorf("Error in unmarshalling data from Nuage VSP k8s yaml file: %s", err)
+	}
+
+	if vspConfig.NuageK8SMonServer == "" {
+		return fmt.Errorf("Nuage K8S monitor server is not set in %s", vspK8sConfigFile)
+	}
+
+	if orchestrator == "k8s" {
+		for _, file := range []string{vspConfig.KubeConfig, vspConfig.NuageK8SMonClientCertFile,
+			vspConfig.NuageK8SMonClientKeyFile, vspConfig.NuageK8SMonCAFile} {
+			if file == "" {
+				continue
+			}
+			if _, err = os.Stat(file); err != nil {
+				return fmt.Errorf("Error accessing %s set in %s: %v", file, vspK8sConfigFile, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 func getVSPK8SConfig() error {
 
 	// Reading Nuage VSP K8S yaml file
@@ -126,82 +216,127 @@ func getVSPK8SConfig() error {
 func getPodMetadataFromNuageK8sMon(podname string, ns string) error {
 
 	log.Infof("Obtaining Nuage Metadata for pod %s under namespace %s", podname, ns)
-	var result = new(NuageKubeMonResp)
-	url := vspK8SConfig.NuageK8SMonServer + "/namespaces/" + ns + "/pods"
 
-	// Load client cert
-	cert, err := tls.X509KeyPair([]byte(nuageMonClientCertFile), []byte(nuageMonClientKeyFile))
+	pod := &kubemon.Pod{Name: podname}
+	if podZone != ns {
+		log.Infof("Desired zone %s and network %s set as labels for pod %s", podZone, podNetwork, podname)
+		pod = &kubemon.Pod{Name: podname, Zone: podZone, Subnet: podNetwork}
+	}
+
+	podMetadataCached = false
+	ctx, cancel := context.WithTimeout(context.Background(), KubemonDeadline)
+	defer cancel()
+	result, err := getKubemonClient().GetPodMetadata(ctx, ns, pod)
 	if err != nil {
-		log.Errorf("Error loading client cert file to communicate with Nuage monitor: %v", err)
-		return err
+		log.Errorf("Error occured while obtaining pod metadata from Nuage K8S monitor: %v", err)
+		result = lookupSubnetDecision(ns, pod, err)
+		if result == nil {
+			return err
+		}
+		log.Warnf("Using cached subnet %s for pod %s under namespace %s as Nuage K8S monitor is unreachable", result.Subnet, podname, ns)
+		podMetadataCached = true
+	} else {
+		storeSubnetDecision(ns, pod, result)
 	}
 
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM([]byte(nuageMonClientCACertFile))
+	log.Debugf("Result obtained as a result of passed labels for pod %s: %v", podname, result)
 
-	// Setup HTTPS client
-	tlsConfig := &tls.Config{
-		Certificates:       []tls.Certificate{cert},
-		RootCAs:            caCertPool,
-		InsecureSkipVerify: true,
+	podMonitorPG = ""
+	for _, pg := range result.PG {
+		podMonitorPG = pg
 	}
-	tlsConfig.BuildNameToCertificate()
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
-	client := &http.Client{Transport: transport}
-
-	pod := &Pod{Name: podname}
-	if podZone != ns {
-		log.Infof("Desired zone %s and network %s set as labels for pod %s", podZone, podNetwork, podname)
-		pod = &Pod{Name: podname, Zone: podZone, Subnet: podNetwork}
+	if podPG == "" {
+		log.Debugf("Pod policy group information obtained from Nuage K8S monitor : %s", result.PG)
+		podPG = podMonitorPG
 	}
-	out, err := json.Marshal(pod)
+
+	log.Debugf("Pod subnet information obtained from Nuage K8S monitor : %s", result.Subnet)
+	podNetwork = result.Subnet
+
+	return nil
+}
+
+// GetAlternateSubnet asks Nuage K8S monitor for another subnet in
+// the pod's zone after the exhausted subnets ran out of addresses
+func GetAlternateSubnet(nuageMetadata *client.NuageMetadata, name string, ns string, exhausted []string, orchestrator string) (string, error) {
+
+	log.Infof("Requesting another subnet in zone %s for pod %s as subnets %v are exhausted", nuageMetadata.Zone, name, exhausted)
+
+	err := initNuageConfig(orchestrator)
 	if err != nil {
-		log.Errorf("Error occured while marshalling Pod data to communicate with Nuage K8S monitor: %v", err)
-		return err
+		return "", err
 	}
 
-	var jsonStr = []byte(string(out))
-	resp, err := client.Post(url, "application/json", bytes.NewBuffer(jsonStr))
+	pod := &kubemon.Pod{Name: name, Zone: nuageMetadata.Zone}
+	ctx, cancel := context.WithTimeout(context.Background(), KubemonDeadline)
+	defer cancel()
+	result, err := getKubemonClient().GetAlternatePodMetadata(ctx, ns, pod, exhausted)
 	if err != nil {
-		log.Errorf("Error occured while sending POST call to Nuage K8S monitor to obtain pod metadata: %v", err)
-		return err
+		return "", err
+	}
+
+	for _, subnet := range exhausted {
+		if result.Subnet == subnet {
+			return "", fmt.Errorf("Nuage K8S monitor returned exhausted subnet %s again", subnet)
+		}
 	}
+	if result.Subnet == "" {
+		return "", fmt.Errorf("Nuage K8S monitor has no other subnet in zone %s", nuageMetadata.Zone)
+	}
+
+	return result.Subnet, nil
+}
 
-	log.Debugf("Response sent to Nuage kubemon is %v", bytes.NewBuffer(jsonStr))
+// ReconcilePodMetadata registers a pod that was attached using
+// cached metadata with Nuage K8S monitor. It returns the subnet
+// Nuage K8S monitor now has for the pod
+func ReconcilePodMetadata(state *client.PodState, orchestrator string) (string, error) {
 
-	body, err := ioutil.ReadAll(resp.Body)
+	err := initNuageConfig(orchestrator)
 	if err != nil {
-		log.Errorf("Error occured while reading response obtained from Nuage K8S monitor: %v", err)
-		return err
+		return "", err
 	}
 
-	err = json.Unmarshal(body, &result)
+	pod := &kubemon.Pod{Name: state.PodName, Zone: state.Metadata.Zone, Subnet: state.Metadata.Network}
+	ctx, cancel := context.WithTimeout(context.Background(), KubemonDeadline)
+	defer cancel()
+	result, err := getKubemonClient().GetPodMetadata(ctx, state.PodNamespace, pod)
 	if err != nil {
-		log.Errorf("Error occured while unmarshalling Pod data obtained from Nuage K8S monitor: %v", err)
-		return err
+		return "", err
 	}
 
-	log.Debugf("Response status obtained from Nuage Kubemon for pod %s: %s", podname, resp.Status)
-	log.Debugf("Result obtained as a result of passed labels for pod %s: %v", podname, result)
+	return result.Subnet, nil
+}
 
-	if podPG == "" {
-		log.Debugf("Pod policy group information obtained from Nuage K8S monitor : %s", result.PG)
-		for _, pg := range result.PG {
-			podPG = pg
-		}
-	}
+// getKubemonClient returns the Nuage K8S monitor client for
+// the current Nuage VSP config, reusing it while unchanged
+func getKubemonClient() *kubemon.Client {
 
-	log.Debugf("Pod subnet information obtained from Nuage K8S monitor : %s", result.Subnet)
-	podNetwork = result.Subnet
+	kubemonConfig := kubemon.Config{
+		Server:     vspK8SConfig.NuageK8SMonServer,
+		CertFile:   nuageMonClientCertFile,
+		KeyFile:    nuageMonClientKeyFile,
+		CAFile:     nuageMonClientCACertFile,
+		ServerName: vspK8SConfig.NuageK8SMonServerName,
+	}.WithDefaults()
+	if kubemonClient != nil && kubemonClient.Config() != kubemonConfig {
+		kubemonClient.CloseIdleConnections()
+		kubemonClient = nil
+	}
+	if kubemonClient == nil {
+		kubemonClient = kubemon.NewClient(kubemonConfig)
+	}
 
-	return err
+	return kubemonClient
 }
 
 func initDataDir(orchestrator string) {
 
 	isHostAtomic = VerifyHostType()
 	var dir string
-	if isHostAtomic {
+	if dataDir := os.Getenv(config.DataDirEnv); dataDir != "" {
+		dir = dataDir
+	} else if isHostAtomic {
 		dir = "/var/usr/share/"
 	} else {
 		dir = "/usr/share/"
@@ -218,6 +353,14 @@ func initDataDir(orchestrator string) {
 	}
 }
 
+// VSPConfigFile returns the location of
+// Nuage VSP yaml file on the node
+func VSPConfigFile(orchestrator string) string {
+
+	initDataDir(orchestrator)
+	return vspK8sConfigFile
+}
+
 // VerifyHostType will determine the base host
 // as RHEL server or RHEL atomic
 func VerifyHostType() bool {
@@ -236,25 +379,13 @@ func VerifyHostType() bool {
 // needed for port resolution using CNI plugin
 func GetPodNuageMetadata(nuageMetadata *client.NuageMetadata, name string, ns string, orchestrator string) error {
 
-	initDataDir(orchestrator)
 	log.Infof("Obtaining Nuage Metadata for pod %s under namespace %s", name, ns)
 
 	var err error
 
-	// Parsing Nuage VSP K8S yaml file on K8S agent nodes
-	err = getVSPK8SConfig()
+	err = initNuageConfig(orchestrator)
 	if err != nil {
-		log.Errorf("Error in parsing Nuage k8s yaml file")
-		return fmt.Errorf("Error in parsing Nuage k8s yaml file: %s", err)
-	}
-
-	// Populating certificate and kubeconfig locations
-	// only for k8s as orchestrator
-	if orchestrator == "k8s" {
-		kubeconfFile = vspK8SConfig.KubeConfig
-		nuageMonClientCertFile = vspK8SConfig.NuageK8SMonClientCertFile
-		nuageMonClientKeyFile = vspK8SConfig.NuageK8SMonClientKeyFile
-		nuageMonClientCACertFile = vspK8SConfig.NuageK8SMonCAFile
+		return err
 	}
 
 	// Obtaining pod labels if set from K8S API server
@@ -268,7 +399,12 @@ func GetPodNuageMetadata(nuageMetadata *client.NuageMetadata, name string, ns st
 	err = getPodMetadataFromNuageK8sMon(name, ns)
 	if err != nil {
 		log.Errorf("Error in obtaining pod subnet/policy group from Nuage K8S monitor")
-		return fmt.Errorf("Error in obtaining pod subnet/policy group from Nuage K8S monitor: %s", err)
+		return &SubnetUnavailableError{Err: fmt.Errorf("Error in obtaining pod subnet/policy group from Nuage K8S monitor: %s", err)}
+	}
+
+	if podNetwork == "" {
+		log.Errorf("Nuage K8S monitor did not return a subnet for pod %s under namespace %s", name, ns)
+		return &SubnetUnavailableError{Err: fmt.Errorf("Nuage K8S monitor did not return a subnet for pod %s", name)}
 	}
 
 	nuageMetadata.Enterprise = vspK8SConfig.EnterpriseName
@@ -277,70 +413,37 @@ func GetPodNuageMetadata(nuageMetadata *client.NuageMetadata, name string, ns st
 	nuageMetadata.Network = podNetwork
 	nuageMetadata.User = adminUser
 	nuageMetadata.PolicyGroup = podPG
+	nuageMetadata.MonitorPolicyGroup = podMonitorPG
+	nuageMetadata.PolicyGroupLabeled = podPGLabeled
+	nuageMetadata.RedirectionTarget = podRT
 
 	return err
 }
 
 // SendPodDeletionNotification will notify the Nuage monitor on master nodes
-// about pod deletion
-func SendPodDeletionNotification(podname string, ns string, orchestrator string) error {
-
-	initDataDir(orchestrator)
-	var err error
-
-	log.Infof("Sending delete notification for pod %s under namespace %s", podname, ns)
-
-	// Parsing Nuage config file on agent nodes
-	err = getVSPK8SConfig()
-	if err != nil {
-		log.Errorf("Error in parsing Nuage config file")
-		return fmt.Errorf("Error in parsing Nuage config file: %s", err)
-	}
-
-	// Populating certificate and kubeconfig locations
-	// only for k8s as orchestrator
-	if orchestrator == "k8s" {
-		kubeconfFile = vspK8SConfig.KubeConfig
-		nuageMonClientCertFile = vspK8SConfig.NuageK8SMonClientCertFile
-		nuageMonClientKeyFile = vspK8SConfig.NuageK8SMonClientKeyFile
-		nuageMonClientCACertFile = vspK8SConfig.NuageK8SMonCAFile
-	}
-
-	url := vspK8SConfig.NuageK8SMonServer + "/namespaces/" + ns + "/pods"
-
-	// Load client cert
-	cert, err := tls.X509KeyPair([]byte(nuageMonClientCertFile), []byte(nuageMonClientKeyFile))
-	if err != nil {
-		log.Errorf("Error loading client cert file to communicate with Nuage monitor: %v", err)
-		return err
-	}
+// about deletion of the pod from the zone it was created in
+func SendPodDeletionNotification(pod *kubemon.Pod, orchestrator string) error {
+	return SendPodDeletionNotificationWithin(pod, orchestrator, KubemonDeadline)
+}
 
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM([]byte(nuageMonClientCACertFile))
+// SendPodDeletionNotificationWithin sends the pod deletion notification
+// giving up, retries included, once the timeout expires
+func SendPodDeletionNotificationWithin(pod *kubemon.Pod, orchestrator string, timeout time.Duration) error {
 
-	// Setup HTTPS client
-	tlsConfig := &tls.Config{
-		Certificates:       []tls.Certificate{cert},
-		RootCAs:            caCertPool,
-		InsecureSkipVerify: true,
-	}
-	tlsConfig.BuildNameToCertificate()
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
-	client := &http.Client{Transport: transport}
+	log.Infof("Sending delete notification for pod %s under zone %s, subnet %s and domain %s", pod.Name, pod.Zone, pod.Subnet, pod.Domain)
 
-	pod := &Pod{Name: podname, Action: "delete"}
-	out, err := json.Marshal(pod)
+	err := initNuageConfig(orchestrator)
 	if err != nil {
-		log.Errorf("Error occured while marshalling Pod data to communicate with Nuage monitor: %v", err)
 		return err
 	}
 
-	var jsonStr = []byte(string(out))
-	_, err = client.Post(url, "application/json", bytes.NewBuffer(jsonStr))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err = getKubemonClient().NotifyPodDeletion(ctx, pod.Zone, pod)
 	if err != nil {
 		log.Errorf("Error occured while sending pod deletion notification to Nuage monitor: %v", err)
 		return err
 	}
 
-	return err
+	return nil
 }
@@ -0,0 +1,28 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/nuagenetworks/nuage-cni/config"
+)
+
+func TestGetKubemonClient(t *testing.T) {
+
+	vspK8SConfig = &config.NuageVSPK8SConfig{NuageK8SMonServer: "https://kubemon:9443"}
+	nuageMonClientCertFile, nuageMonClientKeyFile = "client.crt", "client.key"
+	defer func() {
+		vspK8SConfig = &config.NuageVSPK8SConfig{}
+		nuageMonClientCertFile, nuageMonClientKeyFile = "", ""
+		kubemonClient = nil
+	}()
+
+	first := getKubemonClient()
+	if second := getKubemonClient(); second != first {
+		t.Errorf("expected client to be reused while Nuage VSP config is unchanged")
+	}
+
+	vspK8SConfig.NuageK8SMonServer = "https://kubemon2:9443"
+	if third := getKubemonClient(); third == first {
+		t.Errorf("expected a new client after Nuage K8S monitor server changed")
+	}
+}
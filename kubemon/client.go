@@ -0,0 +1,338 @@
+// This module provides a client for Nuage K8S monitor (kubemon)
+// REST server that authenticates using mutual TLS
+
+package kubemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults for requests sent to Nuage K8S monitor
+const (
+	DefaultTimeout      = 10 * time.Second
+	DefaultRetries      = 3
+	DefaultRetryBackoff = 500 * time.Millisecond
+)
+
+// maxErrorBodySize limits how much of an error
+// response body is kept in returned errors
+const maxErrorBodySize = 4096
+
+// Config holds the location of Nuage K8S monitor
+// and the PEM files used to talk to it
+type Config struct {
+	Server     string
+	CertFile   string
+	KeyFile    string
+	CAFile     string
+	ServerName string
+	// Timeout bounds each attempt of a request
+	Timeout time.Duration
+	// Retries is the number of times idempotent
+	// requests are retried on server errors
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// Pod will hold fields necessary to query
+// Nuage kubemon service to obtain pod metadata
+type Pod struct {
+	Name   string `json:"podName"`
+	Zone   string `json:"desiredZone,omitempty"`
+	Subnet string `json:"desiredSubnet,omitempty"`
+	Domain string `json:"domain,omitempty"`
+	Action string `json:"action,omitempty"`
+	// ExhaustedSubnets lists subnets VRS could not
+	// assign an address to the pod from
+	ExhaustedSubnets []string `json:"exhaustedSubnets,omitempty"`
+}
+
+// PodMetadata will unmarshal JSON
+// response from Nuage kubemon service
+type PodMetadata struct {
+	Subnet string   `json:"subnetName"`
+	PG     []string `json:"policyGroups"`
+}
+
+// Client talks to Nuage K8S monitor. Certificates are reloaded
+// whenever their files change so that rotated certificates
+// are picked up without a restart
+type Client struct {
+	config     Config
+	mutex      sync.Mutex
+	httpClient *http.Client
+	transport  *http.Transport
+	modTimes   map[string]time.Time
+}
+
+// WithDefaults returns the configuration with
+// request defaults filled in for unset fields
+func (config Config) WithDefaults() Config {
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Retries == 0 {
+		config.Retries = DefaultRetries
+	}
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = DefaultRetryBackoff
+	}
+
+	return config
+}
+
+// NewClient creates a Nuage K8S monitor client
+func NewClient(config Config) *Client {
+	return &Client{config: config.WithDefaults()}
+}
+
+// Config returns the configuration the client was
+// created with, including the request defaults
+func (c *Client) Config() Config {
+	return c.config
+}
+
+// CloseIdleConnections closes the kept-alive connections
+// of a client that is no longer going to be used
+func (c *Client) CloseIdleConnections() {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.transport != nil {
+		c.transport.CloseIdleConnections()
+	}
+}
+
+// GetPodMetadata obtains subnet and policy groups
+// of the pod under the namespace
+func (c *Client) GetPodMetadata(ctx context.Context, ns string, pod *Pod) (*PodMetadata, error) {
+
+	result := &PodMetadata{}
+	err := c.do(ctx, http.MethodPost, podsPath(ns), pod, result, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetAlternatePodMetadata asks for another subnet of the pod's
+// zone after the exhausted subnets ran out of addresses. Nuage K8S
+// monitor moves the pod to a new subnet, so it is never retried
+func (c *Client) GetAlternatePodMetadata(ctx context.Context, ns string, pod *Pod, exhausted []string) (*PodMetadata, error) {
+
+	request := *pod
+	request.Action = "subnetExhausted"
+	request.ExhaustedSubnets = exhausted
+
+	result := &PodMetadata{}
+	err := c.do(ctx, http.MethodPost, podsPath(ns), &request, result, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// NotifyPodDeletion tells Nuage K8S monitor that the pod was
+// deleted. Zone, subnet and domain of the pod, when set, are
+// the ones its port was actually created in. It releases the pod's
+// address, so it is never retried here; callers queue failed
+// notifications and send them again only while the pod is gone
+func (c *Client) NotifyPodDeletion(ctx context.Context, ns string, pod *Pod) error {
+	notification := *pod
+	notification.Action = "delete"
+	return c.do(ctx, http.MethodPost, podsPath(ns), &notification, nil, false)
+}
+
+func podsPath(ns string) string {
+	return "/namespaces/" + ns + "/pods"
+}
+
+// do sends the request and decodes the response into result.
+// Idempotent requests are retried with backoff on transport
+// and server errors but never on client errors
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, result interface{}, idempotent bool) error {
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("Error marshalling request to Nuage K8S monitor: %v", err)
+	}
+
+	attempts := 1
+	if idempotent {
+		attempts += c.config.Retries
+	}
+
+	backoff := c.config.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err = c.doOnce(ctx, method, path, data, result)
+		if err == nil || !isRetryable(err) || attempt >= attempts {
+			return err
+		}
+
+		log.Warnf("Request %s %s to Nuage K8S monitor failed: %v. Retrying in %s", method, path, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method string, path string, data []byte, result interface{}) error {
+
+	httpClient, err := c.getHTTPClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(method, c.config.Server+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Draining the body lets the connection be reused
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newStatusError(resp)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("Error decoding response from Nuage K8S monitor: %v", err)
+	}
+
+	return nil
+}
+
+// getHTTPClient returns an HTTP client using the current
+// certificates, rebuilding it when certificate files change
+func (c *Client) getHTTPClient() (*http.Client, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	modTimes, err := c.getModTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.httpClient != nil && sameModTimes(c.modTimes, modTimes) {
+		return c.httpClient, nil
+	}
+
+	tlsConfig, err := c.loadTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.transport != nil {
+		log.Infof("Nuage K8S monitor certificates changed; reloading them")
+		c.transport.CloseIdleConnections()
+	}
+	c.transport = &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: c.config.Timeout,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	c.httpClient = &http.Client{Transport: c.transport}
+	c.modTimes = modTimes
+
+	return c.httpClient, nil
+}
+
+// loadTLSConfig reads client certificate, key and CA
+// PEM files and verifies the server against the CA
+func (c *Client) loadTLSConfig() (*tls.Config, error) {
+
+	cert, err := tls.LoadX509KeyPair(c.config.CertFile, c.config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading client cert file to communicate with Nuage monitor: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ServerName:   c.config.ServerName,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.config.CAFile != "" {
+		caCert, err := ioutil.ReadFile(c.config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading Nuage monitor CA file: %v", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("No PEM certificates found in Nuage monitor CA file %s", c.config.CAFile)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	return tlsConfig, nil
+}
+
+func (c *Client) getModTimes() (map[string]time.Time, error) {
+
+	modTimes := make(map[string]time.Time)
+	for _, file := range []string{c.config.CertFile, c.config.KeyFile, c.config.CAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("Error accessing Nuage monitor certificate file: %v", err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	return modTimes, nil
+}
+
+func sameModTimes(old map[string]time.Time, current map[string]time.Time) bool {
+
+	if len(old) != len(current) {
+		return false
+	}
+	for file, modTime := range current {
+		if !old[file].Equal(modTime) {
+			return false
+		}
+	}
+
+	return true
+}
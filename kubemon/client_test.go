@@ -0,0 +1,458 @@
+package kubemon
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testServerName = "kubemon.test"
+
+var testSerial int64
+
+// testCA signs server and client certificates used by the tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(atomic.AddInt64(&testSerial, 1)),
+		Subject:               pkix.Name{CommonName: "kubemon test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns PEM encoded certificate and key signed by the CA
+func (ca *testCA) issue(t *testing.T, commonName string, dnsNames []string, usage x509.ExtKeyUsage) ([]byte, []byte, *big.Int) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := big.NewInt(atomic.AddInt64(&testSerial, 1))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), serial
+}
+
+// testEnv is a mutual TLS kubemon server along with
+// the PEM files a client needs to talk to it
+type testEnv struct {
+	server   *httptest.Server
+	ca       *testCA
+	dir      string
+	certFile string
+	keyFile  string
+	caFile   string
+}
+
+func newTestEnv(t *testing.T, handler http.HandlerFunc) *testEnv {
+
+	dir, err := ioutil.TempDir("", "kubemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &testEnv{
+		ca:       newTestCA(t),
+		dir:      dir,
+		certFile: filepath.Join(dir, "client.crt"),
+		keyFile:  filepath.Join(dir, "client.key"),
+		caFile:   filepath.Join(dir, "ca.crt"),
+	}
+
+	serverCertPEM, serverKeyPEM, _ := env.ca.issue(t, testServerName, []string{testServerName}, x509.ExtKeyUsageServerAuth)
+	serverCert, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(env.ca.cert)
+
+	env.server = httptest.NewUnstartedServer(handler)
+	env.server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	env.server.StartTLS()
+
+	env.writeClientCert(t)
+	env.writeFile(t, env.caFile, env.ca.pem)
+
+	return env
+}
+
+func (env *testEnv) close() {
+	env.server.Close()
+	os.RemoveAll(env.dir)
+}
+
+func (env *testEnv) writeFile(t *testing.T, file string, data []byte) {
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeClientCert issues a new client certificate and returns its serial
+func (env *testEnv) writeClientCert(t *testing.T) *big.Int {
+	certPEM, keyPEM, serial := env.ca.issue(t, "nuage-cni", nil, x509.ExtKeyUsageClientAuth)
+	env.writeFile(t, env.certFile, certPEM)
+	env.writeFile(t, env.keyFile, keyPEM)
+	return serial
+}
+
+func (env *testEnv) config() Config {
+	return Config{
+		Server:       env.server.URL,
+		CertFile:     env.certFile,
+		KeyFile:      env.keyFile,
+		CAFile:       env.caFile,
+		ServerName:   testServerName,
+		Timeout:      time.Second,
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+	}
+}
+
+func TestGetPodMetadata(t *testing.T) {
+
+	env := newTestEnv(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/namespaces/ns1/pods" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		pod := &Pod{}
+		if err := json.NewDecoder(r.Body).Decode(pod); err != nil {
+			t.Errorf("decoding request failed: %v", err)
+		}
+		if pod.Name != "pod1" || pod.Zone != "zone1" || pod.Subnet != "subnet1" {
+			t.Errorf("unexpected pod in request %+v", pod)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"subnetName": "subnet1", "policyGroups": ["pg1"]}`))
+	})
+	defer env.close()
+
+	client := NewClient(env.config())
+	result, err := client.GetPodMetadata(context.Background(), "ns1", &Pod{Name: "pod1", Zone: "zone1", Subnet: "subnet1"})
+	if err != nil {
+		t.Fatalf("obtaining pod metadata failed: %v", err)
+	}
+	if result.Subnet != "subnet1" || len(result.PG) != 1 || result.PG[0] != "pg1" {
+		t.Errorf("unexpected pod metadata %+v", result)
+	}
+}
+
+func TestGetAlternatePodMetadata(t *testing.T) {
+
+	env := newTestEnv(t, func(w http.ResponseWriter, r *http.Request) {
+		pod := &Pod{}
+		if err := json.NewDecoder(r.Body).Decode(pod); err != nil {
+			t.Errorf("decoding request failed: %v", err)
+		}
+		if pod.Name != "pod1" || pod.Zone != "zone1" || pod.Action != "subnetExhausted" ||
+			len(pod.ExhaustedSubnets) != 1 || pod.ExhaustedSubnets[0] != "subnet1" {
+			t.Errorf("unexpected pod in request %+v", pod)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"subnetName": "subnet2"}`))
+	})
+	defer env.close()
+
+	client := NewClient(env.config())
+	result, err := client.GetAlternatePodMetadata(context.Background(), "ns1", &Pod{Name: "pod1", Zone: "zone1"}, []string{"subnet1"})
+	if err != nil {
+		t.Fatalf("obtaining alternate pod metadata failed: %v", err)
+	}
+	if result.Subnet != "subnet2" {
+		t.Errorf("unexpected pod metadata %+v", result)
+	}
+}
+
+func TestStatusHandling(t *testing.T) {
+
+	tests := []struct {
+		name             string
+		responses        []int
+		body             string
+		expectedAttempts int32
+		expectedError    string
+		expectedPayload  *ErrorPayload
+		expectedBody     string
+	}{
+		{
+			name:             "client error with payload is not retried",
+			responses:        []int{http.StatusBadRequest},
+			body:             `{"error": "BadRequest", "message": "unknown zone"}`,
+			expectedAttempts: 1,
+			expectedError:    "client",
+			expectedPayload:  &ErrorPayload{Error: "BadRequest", Message: "unknown zone"},
+		},
+		{
+			name:             "server error with html body is retried",
+			responses:        []int{http.StatusInternalServerError},
+			body:             "<html><body>Internal Server Error</body></html>",
+			expectedAttempts: 3,
+			expectedError:    "server",
+			expectedBody:     "<html><body>Internal Server Error</body></html>",
+		},
+		{
+			name:             "server error followed by success",
+			responses:        []int{http.StatusServiceUnavailable, http.StatusOK},
+			body:             `{"subnetName": "subnet1"}`,
+			expectedAttempts: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var attempts int32
+			env := newTestEnv(t, func(w http.ResponseWriter, r *http.Request) {
+				attempt := atomic.AddInt32(&attempts, 1)
+				status := test.responses[len(test.responses)-1]
+				if int(attempt) <= len(test.responses) {
+					status = test.responses[attempt-1]
+				}
+				w.WriteHeader(status)
+				w.Write([]byte(test.body))
+			})
+			defer env.close()
+
+			client := NewClient(env.config())
+			_, err := client.GetPodMetadata(context.Background(), "ns1", &Pod{Name: "pod1"})
+
+			if atomic.LoadInt32(&attempts) != test.expectedAttempts {
+				t.Errorf("expected %d attempts, got %d", test.expectedAttempts, attempts)
+			}
+
+			var statusError StatusError
+			switch typed := err.(type) {
+			case nil:
+				if test.expectedError != "" {
+					t.Fatalf("expected %s error, got none", test.expectedError)
+				}
+				return
+			case *ClientError:
+				if test.expectedError != "client" {
+					t.Fatalf("expected %s error, got client error %v", test.expectedError, err)
+				}
+				statusError = typed.StatusError
+			case *ServerError:
+				if test.expectedError != "server" {
+					t.Fatalf("expected %s error, got server error %v", test.expectedError, err)
+				}
+				statusError = typed.StatusError
+			default:
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if test.expectedPayload != nil && (statusError.Payload == nil || *statusError.Payload != *test.expectedPayload) {
+				t.Errorf("expected payload %+v, got %+v", test.expectedPayload, statusError.Payload)
+			}
+			if statusError.Body != test.expectedBody {
+				t.Errorf("expected body %q, got %q", test.expectedBody, statusError.Body)
+			}
+		})
+	}
+}
+
+func TestNotifyPodDeletion(t *testing.T) {
+
+	env := newTestEnv(t, func(w http.ResponseWriter, r *http.Request) {
+		pod := &Pod{}
+		if err := json.NewDecoder(r.Body).Decode(pod); err != nil {
+			t.Errorf("decoding request failed: %v", err)
+		}
+		if pod.Name != "pod1" || pod.Action != "delete" || pod.Zone != "zone1" || pod.Subnet != "subnet1" || pod.Domain != "domain1" {
+			t.Errorf("unexpected pod in request %+v", pod)
+		}
+		if r.URL.Path != "/namespaces/zone1/pods" {
+			t.Errorf("unexpected request path %s", r.URL.Path)
+		}
+	})
+	defer env.close()
+
+	client := NewClient(env.config())
+	pod := &Pod{Name: "pod1", Zone: "zone1", Subnet: "subnet1", Domain: "domain1"}
+	if err := client.NotifyPodDeletion(context.Background(), "zone1", pod); err != nil {
+		t.Errorf("sending pod deletion notification failed: %v", err)
+	}
+}
+
+func TestStateChangingRequestsNotRetried(t *testing.T) {
+
+	var attempts int32
+	env := newTestEnv(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer env.close()
+
+	client := NewClient(env.config())
+	requests := map[string]func() error{
+		"pod deletion": func() error {
+			return client.NotifyPodDeletion(context.Background(), "ns1", &Pod{Name: "pod1"})
+		},
+		"subnet exhausted": func() error {
+			_, err := client.GetAlternatePodMetadata(context.Background(), "ns1", &Pod{Name: "pod1"}, []string{"subnet1"})
+			return err
+		},
+	}
+
+	for name, request := range requests {
+		t.Run(name, func(t *testing.T) {
+			atomic.StoreInt32(&attempts, 0)
+			if err := request(); !IsTransient(err) {
+				t.Errorf("expected transient error, got %v", err)
+			}
+			if atomic.LoadInt32(&attempts) != 1 {
+				t.Errorf("expected a single attempt, got %d", attempts)
+			}
+		})
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+
+	var attempts int32
+	env := newTestEnv(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		time.Sleep(200 * time.Millisecond)
+	})
+	defer env.close()
+
+	config := env.config()
+	config.Timeout = 50 * time.Millisecond
+	config.Retries = 1
+	client := NewClient(config)
+
+	_, err := client.GetPodMetadata(context.Background(), "ns1", &Pod{Name: "pod1"})
+	if err == nil {
+		t.Fatalf("expected timeout error")
+	}
+	if atomic.LoadInt32(&attempts) != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestServerVerification(t *testing.T) {
+
+	env := newTestEnv(t, func(w http.ResponseWriter, r *http.Request) {})
+	defer env.close()
+
+	tests := []struct {
+		name       string
+		serverName string
+		otherCA    bool
+		expectErr  bool
+	}{
+		{name: "server name override", serverName: testServerName},
+		{name: "server name mismatch", serverName: "", expectErr: true},
+		{name: "server not signed by CA", serverName: testServerName, otherCA: true, expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := env.config()
+			config.ServerName = test.serverName
+			if test.otherCA {
+				config.CAFile = filepath.Join(env.dir, "other-ca.crt")
+				env.writeFile(t, config.CAFile, newTestCA(t).pem)
+			}
+
+			err := NewClient(config).NotifyPodDeletion(context.Background(), "ns1", &Pod{Name: "pod1"})
+			if test.expectErr && err == nil {
+				t.Errorf("expected server verification to fail")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("expected server verification to succeed: %v", err)
+			}
+		})
+	}
+}
+
+func TestCertificateRotation(t *testing.T) {
+
+	var serial atomic.Value
+	env := newTestEnv(t, func(w http.ResponseWriter, r *http.Request) {
+		serial.Store(r.TLS.PeerCertificates[0].SerialNumber)
+	})
+	defer env.close()
+
+	client := NewClient(env.config())
+	if err := client.NotifyPodDeletion(context.Background(), "ns1", &Pod{Name: "pod1"}); err != nil {
+		t.Fatalf("sending pod deletion notification failed: %v", err)
+	}
+	first := serial.Load().(*big.Int)
+
+	rotated := env.writeClientCert(t)
+	future := time.Now().Add(time.Minute)
+	for _, file := range []string{env.certFile, env.keyFile} {
+		if err := os.Chtimes(file, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := client.NotifyPodDeletion(context.Background(), "ns1", &Pod{Name: "pod1"}); err != nil {
+		t.Fatalf("sending pod deletion notification failed: %v", err)
+	}
+	second := serial.Load().(*big.Int)
+
+	if second.Cmp(rotated) != 0 || second.Cmp(first) == 0 {
+		t.Errorf("expected rotated client certificate %v to be used, got %v", rotated, second)
+	}
+}
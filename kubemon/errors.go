@@ -0,0 +1,109 @@
+package kubemon
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrorPayload is the JSON error body returned by Nuage K8S monitor
+type ErrorPayload struct {
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// StatusError describes a non 2xx response from Nuage K8S monitor.
+// Payload is set when the response body is a JSON error payload,
+// otherwise Body holds the start of the raw response body
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Payload    *ErrorPayload
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+
+	detail := e.Body
+	if e.Payload != nil {
+		detail = e.Payload.Message
+		if detail == "" {
+			detail = e.Payload.Error
+		}
+	}
+	if detail == "" {
+		return fmt.Sprintf("Nuage K8S monitor returned %s", e.Status)
+	}
+	return fmt.Sprintf("Nuage K8S monitor returned %s: %s", e.Status, detail)
+}
+
+// ClientError is returned for 4xx responses. The request
+// was rejected and retrying it will not help
+type ClientError struct {
+	StatusError
+}
+
+// ServerError is returned for 5xx and other unexpected
+// responses. Idempotent requests are retried on it
+type ServerError struct {
+	StatusError
+}
+
+func newStatusError(resp *http.Response) error {
+
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	statusError := StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+
+	payload := &ErrorPayload{}
+	if json.Unmarshal(data, payload) == nil && (payload.Error != "" || payload.Message != "") {
+		statusError.Payload = payload
+	} else {
+		statusError.Body = strings.TrimSpace(string(data))
+	}
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return &ClientError{statusError}
+	}
+	return &ServerError{statusError}
+}
+
+// isRetryable reports whether a request that failed
+// with the error may succeed when sent again
+func isRetryable(err error) bool {
+
+	// Certificate verification fails the same way every time
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) {
+		return false
+	}
+
+	switch err.(type) {
+	case *ServerError:
+		return true
+	case *url.Error:
+		// Transport errors such as refused
+		// connections and attempt timeouts
+		return true
+	}
+
+	return false
+}
+
+// IsTransient reports whether a request failed because Nuage
+// K8S monitor could not be reached or had a server side error,
+// as opposed to the request being rejected
+func IsTransient(err error) bool {
+	return isRetryable(err) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,153 @@
+// This module sets up structured logging for Nuage CNI plugin
+// and audit daemon
+
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nuagenetworks/nuage-cni/config"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Field names attached to every log line emitted
+// while handling a CNI invocation
+const (
+	FieldPID          = "pid"
+	FieldCNICommand   = "cniCommand"
+	FieldContainerID  = "containerID"
+	FieldPodNamespace = "podNamespace"
+	FieldPodName      = "podName"
+	FieldPortName     = "portName"
+)
+
+// Supported log output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var supportedLogLevels = map[string]log.Level{
+	"debug": log.DebugLevel,
+	"info":  log.InfoLevel,
+	"warn":  log.WarnLevel,
+	"error": log.ErrorLevel,
+}
+
+// TextFormatter formats log entries as pipe separated
+// plain text with a per process message counter
+type TextFormatter struct {
+	counter uint64
+}
+
+// Format renders a single log entry
+func (f *TextFormatter) Format(entry *log.Entry) ([]byte, error) {
+	counter := atomic.AddUint64(&f.counter, 1)
+
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var fields strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&fields, "%s=%v ", key, entry.Data[key])
+	}
+
+	return []byte(fmt.Sprintf("|%v|%s|%04d|%s%s\n", entry.Time, strings.ToUpper(entry.Level.String()), counter, fields.String(), entry.Message)), nil
+}
+
+// contextHook adds invocation wide fields to each log entry
+type contextHook struct {
+	sync.RWMutex
+	fields log.Fields
+}
+
+var hook = &contextHook{fields: log.Fields{FieldPID: os.Getpid()}}
+
+func (h *contextHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *contextHook) Fire(entry *log.Entry) error {
+	h.RLock()
+	defer h.RUnlock()
+
+	// Entries created with WithFields share their data map
+	// with the parent entry, so a copy is populated instead
+	data := make(log.Fields, len(entry.Data)+len(h.fields))
+	for key, value := range h.fields {
+		data[key] = value
+	}
+	for key, value := range entry.Data {
+		data[key] = value
+	}
+	entry.Data = data
+
+	return nil
+}
+
+// SetContext adds fields that will be attached to
+// every subsequent log line of this process
+func SetContext(fields log.Fields) {
+	hook.Lock()
+	defer hook.Unlock()
+
+	for key, value := range fields {
+		hook.fields[key] = value
+	}
+}
+
+// ParseLevel returns the log level if it is a supported one
+func ParseLevel(level string) (log.Level, error) {
+	logLevel, ok := supportedLogLevels[strings.ToLower(level)]
+	if !ok {
+		return log.InfoLevel, fmt.Errorf("Unsupported log level %s", level)
+	}
+	return logLevel, nil
+}
+
+// SetLevel applies the log level if it is a supported one
+func SetLevel(level string) error {
+	logLevel, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(logLevel)
+	return nil
+}
+
+// Setup configures format, rotation and level
+// of the logs written to the given log file
+func Setup(conf *config.Config, logfile string) {
+
+	if err := os.MkdirAll(filepath.Dir(logfile), 0777); err != nil {
+		log.Errorf("Error creating log folder: %v", err)
+	}
+
+	if strings.ToLower(conf.LogFormat) == FormatJSON {
+		log.SetFormatter(&log.JSONFormatter{})
+	} else {
+		log.SetFormatter(new(TextFormatter))
+	}
+	log.SetOutput(&lumberjack.Logger{
+		Filename:   logfile,
+		MaxSize:    conf.LogFileSize,
+		MaxAge:     conf.LogFileMaxAge,
+		MaxBackups: conf.LogFileBackups,
+	})
+	log.AddHook(hook)
+
+	if err := SetLevel(conf.LogLevel); err != nil {
+		log.Errorf("%v. Using info level", err)
+		log.SetLevel(log.InfoLevel)
+	}
+}
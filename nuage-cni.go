@@ -10,10 +10,8 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"runtime"
-	"strconv"
 	"strings"
 	"time"
 
@@ -27,22 +25,13 @@ import (
 	"github.com/nuagenetworks/nuage-cni/config"
 	"github.com/nuagenetworks/nuage-cni/daemon"
 	"github.com/nuagenetworks/nuage-cni/k8s"
+	"github.com/nuagenetworks/nuage-cni/kubemon"
+	"github.com/nuagenetworks/nuage-cni/logging"
+	"github.com/nuagenetworks/nuage-cni/outbox"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/natefinch/lumberjack.v2"
-	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
 )
 
-var logMessageCounter int
-
-type logTextFormatter log.TextFormatter
-
-var supportedLogLevels = map[string]log.Level{
-	"debug": log.DebugLevel,
-	"info":  log.InfoLevel,
-	"warn":  log.WarnLevel,
-	"error": log.ErrorLevel,
-}
-
 // nuageCNIConfig will be a pointer variable to
 // Config struct that will hold all Nuage CNI plugin
 // parameters
@@ -50,11 +39,11 @@ var nuageCNIConfig = &config.Config{}
 
 var operMode string
 var orchestrator string
+var subcommand string
+var subcommandArgs []string
 
 // Const definitions for plugin log location and input parameter file
 const (
-	paramFile     = "/etc/default/nuage-cni.yaml"
-	logFolder     = "/var/log/cni/"
 	cniLogFile    = "/var/log/cni/nuage-cni.log"
 	daemonLogFile = "/var/log/cni/nuage-daemon.log"
 	bridgeName    = "alubr0"
@@ -62,6 +51,14 @@ const (
 	openshift     = "ose"
 )
 
+// cniErrPortResolution is the CNI error code
+// returned when port resolution failed
+const cniErrPortResolution = 100
+
+// notificationTimeout bounds how long DEL waits for
+// Nuage monitor before queueing the pod deletion notification
+const notificationTimeout = 5 * time.Second
+
 func init() {
 	// This ensures that main runs only on main thread (thread group leader).
 	// since namespace ops (unshare, setns) are done for a single thread, we
@@ -69,22 +66,10 @@ func init() {
 	runtime.LockOSThread()
 
 	// Reading Nuage CNI plugin parameter file
-	data, err := ioutil.ReadFile(paramFile)
+	var err error
+	nuageCNIConfig, err = config.LoadConfig(config.GetConfigFile())
 	if err != nil {
-		log.Errorf("Error in reading from Nuage CNI plugin parameter file: %s\n", err)
-	}
-
-	if err = yaml.Unmarshal(data, nuageCNIConfig); err != nil {
-		log.Errorf("Error in unmarshalling data from Nuage CNI parameter file: %s\n", err)
-	}
-
-	if _, err = os.Stat(logFolder); err != nil {
-		if os.IsNotExist(err) {
-			err = os.Mkdir(logFolder, 0777)
-			if err != nil {
-				log.Errorf("Error creating log folder: %v", err)
-			}
-		}
+		log.Errorf("%s\n", err)
 	}
 
 	// Use a new flag set so as not to conflict with existing
@@ -94,41 +79,63 @@ func init() {
 	// Determining the mode of operation
 	mode := flagSet.Bool("daemon", false, "a bool")
 
-	err = flagSet.Parse(os.Args[1:])
+	// Admin subcommands are given as first argument
+	// and parse their own flags
+	cmdArgs := os.Args[1:]
+	if len(cmdArgs) > 0 && !strings.HasPrefix(cmdArgs[0], "-") {
+		subcommand = cmdArgs[0]
+		subcommandArgs = cmdArgs[1:]
+		cmdArgs = []string{}
+	}
+
+	err = flagSet.Parse(cmdArgs)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	var logfile string
-	if *mode {
-		operMode = "daemon"
-		logfile = daemonLogFile
-	} else {
-		operMode = "cni"
-		logfile = cniLogFile
-	}
-
 	if nuageCNIConfig.LogLevel == "" {
 		nuageCNIConfig.LogLevel = "info"
 	}
 
+	if nuageCNIConfig.LogFormat == "" {
+		nuageCNIConfig.LogFormat = logging.FormatText
+	}
+
+	if nuageCNIConfig.CNILogFile == "" {
+		nuageCNIConfig.CNILogFile = cniLogFile
+	}
+
+	if nuageCNIConfig.DaemonLogFile == "" {
+		nuageCNIConfig.DaemonLogFile = daemonLogFile
+	}
+
 	if nuageCNIConfig.LogFileSize == 0 {
 		nuageCNIConfig.LogFileSize = 1
 	}
 
-	customFormatter := new(logTextFormatter)
-	log.SetFormatter(customFormatter)
-	log.SetOutput(&lumberjack.Logger{
-		Filename: logfile,
-		MaxSize:  nuageCNIConfig.LogFileSize,
-		MaxAge:   30,
-	})
-	log.SetLevel(supportedLogLevels[strings.ToLower(nuageCNIConfig.LogLevel)])
+	if nuageCNIConfig.LogFileMaxAge == 0 {
+		nuageCNIConfig.LogFileMaxAge = 30
+	}
+
+	var logfile string
+	if *mode {
+		operMode = "daemon"
+		logfile = nuageCNIConfig.DaemonLogFile
+	} else if subcommand != "" {
+		operMode = subcommand
+		logfile = nuageCNIConfig.CNILogFile
+	} else {
+		operMode = "cni"
+		logfile = nuageCNIConfig.CNILogFile
+	}
+
+	logging.Setup(nuageCNIConfig, logfile)
 
 	// Set default values if some values were not set
 	// in Nuage CNI yaml file
 	client.SetDefaultsForNuageCNIConfig(nuageCNIConfig)
+	k8s.ConfigureSubnetCache(nuageCNIConfig)
 
 	// Determine which orchestrator is making the CNI call
 	var arg string = os.Args[0]
@@ -148,32 +155,75 @@ func init() {
 	}
 }
 
-func (f *logTextFormatter) Format(entry *log.Entry) ([]byte, error) {
-	logMessageCounter++
-	return []byte(fmt.Sprintf("|%v|%s|%04d|%s\n", entry.Time, strings.ToUpper(log.Level.String(entry.Level)), logMessageCounter, entry.Message)), nil
+// setLogContext attaches CNI invocation details to
+// every log line written while handling the invocation
+func setLogContext(command string, args *skel.CmdArgs, k8sArgs *client.K8sArgs, portName string) {
+	fields := log.Fields{
+		logging.FieldCNICommand:  command,
+		logging.FieldContainerID: args.ContainerID,
+		logging.FieldPortName:    portName,
+	}
+	if k8sArgs != nil {
+		fields[logging.FieldPodNamespace] = string(k8sArgs.K8S_POD_NAMESPACE)
+		fields[logging.FieldPodName] = string(k8sArgs.K8S_POD_NAME)
+	}
+	logging.SetContext(fields)
 }
 
-func networkConnect(args *skel.CmdArgs) error {
+// recordPodEvent records a K8S event on the pod being attached
+// so that attach results can be seen without node access
+func recordPodEvent(k8sArgs *client.K8sArgs, eventType string, reason string, format string, a ...interface{}) {
+	if k8sArgs == nil {
+		return
+	}
+	k8s.RecordPodEvent(string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_UID),
+		eventType, reason, fmt.Sprintf(format, a...), orchestrator)
+}
 
+// withVRSConnection connects to VRS, retrying until VRS is
+// reachable, and runs the CNI command over the connection
+func withVRSConnection(command func(client.VRSConnection, *skel.CmdArgs) error) func(*skel.CmdArgs) error {
+	return func(args *skel.CmdArgs) error {
+		var vrsConnection client.VRSConnection
+		var err error
+		for {
+			vrsConnection, err = client.ConnectToVRSOVSDB(nuageCNIConfig)
+			if err != nil {
+				log.Errorf("Error connecting to VRS. Will re-try connection")
+			} else {
+				defer vrsConnection.Disconnect()
+				break
+			}
+			time.Sleep(time.Duration(3) * time.Second)
+		}
+		log.Debugf("Successfully established a connection to Nuage VRS")
+
+		return command(vrsConnection, args)
+	}
+}
+
+func networkConnect(vrsConnection client.VRSConnection, args *skel.CmdArgs) error {
+
+	setLogContext("ADD", args, nil, "")
 	log.Infof("Nuage CNI plugin invoked to add an entity to Nuage defined VSD network")
 	var err error
-	var vrsConnection vrsSdk.VRSConnection
 	var result *types.Result
+	var k8sArgs *client.K8sArgs
 	entityInfo := make(map[string]string)
 	// nuageMetadataObj will be a structure pointer
 	// to hold Nuage metadata
 	var nuageMetadataObj = client.NuageMetadata{}
-	for {
-		vrsConnection, err = client.ConnectToVRSOVSDB(nuageCNIConfig)
+
+	if orchestrator == kubernetes || orchestrator == openshift {
+		// Parsing CNI args obtained for K8S/Openshift
+		k8sArgs = &client.K8sArgs{}
+		err = types.LoadArgs(args.Args, k8sArgs)
 		if err != nil {
-			log.Errorf("Error connecting to VRS. Will re-try connection")
-		} else {
-			defer vrsConnection.Disconnect()
-			break
+			log.Errorf("Error in loading k8s CNI arguments")
+			return fmt.Errorf("Error in loading k8s CNI arguments: %s", err)
 		}
-		time.Sleep(time.Duration(3) * time.Second)
+		setLogContext("ADD", args, k8sArgs, client.GetNuagePortName(args.ContainerID))
 	}
-	log.Debugf("Successfully established a connection to Nuage VRS")
 
 	// Here we want to verify if Nuage VSP in good state before we create
 	// OVSDB entries to resolve pods in Nuage overlay networks
@@ -181,6 +231,7 @@ func networkConnect(args *skel.CmdArgs) error {
 		isVSPFunctional := client.IsVSPFunctional(vrsConnection)
 		if !isVSPFunctional && retryCount == 10 {
 			log.Errorf("VRS-VSC connection is not in functional state. Cannot resolve any pods")
+			recordPodEvent(k8sArgs, corev1.EventTypeWarning, k8s.ReasonVSCDisconnected, "VRS on node is not connected to its VSC controller; pod cannot be attached to Nuage network")
 			return fmt.Errorf("VRS-VSC connection is not in functional state. Exiting")
 		}
 
@@ -194,24 +245,25 @@ func networkConnect(args *skel.CmdArgs) error {
 
 	if orchestrator == kubernetes || orchestrator == openshift {
 		log.Debugf("Orchestrator ID is %s", orchestrator)
-		// Parsing CNI args obtained for K8S/Openshift
-		k8sArgs := client.K8sArgs{}
-		err = types.LoadArgs(args.Args, &k8sArgs)
-		if err != nil {
-			log.Errorf("Error in loading k8s CNI arguments")
-			return fmt.Errorf("Error in loading k8s CNI arguments: %s", err)
-		}
-
 		log.Debugf("Infra Container ID for pod %s is %s", string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID))
 
-		entityInfo["name"] = string(k8sArgs.K8S_POD_NAME)
+		entityInfo["name"] = client.GetEntityName(string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME))
 		entityInfo["entityport"] = args.IfName
 		entityInfo["brport"] = client.GetNuagePortName(args.ContainerID)
 		err := k8s.GetPodNuageMetadata(&nuageMetadataObj, string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), orchestrator)
 		if err != nil {
 			log.Errorf("Error obtaining Nuage metadata")
+			if _, ok := err.(*k8s.SubnetUnavailableError); ok {
+				recordPodEvent(k8sArgs, corev1.EventTypeWarning, k8s.ReasonSubnetUnavailable, "Nuage monitor did not provide a subnet: %v", err)
+			} else {
+				recordPodEvent(k8sArgs, corev1.EventTypeWarning, k8s.ReasonMetadataMissing, "Unable to obtain Nuage metadata: %v", err)
+			}
 			return fmt.Errorf("Error obtaining Nuage metadata: %s", err)
 		}
+		if k8s.IsPodMetadataCached() {
+			recordPodEvent(k8sArgs, corev1.EventTypeWarning, k8s.ReasonSubnetFromCache, "Nuage monitor unreachable; using cached subnet %s of zone %s until it is reconciled",
+				nuageMetadataObj.Network, nuageMetadataObj.Zone)
+		}
 		entityInfo["uuid"] = string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID)
 		log.Infof("Nuage metadata obtained for pod %s is Enterprise: %s, Domain: %s, Zone: %s, Network: %s and User:%s", string(k8sArgs.K8S_POD_NAME), nuageMetadataObj.Enterprise, nuageMetadataObj.Domain, nuageMetadataObj.Zone, nuageMetadataObj.Network, nuageMetadataObj.User)
 	} else {
@@ -222,6 +274,7 @@ func networkConnect(args *skel.CmdArgs) error {
 		entityInfo["uuid"] = formattedContainerUUID
 		entityInfo["entityport"] = args.IfName
 		entityInfo["brport"] = client.GetNuagePortName(entityInfo["uuid"])
+		setLogContext("ADD", args, nil, entityInfo["brport"])
 		err = client.GetContainerNuageMetadata(&nuageMetadataObj, args)
 		if err != nil {
 			log.Errorf("Error obtaining Nuage metadata")
@@ -232,6 +285,8 @@ func networkConnect(args *skel.CmdArgs) error {
 	// Verifying all required Nuage metadata present before proceeding
 	if (nuageMetadataObj.Enterprise == "") || (nuageMetadataObj.Domain == "") || (nuageMetadataObj.Zone == "") || (nuageMetadataObj.Network == "") || (nuageMetadataObj.User == "") {
 		log.Errorf("Required Nuage metadata not available for port resolution")
+		recordPodEvent(k8sArgs, corev1.EventTypeWarning, k8s.ReasonMetadataMissing, "Required Nuage metadata not available for port resolution (enterprise %q, domain %q, zone %q, subnet %q, user %q)",
+			nuageMetadataObj.Enterprise, nuageMetadataObj.Domain, nuageMetadataObj.Zone, nuageMetadataObj.Network, nuageMetadataObj.User)
 		return fmt.Errorf("Required Nuage metadata not available for port resolution")
 	}
 
@@ -250,17 +305,27 @@ func networkConnect(args *skel.CmdArgs) error {
 	}
 	log.Debugf("Successfully created a veth paired port for entity %s", entityInfo["name"])
 
-	var info vrsSdk.EntityInfo
-	info.Name = entityInfo["name"]
-	info.UUID = entityInfo["uuid"]
-	err = vrsConnection.AddPortToAlubr0(entityInfo["brport"], info)
-	if err != nil {
-		log.Errorf("Error adding bridge veth end %s of entity %s to alubr0", entityInfo["brport"], entityInfo["name"])
-		// Cleaning up veth ports from VRS
-		_ = client.DeleteVethPair(entityInfo["brport"], entityInfo["entityport"])
-		return fmt.Errorf("Failed to add bridge veth port to alubr0")
+	// Recording pod state on the node so that the audit daemon
+	// knows the veth belongs to a sandbox being set up
+	podState := &client.PodState{
+		ContainerID: args.ContainerID,
+		EntityName:  entityInfo["name"],
+		EntityUUID:  entityInfo["uuid"],
+		Netns:       netns,
+		IfName:      entityInfo["entityport"],
+		PortName:    entityInfo["brport"],
+		MAC:         contVethMAC,
+		Metadata:    nuageMetadataObj,
+	}
+	if k8sArgs != nil {
+		podState.PodNamespace = string(k8sArgs.K8S_POD_NAMESPACE)
+		podState.PodName = string(k8sArgs.K8S_POD_NAME)
+		podState.PodUID = string(k8sArgs.K8S_POD_UID)
+		podState.MetadataCached = k8s.IsPodMetadataCached()
+	}
+	if err = client.SavePodState(nuageCNIConfig.StateDir, podState); err != nil {
+		log.Warnf("Error recording pod state for entity %s: %v", entityInfo["name"], err)
 	}
-	log.Debugf("Attached veth interface %s to bridge %s for entity %s", entityInfo["brport"], bridgeName, entityInfo["name"])
 
 	// Create Port Attributes
 	portAttributes := port.Attributes{
@@ -269,106 +334,70 @@ func networkConnect(args *skel.CmdArgs) error {
 		Bridge:   bridgeName,
 	}
 
-	// Create Port Metadata for entity
-	portMetadata := make(map[port.MetadataKey]string)
-	portMetadata[port.MetadataKeyDomain] = nuageMetadataObj.Domain
-	portMetadata[port.MetadataKeyNetwork] = nuageMetadataObj.Network
-	portMetadata[port.MetadataKeyZone] = nuageMetadataObj.Zone
-	portMetadata[port.MetadataKeyNetworkType] = "ipv4"
-
-	// Handling static IP scenario
-	if nuageMetadataObj.StaticIP != "" {
-		portMetadata[port.MetadataKeyStaticIP] = nuageMetadataObj.StaticIP
-	}
-
-	// Handling policy group assignment scenario
-	if nuageMetadataObj.PolicyGroup != "" {
-		portMetadata[port.MetadataNuagePolicyGroup] = nuageMetadataObj.PolicyGroup
-	}
-
-	// Handling redirection target scenario
-	if nuageMetadataObj.RedirectionTarget != "" {
-		portMetadata[port.MetadataKeyNuageRedirectionTarget] = nuageMetadataObj.RedirectionTarget
+	err = client.AttachEntityPort(vrsConnection, entityInfo, portAttributes, nuageMetadataObj, nuageCNIConfig.NuageSiteID)
+	if err != nil {
+		return err
 	}
 
-	// Create an entry for entity in Nuage Port Table
-	err = vrsConnection.CreatePort(entityInfo["brport"], portAttributes, portMetadata)
+	// Registering for VRS port updates
+	portInfoUpdateChan := make(chan *vrsSdk.PortIPv4Info)
+	err = vrsConnection.RegisterForPortUpdates(entityInfo["brport"], portInfoUpdateChan)
 	if err != nil {
-		log.Errorf("Error creating entity port for entity %s in Nuage Port table", entityInfo["name"])
-		_ = client.DeleteVethPair(entityInfo["brport"], entityInfo["entityport"])
-		_ = vrsConnection.RemovePortFromAlubr0(entityInfo["brport"])
-		return fmt.Errorf("Unable to create entity port %v", err)
-	}
-	log.Debugf("Successfully created a port for entity %s in Nuage Port table", entityInfo["name"])
-
-	entityExists, _ := vrsConnection.CheckEntityExists(entityInfo["uuid"])
-	if !entityExists {
-		// Populate entity metadata
-		entityMetadata := make(map[entity.MetadataKey]string)
-		entityMetadata[entity.MetadataKeyUser] = nuageMetadataObj.User
-		entityMetadata[entity.MetadataKeyEnterprise] = nuageMetadataObj.Enterprise
-		if nuageCNIConfig.NuageSiteID != -1 {
-			entityMetadata[entity.MetadataKeySiteID] = strconv.Itoa(nuageCNIConfig.NuageSiteID)
+		log.Errorf("Failed to register for updates from VRS for entity port %s", entityInfo["brport"])
+		return fmt.Errorf("Failed to register for updates from VRS %v", err)
+	}
+	resolveStart := time.Now()
+	var portInfo *vrsSdk.PortIPv4Info
+	var attempts []string
+	var exhausted []string
+	for {
+		portInfo, err = client.WaitForPortResolution(vrsConnection, entityInfo["brport"], portInfoUpdateChan, time.Duration(nuageCNIConfig.PortResolveTimer)*time.Second)
+		if err == nil {
+			log.Debugf("Received an update from VRS for entity port %s", entityInfo["brport"])
+			break
 		}
 
-		// Define ports associated with the entity
-		ports := []string{entityInfo["brport"]}
-
-		// Add entity to VRS
-		entityInfoVRS := vrsSdk.EntityInfo{
-			UUID:     entityInfo["uuid"],
-			Name:     entityInfo["name"],
-			Domain:   entity.Docker,
-			Type:     entity.Container,
-			Ports:    ports,
-			Metadata: entityMetadata,
+		log.Errorf("Port %s was not resolved in subnet %s: %v", entityInfo["brport"], nuageMetadataObj.Network, err)
+		attempts = append(attempts, fmt.Sprintf("attempt %d in subnet %s of zone %s: %v", len(attempts)+1, nuageMetadataObj.Network, nuageMetadataObj.Zone, err))
+		if err == client.ErrPortResolutionTimeout {
+			recordPodEvent(k8sArgs, corev1.EventTypeWarning, k8s.ReasonPortResolveTimeout, "Port %s was not resolved by VRS within %d seconds in subnet %s of zone %s",
+				entityInfo["brport"], nuageCNIConfig.PortResolveTimer, nuageMetadataObj.Network, nuageMetadataObj.Zone)
+		} else {
+			recordPodEvent(k8sArgs, corev1.EventTypeWarning, k8s.ReasonSubnetExhausted, "Port %s could not be assigned an address in subnet %s of zone %s",
+				entityInfo["brport"], nuageMetadataObj.Network, nuageMetadataObj.Zone)
 		}
 
-		// Sending proper events for container activation
-		// as these are different for each entity type in VRS
-		events := &entity.EntityEvents{}
-		events.EntityEventCategory = entity.EventCategoryStarted
-		events.EntityEventType = entity.EventStartedBooted
-		events.EntityState = entity.Running
-		events.EntityReason = entity.RunningBooted
-		entityInfoVRS.Events = events
+		if k8sArgs == nil || len(exhausted) >= nuageCNIConfig.SubnetExhaustionRetries {
+			return portResolutionError(entityInfo["brport"], attempts)
+		}
 
-		err = vrsConnection.CreateEntity(entityInfoVRS)
+		// Moving the port to another subnet of the zone
+		exhausted = append(exhausted, nuageMetadataObj.Network)
+		subnet, err := k8s.GetAlternateSubnet(&nuageMetadataObj, string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), exhausted, orchestrator)
 		if err != nil {
-			log.Errorf("Error creating an entry in Nuage entity table for entity %s", entityInfo["name"])
-			return fmt.Errorf("Unable to add entity to VRS %v", err)
+			attempts = append(attempts, fmt.Sprintf("requesting another subnet: %v", err))
+			return portResolutionError(entityInfo["brport"], attempts)
 		}
-		log.Debugf("Successfully created an entity in Nuage entity table for entity %s", entityInfo["name"])
-	} else {
-		portList, err := vrsConnection.GetEntityPorts(entityInfo["uuid"])
+		nuageMetadataObj.Network = subnet
+
+		err = client.RecreatePort(vrsConnection, entityInfo["brport"], portAttributes, nuageMetadataObj, portInfoUpdateChan)
 		if err != nil {
-			log.Errorf("Error obtaining alubr0 port for entity %s", entityInfo["name"])
-			return fmt.Errorf("Unable to obtain alubr0 port for entity %v", err)
+			attempts = append(attempts, fmt.Sprintf("moving port to subnet %s: %v", subnet, err))
+			return portResolutionError(entityInfo["brport"], attempts)
 		}
-		if len(portList) > 0 {
-			entityInfo["brport"] = portList[0]
-			log.Infof("Using existing alubr0 port %s for entity %s", entityInfo["brport"], entityInfo["name"])
-		} else {
-			log.Errorf("Error configuring alubr0 port for an existing VRS entity %s", entityInfo["name"])
-			return fmt.Errorf("Error configuring alubr0 port for an existing VRS entity %v", err)
+		log.Infof("Moved port %s to subnet %s of zone %s", entityInfo["brport"], subnet, nuageMetadataObj.Zone)
+
+		podState.Metadata = nuageMetadataObj
+		if err = client.SavePodState(nuageCNIConfig.StateDir, podState); err != nil {
+			log.Warnf("Error recording pod state for entity %s: %v", entityInfo["name"], err)
 		}
 	}
 
-	// Registering for VRS port updates
-	portInfoUpdateChan := make(chan *vrsSdk.PortIPv4Info)
-	err = vrsConnection.RegisterForPortUpdates(entityInfo["brport"], portInfoUpdateChan)
-	if err != nil {
-		log.Errorf("Failed to register for updates from VRS for entity port %s", entityInfo["brport"])
-		return fmt.Errorf("Failed to register for updates from VRS %v", err)
-	}
-	ticker := time.NewTicker(time.Duration(nuageCNIConfig.PortResolveTimer) * time.Second)
-	var portInfo = &vrsSdk.PortIPv4Info{}
-	select {
-	case portInfo = <-portInfoUpdateChan:
-		log.Debugf("Received an update from VRS for entity port %s", entityInfo["brport"])
-	case <-ticker.C:
-		log.Errorf("Failed to receive an update from VRS for entity port %s", entityInfo["brport"])
-		return fmt.Errorf("Failed to receive an IP address from Nuage CNI plugin%v", err)
+	// Flagging port resolutions that took more than
+	// half of the allowed time
+	resolveTime := time.Since(resolveStart)
+	if resolveTime > time.Duration(nuageCNIConfig.PortResolveTimer)*time.Second/2 {
+		recordPodEvent(k8sArgs, corev1.EventTypeWarning, k8s.ReasonPortResolveSlow, "Port %s took %s to be resolved by VRS", entityInfo["brport"], resolveTime.Round(time.Second))
 	}
 
 	// Configuring entity end veth with IP
@@ -384,6 +413,16 @@ func networkConnect(args *skel.CmdArgs) error {
 
 	log.Infof("Successfully configured entity %s with an IP address %s", entityInfo["name"], entityInfo["ip"])
 
+	podState.PortName = entityInfo["brport"]
+	podState.IP = entityInfo["ip"]
+	podState.Gateway = entityInfo["gw"]
+	podState.Mask = entityInfo["mask"]
+	if err = client.SavePodState(nuageCNIConfig.StateDir, podState); err != nil {
+		log.Warnf("Error recording pod state for entity %s: %v", entityInfo["name"], err)
+	}
+	recordPodEvent(k8sArgs, corev1.EventTypeNormal, k8s.ReasonNetworkAttached, "Assigned IP %s/%s in Nuage subnet %s of zone %s",
+		entityInfo["ip"], entityInfo["mask"], nuageMetadataObj.Network, nuageMetadataObj.Zone)
+
 	// De-registering for VRS port updates
 	err = vrsConnection.DeregisterForPortUpdates(entityInfo["brport"])
 	if err != nil {
@@ -393,11 +432,28 @@ func networkConnect(args *skel.CmdArgs) error {
 	return result.Print()
 }
 
-func networkDisconnect(args *skel.CmdArgs) error {
+// portResolutionError returns a CNI error listing
+// every attempt made to resolve the port
+func portResolutionError(portName string, attempts []string) error {
+	return &types.Error{
+		Code:    cniErrPortResolution,
+		Msg:     fmt.Sprintf("Failed to receive an IP address from Nuage VRS for port %s", portName),
+		Details: strings.Join(attempts, "; "),
+	}
+}
+
+func networkDisconnect(vrsConnection client.VRSConnection, args *skel.CmdArgs) error {
+	return detachEntity(vrsConnection, args, false)
+}
 
+// detachEntity cleans up VRS entries, veth and state record of a
+// sandbox. Unless forced, VRS entries are only removed when the
+// entity and its port are both present in VRS tables
+func detachEntity(vrsConnection client.VRSConnection, args *skel.CmdArgs, force bool) error {
+
+	setLogContext("DEL", args, nil, "")
 	log.Infof("Nuage CNI plugin invoked to detach an entity from a Nuage defined VSD network")
 	var err error
-	var vrsConnection vrsSdk.VRSConnection
 	var portName string
 	entityInfo := make(map[string]string)
 
@@ -411,74 +467,112 @@ func networkDisconnect(args *skel.CmdArgs) error {
 			return fmt.Errorf("Error in loading k8s CNI arguments: %s", err)
 		}
 
-		entityInfo["name"] = string(k8sArgs.K8S_POD_NAME)
+		entityInfo["name"] = client.GetEntityName(string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME))
+		entityInfo["podname"] = string(k8sArgs.K8S_POD_NAME)
 		entityInfo["uuid"] = string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID)
 		entityInfo["entityport"] = args.IfName
-		entityInfo["zone"] = string(k8sArgs.K8S_POD_NAMESPACE)
+		entityInfo["namespace"] = string(k8sArgs.K8S_POD_NAMESPACE)
+		entityInfo["poduid"] = string(k8sArgs.K8S_POD_UID)
 		// Determining the Nuage host port name to be deleted from OVSDB table
 		portName = client.GetNuagePortName(args.ContainerID)
+		setLogContext("DEL", args, &k8sArgs, portName)
 	} else {
 		entityInfo["name"] = args.ContainerID
+		entityInfo["podname"] = args.ContainerID
 		newContainerUUID := strings.Replace(args.ContainerID, "-", "", -1)
 		formattedContainerUUID := newContainerUUID + newContainerUUID
 		entityInfo["uuid"] = formattedContainerUUID
 		entityInfo["entityport"] = args.IfName
 		// Determining the Nuage host port name to be deleted from OVSDB table
 		portName = client.GetNuagePortName(entityInfo["uuid"])
+		setLogContext("DEL", args, nil, portName)
 	}
 
 	log.Infof("Detaching entity %s from Nuage defined network", entityInfo["name"])
 
-	for {
-		vrsConnection, err = client.ConnectToVRSOVSDB(nuageCNIConfig)
-		if err != nil {
-			log.Errorf("Error connecting to VRS. Will re-try connection")
-		} else {
-			defer vrsConnection.Disconnect()
-			break
-		}
-		time.Sleep(time.Duration(3) * time.Second)
-	}
-	log.Debugf("Successfully established a connection to Nuage VRS")
-
 	// Obtaining all ports associated with this entity
 	portList, _ := vrsConnection.GetEntityPorts(entityInfo["uuid"])
 
 	// Delete VRS OVSDB entries only if the ports for the entity
 	// exist in VRS tables
-	if len(portList) == 1 {
+	if len(portList) == 1 || force {
 
-		err = k8s.SendPodDeletionNotification(entityInfo["name"], entityInfo["zone"], orchestrator)
-		if err != nil {
-			log.Errorf("Error occured while sending delete notification for pod %s: %v", entityInfo["name"], err)
-			return err
-		}
+		notifyPodDeletion(getDeletedPod(vrsConnection, portName, args.ContainerID, entityInfo), entityInfo)
 
-		err = vrsConnection.DestroyEntity(entityInfo["uuid"])
-		if err != nil {
-			log.Errorf("Failed to remove entity from Nuage entity Table for entity %s: %v", entityInfo["name"], err)
-		}
+		_ = client.DetachEntityPort(vrsConnection, entityInfo, portName)
+	}
 
-		// Performing cleanup of port/entity on VRS
-		err = vrsConnection.DestroyPort(portName)
-		if err != nil {
-			log.Errorf("Failed to delete entity port from Nuage Port table for entity %s: %v", entityInfo["name"], err)
-		}
+	err = client.DeletePodState(nuageCNIConfig.StateDir, args.ContainerID)
+	if err != nil {
+		log.Warnf("Failed to remove pod state for entity %s: %v", entityInfo["name"], err)
+	}
 
-		// Purging out the veth port from VRS alubr0
-		err = vrsConnection.RemovePortFromAlubr0(portName)
-		if err != nil {
-			log.Errorf("Failed to remove veth port %s for entity %s from alubr0: %v", portName, entityInfo["name"], err)
-		}
+	return nil
+}
 
-		// Cleaning up veth paired ports from VRS
-		err = client.DeleteVethPair(portName, entityInfo["entityport"])
-		if err != nil {
-			log.Errorf("Failed to clear veth ports from VRS for entity %s: %v", entityInfo["name"], err)
+// getDeletedPod determines the zone, subnet and domain the pod's
+// port was created in. These come from VRS port state or, if VRS
+// has not resolved the port, from the pod state record. The pod
+// namespace is used as zone when neither is available
+func getDeletedPod(vrsConnection client.VRSConnection, portName string, containerID string, entityInfo map[string]string) *kubemon.Pod {
+
+	var nuageMetadata client.NuageMetadata
+	portState, err := vrsConnection.GetPortState(portName)
+	if err != nil {
+		log.Warnf("Unable to obtain port state of %s from VRS: %v", portName, err)
+	} else {
+		nuageMetadata = client.GetPortStateMetadata(portState)
+	}
+
+	if nuageMetadata.Zone == "" {
+		state, err := client.LoadPodState(nuageCNIConfig.StateDir, containerID)
+		if err == nil {
+			nuageMetadata = state.Metadata
 		}
 	}
 
-	return nil
+	if nuageMetadata.Zone == "" {
+		log.Warnf("Zone of port %s not known; using pod namespace %s", portName, entityInfo["namespace"])
+		nuageMetadata.Zone = entityInfo["namespace"]
+	}
+
+	return &kubemon.Pod{
+		Name:   entityInfo["podname"],
+		Zone:   nuageMetadata.Zone,
+		Subnet: nuageMetadata.Network,
+		Domain: nuageMetadata.Domain,
+	}
+}
+
+// notifyPodDeletion notifies Nuage monitor about the pod deletion.
+// Notifications that cannot be sent right away because Nuage monitor
+// is unreachable are queued in the outbox and retried by the audit
+// daemon so that DEL never fails on it. Rejected ones are dropped
+func notifyPodDeletion(pod *kubemon.Pod, entityInfo map[string]string) {
+
+	err := k8s.SendPodDeletionNotificationWithin(pod, orchestrator, notificationTimeout)
+	if err == nil {
+		return
+	}
+	if !kubemon.IsTransient(err) {
+		log.Errorf("Error occured while sending delete notification for pod %s: %v. Not retrying it", pod.Name, err)
+		return
+	}
+
+	log.Errorf("Error occured while sending delete notification for pod %s: %v. Queueing it for retry", pod.Name, err)
+	n := &outbox.Notification{
+		PodName:      pod.Name,
+		PodNamespace: entityInfo["namespace"],
+		PodUID:       entityInfo["poduid"],
+		Zone:         pod.Zone,
+		Subnet:       pod.Subnet,
+		Domain:       pod.Domain,
+		LastError:    err.Error(),
+	}
+	err = outbox.Add(nuageCNIConfig.OutboxDir, n)
+	if err != nil {
+		log.Errorf("Unable to queue delete notification for pod %s: %v", pod.Name, err)
+	}
 }
 
 func main() {
@@ -492,13 +586,82 @@ func main() {
 	}
 
 	var err error
-	if operMode == "daemon" {
+	switch operMode {
+	case "daemon":
 		log.Infof("Starting Nuage CNI audit daemon on agent nodes")
 		err = daemon.MonitorAgent(nuageCNIConfig, orchestrator)
 		if err != nil {
 			log.Errorf("Error encountered while running Nuage CNI daemon: %s\n", err)
 		}
-	} else {
-		skel.PluginMain(networkConnect, networkDisconnect, version.PluginSupports("0.2.0", "0.3.0"))
+	case "cni":
+		skel.PluginMain(withVRSConnection(networkConnect), withVRSConnection(networkDisconnect), version.PluginSupports("0.2.0", "0.3.0"))
+	default:
+		err = runSubcommand(operMode, subcommandArgs)
+		if err != nil {
+			log.Errorf("Error encountered while running %s command: %s", operMode, err)
+			fmt.Fprintf(os.Stderr, "%s: %s\n", operMode, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// runSubcommand runs one of the admin subcommands
+// of Nuage CNI plugin binary
+func runSubcommand(name string, args []string) error {
+
+	flagSet := flag.NewFlagSet(name, flag.ExitOnError)
+	switch name {
+	case "audit":
+		dryRun := flagSet.Bool("dry-run", true, "only report stale VRS entries without deleting them")
+		report := flagSet.String("report", "-", "file to write the JSON audit report to, - for stdout")
+		if err := flagSet.Parse(args); err != nil {
+			return err
+		}
+		return daemon.RunAudit(nuageCNIConfig, orchestrator, *dryRun, *report)
+	case "inspect":
+		format := flagSet.String("o", daemon.InspectFormatTable, "output format, table or json")
+		if err := flagSet.Parse(args); err != nil {
+			return err
+		}
+		return daemon.Inspect(nuageCNIConfig, orchestrator, *format, os.Stdout)
+	case "force-detach", "re-resolve":
+		if err := flagSet.Parse(args); err != nil {
+			return err
+		}
+		if flagSet.NArg() != 1 {
+			if name == "re-resolve" {
+				return fmt.Errorf("usage: %s <namespace>/<pod> | <container ID>; the port may get a new IP, in which case the pod must be restarted", name)
+			}
+			return fmt.Errorf("usage: %s <namespace>/<pod> | <container ID>", name)
+		}
+		if name == "force-detach" {
+			return forceDetachPod(flagSet.Arg(0))
+		}
+		return reResolvePod(flagSet.Arg(0))
+	case "support-bundle":
+		file := flagSet.String("o", "", "tarball to write, nuage-cni-support-<node>-<time>.tar.gz by default")
+		if err := flagSet.Parse(args); err != nil {
+			return err
+		}
+		return daemon.WriteSupportBundle(nuageCNIConfig, orchestrator, *file, os.Stdout)
+	case "outbox":
+		action := "list"
+		if len(args) > 0 {
+			action, args = args[0], args[1:]
+		}
+		drop := flagSet.Bool("drop", false, "discard queued notifications instead of sending them")
+		if err := flagSet.Parse(args); err != nil {
+			return err
+		}
+		switch action {
+		case "list":
+			return daemon.ListOutbox(nuageCNIConfig, os.Stdout)
+		case "flush":
+			return daemon.FlushOutbox(nuageCNIConfig, orchestrator, *drop)
+		default:
+			return fmt.Errorf("unknown outbox command %q; use list or flush", action)
+		}
+	default:
+		return fmt.Errorf("unknown command %q", name)
 	}
 }
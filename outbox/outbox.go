@@ -0,0 +1,200 @@
+// This module implements a durable on-node outbox for pod
+// deletion notifications that could not be delivered to
+// Nuage K8S monitor. CNI DEL queues notifications and the
+// audit daemon keeps retrying them until they are sent
+
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backoff between delivery attempts of a notification
+const (
+	InitialBackoff = 10 * time.Second
+	MaxBackoff     = 10 * time.Minute
+)
+
+// ErrSuperseded is returned by a sender when a notification must
+// be dropped, e.g. because a new pod with the same name exists
+var ErrSuperseded = errors.New("notification superseded")
+
+// ErrRejected is returned by a sender when Nuage K8S monitor
+// rejected a notification and sending it again will not help
+var ErrRejected = errors.New("notification rejected")
+
+// ErrDeferred is returned by a sender to end the drain, leaving
+// the notification and the ones after it for a later drain
+var ErrDeferred = errors.New("notification deferred")
+
+// Notification is a pod deletion notification
+// waiting to be sent to Nuage K8S monitor
+type Notification struct {
+	ID           string    `json:"id"`
+	PodName      string    `json:"podName"`
+	PodNamespace string    `json:"podNamespace"`
+	PodUID       string    `json:"podUID,omitempty"`
+	Zone         string    `json:"zone"`
+	Subnet       string    `json:"subnet,omitempty"`
+	Domain       string    `json:"domain,omitempty"`
+	Created      time.Time `json:"created"`
+	Attempts     int       `json:"attempts"`
+	LastAttempt  time.Time `json:"lastAttempt,omitempty"`
+	NextAttempt  time.Time `json:"nextAttempt"`
+	LastError    string    `json:"lastError,omitempty"`
+}
+
+// Sender delivers a notification to Nuage K8S monitor
+type Sender func(n *Notification) error
+
+func notificationFile(dir string, id string) string {
+	return filepath.Join(dir, id+".json")
+}
+
+// Add queues a notification in the outbox directory
+func Add(dir string, n *Notification) error {
+
+	if n.Created.IsZero() {
+		n.Created = time.Now()
+	}
+	if n.NextAttempt.IsZero() {
+		n.NextAttempt = n.Created
+	}
+	if n.ID == "" {
+		n.ID = fmt.Sprintf("%d-%s-%s", n.Created.UnixNano(), n.PodNamespace, n.PodName)
+	}
+
+	return save(dir, n)
+}
+
+// save writes the notification to the outbox directory
+func save(dir string, n *Notification) error {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("Error creating outbox folder: %v", err)
+	}
+
+	data, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling outbox notification: %v", err)
+	}
+
+	// Write to a temporary file first so that a crash
+	// never leaves a partially written notification
+	file := notificationFile(dir, n.ID)
+	if err = ioutil.WriteFile(file+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("Error writing outbox notification: %v", err)
+	}
+
+	return os.Rename(file+".tmp", file)
+}
+
+// Remove deletes a notification from the outbox directory
+func Remove(dir string, id string) error {
+
+	err := os.Remove(notificationFile(dir, id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// List returns the queued notifications, oldest first
+func List(dir string) ([]*Notification, error) {
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Notification{}, nil
+		}
+		return nil, err
+	}
+
+	notifications := []*Notification{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+		n := &Notification{}
+		if err = json.Unmarshal(data, n); err != nil {
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].Created.Before(notifications[j].Created)
+	})
+
+	return notifications, nil
+}
+
+// Drain sends queued notifications that are due, or all of them
+// if force is set. Sent, superseded and rejected notifications
+// are removed, failed ones are kept with an increased backoff and
+// deferred ones end the drain without counting as an attempt.
+// Drain returns the number of notifications removed from the outbox
+func Drain(dir string, force bool, send Sender) (int, error) {
+
+	notifications, err := List(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	now := time.Now()
+	for _, n := range notifications {
+		if !force && now.Before(n.NextAttempt) {
+			continue
+		}
+
+		err = send(n)
+		if err == ErrDeferred {
+			break
+		}
+		if err == nil || err == ErrSuperseded || err == ErrRejected {
+			if err = Remove(dir, n.ID); err != nil {
+				return removed, err
+			}
+			removed++
+			continue
+		}
+
+		n.Attempts++
+		n.LastAttempt = now
+		n.LastError = err.Error()
+		n.NextAttempt = now.Add(backoff(n.Attempts))
+		if err = save(dir, n); err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// backoff doubles the wait after each failed attempt
+func backoff(attempts int) time.Duration {
+
+	wait := InitialBackoff
+	for i := 1; i < attempts && wait < MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > MaxBackoff {
+		wait = MaxBackoff
+	}
+
+	return wait
+}
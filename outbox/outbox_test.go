@@ -0,0 +1,96 @@
+package outbox
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		sendErr     error
+		force       bool
+		notDue      bool
+		wantRemoved int
+		wantAttempt int
+	}{
+		{name: "sent", wantRemoved: 1},
+		{name: "superseded", sendErr: ErrSuperseded, wantRemoved: 1},
+		{name: "rejected", sendErr: ErrRejected, wantRemoved: 1},
+		{name: "failed", sendErr: errors.New("connection refused"), wantAttempt: 1},
+		{name: "deferred", sendErr: ErrDeferred},
+		{name: "not due", notDue: true},
+		{name: "forced", notDue: true, force: true, wantRemoved: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "outbox")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			n := &Notification{PodName: "nginx", PodNamespace: "default", Zone: "default"}
+			if test.notDue {
+				n.Created = time.Now()
+				n.NextAttempt = n.Created.Add(time.Hour)
+			}
+			if err = Add(dir, n); err != nil {
+				t.Fatal(err)
+			}
+
+			sent := 0
+			removed, err := Drain(dir, test.force, func(n *Notification) error {
+				sent++
+				return test.sendErr
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if removed != test.wantRemoved {
+				t.Errorf("removed %d notifications, want %d", removed, test.wantRemoved)
+			}
+			if test.notDue && !test.force && sent != 0 {
+				t.Errorf("sent a notification that is not due")
+			}
+
+			left, err := List(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(left) != 1-test.wantRemoved {
+				t.Fatalf("%d notifications left, want %d", len(left), 1-test.wantRemoved)
+			}
+			if len(left) == 1 && left[0].Attempts != test.wantAttempt {
+				t.Errorf("got %d attempts, want %d", left[0].Attempts, test.wantAttempt)
+			}
+			if test.wantAttempt > 0 && !left[0].NextAttempt.After(time.Now()) {
+				t.Errorf("failed notification was not backed off")
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, InitialBackoff},
+		{2, 2 * InitialBackoff},
+		{4, 8 * InitialBackoff},
+		{100, MaxBackoff},
+	}
+
+	for _, test := range tests {
+		if got := backoff(test.attempts); got != test.want {
+			t.Errorf("backoff(%d) = %s, want %s", test.attempts, got, test.want)
+		}
+	}
+}
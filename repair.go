@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/nuagenetworks/nuage-cni/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultIfName is the pod interface name
+// used by container runtimes
+const defaultIfName = "eth0"
+
+// forceDetachPod removes the entity, port, alubr0 attachment, veth
+// and state record of a pod sandbox and notifies Nuage monitor. It
+// runs CNI DEL cleanup even when VRS entries are partially gone
+func forceDetachPod(target string) error {
+
+	vrsConnection, err := client.ConnectToVRSOVSDB(nuageCNIConfig)
+	if err != nil {
+		log.Errorf("Error connecting to VRS: %v", err)
+		return err
+	}
+	defer vrsConnection.Disconnect()
+
+	args, _, err := findPodSandbox(vrsConnection, target)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Force detaching sandbox %s of %s", args.ContainerID, target)
+	return detachEntity(vrsConnection, args, true)
+}
+
+// reResolvePod detaches an existing pod sandbox from Nuage network
+// and attaches it again so that VRS resolves its port afresh. The
+// port may get another IP that kubelet never learns about, in which
+// case the pod has to be restarted
+func reResolvePod(target string) error {
+
+	vrsConnection, err := client.ConnectToVRSOVSDB(nuageCNIConfig)
+	if err != nil {
+		log.Errorf("Error connecting to VRS: %v", err)
+		return err
+	}
+	defer vrsConnection.Disconnect()
+
+	args, state, err := findPodSandbox(vrsConnection, target)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("No pod state record for sandbox %s; network namespace of the sandbox is unknown", args.ContainerID)
+	}
+	if _, err = os.Stat(args.Netns); err != nil {
+		return fmt.Errorf("Network namespace %s of sandbox %s is gone: %v", args.Netns, args.ContainerID, err)
+	}
+
+	log.Infof("Re-resolving port of sandbox %s of %s", args.ContainerID, target)
+	if err = detachEntity(vrsConnection, args, true); err != nil {
+		return err
+	}
+	if err = networkConnect(vrsConnection, args); err != nil {
+		return err
+	}
+
+	ip := ""
+	if resolved, err := client.LoadPodState(nuageCNIConfig.StateDir, args.ContainerID); err == nil {
+		ip = resolved.IP
+	}
+	if ip != state.IP {
+		log.Warnf("Sandbox %s of %s got IP %q instead of %q", args.ContainerID, target, ip, state.IP)
+		fmt.Fprintf(os.Stderr, "WARNING: %s got IP %q instead of %q. Kubelet and K8S API server still report the old IP; restart the pod to use the new one\n",
+			target, ip, state.IP)
+	}
+	return nil
+}
+
+// findPodSandbox returns CNI arguments of a pod sandbox given as
+// <namespace>/<pod> or container ID, along with its pod state record.
+// Sandboxes without a state record are looked up among VRS entities
+func findPodSandbox(vrsConnection client.VRSConnection, target string) (*skel.CmdArgs, *client.PodState, error) {
+
+	if target == "" {
+		return nil, nil, fmt.Errorf("No pod or container ID given")
+	}
+
+	states, err := client.ListPodStates(nuageCNIConfig.StateDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error listing pod state records: %v", err)
+	}
+
+	namespace, name, byName := splitPodTarget(target)
+	matches := make(map[string]*client.PodState)
+	for _, state := range states {
+		if byName && state.PodNamespace == namespace && state.PodName == name ||
+			!byName && strings.HasPrefix(state.ContainerID, target) {
+			matches[state.ContainerID] = state
+		}
+	}
+
+	// Sandboxes known to VRS only have no state record
+	unrecorded := make(map[string]*client.PodState)
+	entities, err := vrsConnection.GetAllEntities()
+	if err != nil {
+		log.Warnf("Failed to get entity list from VRS: %v", err)
+	}
+	for _, id := range entities {
+		if _, ok := matches[id]; ok {
+			continue
+		}
+		entityName, err := vrsConnection.GetEntityName(id)
+		if err != nil {
+			log.Debugf("Error obtaining entity name from OVSDB: %v", err)
+			continue
+		}
+		if byName && entityName == client.GetEntityName(namespace, name) ||
+			!byName && strings.HasPrefix(id, target) {
+			state := &client.PodState{ContainerID: id, EntityName: entityName}
+			state.PodNamespace, state.PodName = client.ParseEntityName(entityName)
+			matches[id] = state
+			unrecorded[id] = state
+		}
+	}
+
+	var ids []string
+	for id := range matches {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, nil, fmt.Errorf("No sandbox of %s found in pod state records or VRS", target)
+	}
+	if len(ids) > 1 {
+		sort.Strings(ids)
+		return nil, nil, fmt.Errorf("%s matches sandboxes %s; give the container ID", target, strings.Join(ids, ", "))
+	}
+
+	state := matches[ids[0]]
+	args := &skel.CmdArgs{
+		ContainerID: state.ContainerID,
+		Netns:       state.Netns,
+		IfName:      state.IfName,
+		Args: strings.Join([]string{
+			"IgnoreUnknown=1",
+			"K8S_POD_NAMESPACE=" + state.PodNamespace,
+			"K8S_POD_NAME=" + state.PodName,
+			"K8S_POD_INFRA_CONTAINER_ID=" + state.ContainerID,
+			"K8S_POD_UID=" + state.PodUID,
+		}, ";"),
+	}
+	if args.IfName == "" {
+		args.IfName = defaultIfName
+	}
+
+	if _, ok := unrecorded[state.ContainerID]; ok {
+		return args, nil, nil
+	}
+	return args, state, nil
+}
+
+// splitPodTarget splits <namespace>/<pod> into its parts
+func splitPodTarget(target string) (string, string, bool) {
+
+	parts := strings.SplitN(target, "/", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}